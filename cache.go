@@ -0,0 +1,166 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"sync"
+	"time"
+)
+
+// DeviceInfoCache keeps the DeviceInfo of devices so they can be opened
+// without querying them first
+type DeviceInfoCache interface {
+	// Find returns the cached info for the device at address
+	Find(address Address) (info DeviceInfo, found bool)
+
+	// Update replaces the cached info for info.Address
+	Update(info DeviceInfo)
+}
+
+// OpenWithInfo creates the device described by info without sending anything
+// to it.  The info is lazily re-validated: the first time the device NAKs a
+// command it is queried for its engine version and device category, and if
+// either differs from info then the command returns ErrStaleInfo.  ErrStaleInfo
+// is also returned once the device announces (with a set button broadcast)
+// a different device category.  Once ErrStaleInfo has been returned the
+// device should be opened again
+func OpenWithInfo(conn Connection, timeout time.Duration, info DeviceInfo) (Device, error) {
+	return openWithInfo(conn, timeout, info, nil)
+}
+
+// OpenCached works like Open, except the device info is taken from the cache
+// when it is available.  Devices that aren't in the cache are queried and
+// the result is added to the cache.  Devices are re-validated the same way
+// as OpenWithInfo, and any corrected info is saved to the cache before
+// ErrStaleInfo is returned, so opening the device again uses the new info
+func OpenCached(conn Connection, timeout time.Duration, cache DeviceInfoCache) (device Device, err error) {
+	info, found := cache.Find(conn.Address())
+	if !found {
+		info, err = identify(conn)
+		if err == ErrNotLinked {
+			device, _ = New(VerI2Cs, conn, timeout)
+			return device, err
+		} else if err != nil {
+			return nil, err
+		}
+		cache.Update(info)
+	}
+	return openWithInfo(conn, timeout, info, cache)
+}
+
+func openWithInfo(conn Connection, timeout time.Duration, info DeviceInfo, cache DeviceInfoCache) (Device, error) {
+	return Devices.New(info, &infoConnection{Connection: conn, info: info, cache: cache}, timeout)
+}
+
+// infoConnection watches the traffic of a device that was opened with
+// previously known DeviceInfo and detects when that info is wrong
+type infoConnection struct {
+	Connection
+	cache DeviceInfoCache
+
+	mu        sync.Mutex
+	info      DeviceInfo
+	validated bool
+	stale     bool
+}
+
+func (ic *infoConnection) isStale() bool {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return ic.stale
+}
+
+// Send returns ErrStaleInfo if the message is NAKed and re-validating
+// the device shows that its info has changed
+func (ic *infoConnection) Send(msg *Message) (*Message, error) {
+	if ic.isStale() {
+		return nil, ErrStaleInfo
+	}
+
+	ack, err := ic.Connection.Send(msg)
+	if err == nil && ack.Nak() && ic.revalidate() {
+		err = ErrStaleInfo
+	}
+	return ack, err
+}
+
+// Receive checks set button broadcasts for a change in the
+// device category or firmware version
+func (ic *infoConnection) Receive() (*Message, error) {
+	msg, err := ic.Connection.Receive()
+	if err == nil && msg.Broadcast() && (msg.Command[1] == 0x01 || msg.Command[1] == 0x02) {
+		ic.mu.Lock()
+		info := ic.info
+		info.FirmwareVersion = FirmwareVersion(msg.Dst[2])
+		info.DevCat = DevCat{msg.Dst[0], msg.Dst[1]}
+		ic.compare(info)
+		ic.mu.Unlock()
+	}
+	return msg, err
+}
+
+// revalidate queries the device, once, and reports whether the
+// device info has changed.  ic.mu is not held during the queries so
+// that Receive isn't blocked while waiting for the device
+func (ic *infoConnection) revalidate() bool {
+	ic.mu.Lock()
+	if ic.validated {
+		stale := ic.stale
+		ic.mu.Unlock()
+		return stale
+	}
+	ic.validated = true
+	info := ic.info
+	ic.mu.Unlock()
+
+	version, err := ic.Connection.EngineVersion()
+	if err != nil && err != ErrNotLinked {
+		Log.Debugf("Failed to re-validate %v: %v", ic.Address(), err)
+		ic.mu.Lock()
+		ic.validated = false
+		ic.mu.Unlock()
+		return false
+	}
+	info.EngineVersion = version
+
+	// the engine version alone is enough to tell the info is wrong,
+	// so a failed ID request only leaves the device category as it was
+	if firmware, devCat, err := ic.Connection.IDRequest(); err == nil {
+		info.FirmwareVersion, info.DevCat = firmware, devCat
+	} else {
+		Log.Debugf("Failed to query device category for %v: %v", ic.Address(), err)
+	}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.compare(info)
+	return ic.stale
+}
+
+// compare marks the connection stale and updates the cache if info
+// doesn't match the info the device was opened with.  ic.mu must be
+// held by the caller
+func (ic *infoConnection) compare(info DeviceInfo) {
+	if info == ic.info {
+		return
+	}
+
+	Log.Infof("Device info for %v changed from %+v to %+v", ic.Address(), ic.info, info)
+	ic.info = info
+	ic.stale = true
+	if ic.cache != nil {
+		ic.cache.Update(info)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testInfoCache map[Address]DeviceInfo
+
+func (tic testInfoCache) Find(address Address) (DeviceInfo, bool) {
+	info, found := tic[address]
+	return info, found
+}
+
+func (tic testInfoCache) Update(info DeviceInfo) {
+	tic[info.Address] = info
+}
+
+func TestOpenWithInfo(t *testing.T) {
+	tests := []struct {
+		desc     string
+		input    DeviceInfo
+		wantType reflect.Type
+		wantErr  error
+	}{
+		{"I1Device", DeviceInfo{EngineVersion: VerI1}, reflect.TypeOf(&i1Device{}), nil},
+		{"Dimmer", DeviceInfo{EngineVersion: VerI1, DevCat: DevCat{1, 0}}, reflect.TypeOf(&dimmer{}), nil},
+		{"Linkable Switch", DeviceInfo{EngineVersion: VerI2Cs, DevCat: DevCat{2, 0}}, reflect.TypeOf(&linkableSwitch{}), nil},
+		{"ErrVersion", DeviceInfo{EngineVersion: 4}, reflect.TypeOf(nil), ErrVersion},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			// the connection has no channels, so any attempt
+			// to communicate with the device would block
+			device, err := OpenWithInfo(&testConnection{}, 0, test.input)
+			if err != test.wantErr {
+				t.Errorf("want err %v got %v", test.wantErr, err)
+			}
+
+			if gotType := reflect.TypeOf(device); test.wantType != gotType {
+				t.Errorf("want type %v got %v", test.wantType, gotType)
+			}
+		})
+	}
+}
+
+func TestOpenCached(t *testing.T) {
+	addr := Address{1, 2, 3}
+	tests := []struct {
+		desc      string
+		cached    *DeviceInfo
+		input     *testConnection
+		wantType  reflect.Type
+		wantErr   error
+		wantCache *DeviceInfo
+	}{
+		{"Cached", &DeviceInfo{Address: addr, EngineVersion: VerI2, DevCat: DevCat{1, 0}}, &testConnection{addr: addr, engineVersion: VerI1}, reflect.TypeOf(&linkableDimmer{}), nil, &DeviceInfo{Address: addr, EngineVersion: VerI2, DevCat: DevCat{1, 0}}},
+		{"Not Cached", nil, &testConnection{addr: addr, engineVersion: VerI2, devCat: DevCat{2, 0}, firmwareVersion: 0x45}, reflect.TypeOf(&linkableSwitch{}), nil, &DeviceInfo{Address: addr, EngineVersion: VerI2, DevCat: DevCat{2, 0}, FirmwareVersion: 0x45}},
+		{"Not Linked", nil, &testConnection{addr: addr, engineVersionErr: ErrNotLinked}, reflect.TypeOf(&i2CsDevice{}), ErrNotLinked, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cache := testInfoCache{}
+			if test.cached != nil {
+				cache.Update(*test.cached)
+			}
+
+			device, err := OpenCached(test.input, 0, cache)
+			if err != test.wantErr {
+				t.Errorf("want err %v got %v", test.wantErr, err)
+			}
+
+			if gotType := reflect.TypeOf(device); test.wantType != gotType {
+				t.Errorf("want type %v got %v", test.wantType, gotType)
+			}
+
+			info, found := cache.Find(addr)
+			if test.wantCache == nil {
+				if found {
+					t.Errorf("want nothing cached got %+v", info)
+				}
+			} else if *test.wantCache != info {
+				t.Errorf("want cached %+v got %+v", *test.wantCache, info)
+			}
+		})
+	}
+}
+
+func TestInfoConnectionSend(t *testing.T) {
+	addr := Address{1, 2, 3}
+	cached := DeviceInfo{Address: addr, EngineVersion: VerI2, DevCat: DevCat{1, 0}, FirmwareVersion: 0x45}
+	ack := &Message{Flags: StandardDirectAck}
+	nak := &Message{Flags: StandardDirectNak, Command: Command{0x00, 0x11, 0xfd}}
+
+	tests := []struct {
+		desc      string
+		ack       *Message
+		version   EngineVersion
+		devCat    DevCat
+		wantErr   error
+		wantCache DeviceInfo
+	}{
+		{"Ack", ack, VerI2Cs, DevCat{1, 0}, nil, cached},
+		{"Nak Unchanged", nak, VerI2, DevCat{1, 0}, nil, cached},
+		{"Nak Engine Version", nak, VerI2Cs, DevCat{1, 0}, ErrStaleInfo, DeviceInfo{Address: addr, EngineVersion: VerI2Cs, DevCat: DevCat{1, 0}, FirmwareVersion: 0x45}},
+		{"Nak DevCat", nak, VerI2, DevCat{2, 0}, ErrStaleInfo, DeviceInfo{Address: addr, EngineVersion: VerI2, DevCat: DevCat{2, 0}, FirmwareVersion: 0x45}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cache := testInfoCache{addr: cached}
+			conn := &testConnection{addr: addr, engineVersion: test.version, devCat: test.devCat, firmwareVersion: 0x45, sendCh: make(chan *Message, 2), ackCh: make(chan *Message, 2)}
+			ic := &infoConnection{Connection: conn, info: cached, cache: cache}
+
+			conn.ackCh <- test.ack
+			if _, err := ic.Send(&Message{}); err != test.wantErr {
+				t.Errorf("want err %v got %v", test.wantErr, err)
+			}
+
+			if cache[addr] != test.wantCache {
+				t.Errorf("want cached %+v got %+v", test.wantCache, cache[addr])
+			}
+
+			// once stale, nothing more is sent to the device
+			conn.ackCh <- ack
+			_, err := ic.Send(&Message{})
+			if test.wantErr == ErrStaleInfo && err != ErrStaleInfo {
+				t.Errorf("want err %v got %v", ErrStaleInfo, err)
+			} else if test.wantErr == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestInfoConnectionReceive(t *testing.T) {
+	addr := Address{1, 2, 3}
+	cached := DeviceInfo{Address: addr, EngineVersion: VerI2, DevCat: DevCat{1, 0}, FirmwareVersion: 0x45}
+
+	tests := []struct {
+		desc      string
+		input     *Message
+		wantStale bool
+	}{
+		{"Same DevCat", &Message{Src: addr, Dst: Address{1, 0, 0x45}, Flags: StandardBroadcast, Command: CmdSetButtonPressedController}, false},
+		{"New DevCat", &Message{Src: addr, Dst: Address{2, 0, 0x45}, Flags: StandardBroadcast, Command: CmdSetButtonPressedResponder}, true},
+		{"Direct Message", &Message{Src: addr, Dst: Address{2, 0, 0x45}, Flags: StandardDirectMessage, Command: Command{0x00, 0x01, 0x00}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cache := testInfoCache{addr: cached}
+			conn := &testConnection{addr: addr, recvCh: make(chan *Message, 1)}
+			ic := &infoConnection{Connection: conn, info: cached, cache: cache}
+
+			conn.recvCh <- test.input
+			if _, err := ic.Receive(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ic.isStale() != test.wantStale {
+				t.Errorf("want stale %v got %v", test.wantStale, ic.isStale())
+			}
+
+			if got := cache[addr] != cached; got != test.wantStale {
+				t.Errorf("want cache updated %v got %v", test.wantStale, got)
+			}
+		})
+	}
+}
+
+// queryingConnection blocks EngineVersion until release is closed
+type queryingConnection struct {
+	*testConnection
+	querying chan struct{}
+	release  chan struct{}
+}
+
+func (qc *queryingConnection) EngineVersion() (EngineVersion, error) {
+	close(qc.querying)
+	<-qc.release
+	return qc.testConnection.EngineVersion()
+}
+
+func TestInfoConnectionRevalidateReceive(t *testing.T) {
+	addr := Address{1, 2, 3}
+	cached := DeviceInfo{Address: addr, EngineVersion: VerI2, DevCat: DevCat{1, 0}, FirmwareVersion: 0x45}
+	conn := &queryingConnection{
+		testConnection: &testConnection{addr: addr, engineVersion: VerI2, devCat: DevCat{1, 0}, firmwareVersion: 0x45, sendCh: make(chan *Message, 1), ackCh: make(chan *Message, 1), recvCh: make(chan *Message, 1)},
+		querying:       make(chan struct{}),
+		release:        make(chan struct{}),
+	}
+	ic := &infoConnection{Connection: conn, info: cached, cache: testInfoCache{addr: cached}}
+
+	conn.ackCh <- &Message{Flags: StandardDirectNak, Command: Command{0x00, 0x11, 0xfd}}
+	sent := make(chan error, 1)
+	go func() {
+		_, err := ic.Send(&Message{})
+		sent <- err
+	}()
+	<-conn.querying
+
+	// messages from the device are still received while it is being queried
+	conn.recvCh <- &Message{Src: addr, Dst: Address{1, 0, 0x45}, Flags: StandardBroadcast, Command: CmdSetButtonPressedController}
+	received := make(chan error, 1)
+	go func() {
+		_, err := ic.Receive()
+		received <- err
+	}()
+
+	select {
+	case err := <-received:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Receive blocked while the device was re-validated")
+	}
+
+	close(conn.release)
+	if err := <-sent; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ic.isStale() {
+		t.Errorf("want connection not stale")
+	}
+}
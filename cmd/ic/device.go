@@ -30,7 +30,7 @@ import (
 
 func init() {
 	d := &device{}
-	cmd := app.SubCommand("device", cli.UsageOption("<device id> <command>"), cli.DescOption("Interact with a specific device"), cli.CallbackOption(d.init))
+	cmd := app.SubCommand("device", cli.UsageOption("<device id> <command>"), cli.DescOption("Interact with a specific device"), cli.CallbackOption(withModem(d.init)))
 	cmd.Arguments.Var(&d.addr, "<device id>")
 	cmd.SubCommand("info", cli.DescOption("retrieve device info"), cli.CallbackOption(d.infoCmd))
 	cmd.SubCommand("link", cli.DescOption("enter linking mode"), cli.CallbackOption(d.linkCmd))
@@ -176,64 +176,69 @@ func (dev *device) versionCmd() error {
 }
 
 func (dev *device) editCmd() error {
-	return isLinkable(dev.Device, func(linkable insteon.Linkable) error {
-		dbLinks, _ := linkable.Links()
-		if len(dbLinks) == 0 {
-			return fmt.Errorf("No links to edit")
-		}
+	return isLinkable(dev.Device, editLinks)
+}
 
-		tmpfile, err := ioutil.TempFile("", "insteon_")
-		if err != nil {
-			return err
-		}
-		defer os.Remove(tmpfile.Name())
-
-		buf := bytes.NewBuffer(nil)
-		fmt.Fprintf(buf, "#\n")
-		fmt.Fprintf(buf, "# Lines beginning with a # are ignored\n")
-		fmt.Fprintf(buf, "# DO NOT delete lines, this will cause the entries to\n")
-		fmt.Fprintf(buf, "# shift up and then the last entry will be in the database twice\n")
-		fmt.Fprintf(buf, "# To delete a record simply mark it 'Available' by changing the\n")
-		fmt.Fprintf(buf, "# first letter of the Flags to 'A'\n")
-		fmt.Fprintf(buf, "#\n")
-		fmt.Fprintf(buf, "# Flags Group Address    Data\n")
-		for _, link := range dbLinks {
-			output, _ := link.MarshalText()
-			fmt.Fprintf(buf, "  %s\n", string(output))
-		}
+// editLinks opens the link database in an editor and writes any
+// changes back to the device
+func editLinks(linkable insteon.Linkable) error {
+	dbLinks, _ := linkable.Links()
+	if len(dbLinks) == 0 {
+		return fmt.Errorf("No links to edit")
+	}
+
+	tmpfile, err := ioutil.TempFile("", "insteon_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "#\n")
+	fmt.Fprintf(buf, "# Lines beginning with a # are ignored\n")
+	fmt.Fprintf(buf, "# DO NOT delete lines, this will cause the entries to\n")
+	fmt.Fprintf(buf, "# shift up and then the last entry will be in the database twice\n")
+	fmt.Fprintf(buf, "# To delete a record simply mark it 'Available' by changing the\n")
+	fmt.Fprintf(buf, "# first letter of the Flags to 'A'\n")
+	fmt.Fprintf(buf, "#\n")
+	fmt.Fprintf(buf, "# Flags Group Address    Data\n")
+	for _, link := range dbLinks {
+		output, _ := link.MarshalText()
+		fmt.Fprintf(buf, "  %s\n", string(output))
+	}
+
+	tmpfile.Write(buf.Bytes())
 
-		tmpfile.Write(buf.Bytes())
-
-		if err = tmpfile.Close(); err == nil {
-			cmd := exec.Command(editor, tmpfile.Name())
-			cmd.Stdin = os.Stdin
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Start()
-			err = cmd.Wait()
-			if err == nil {
-				dbLinks = nil
-				input, err := ioutil.ReadFile(tmpfile.Name())
-				if err == nil && !bytes.Equal(buf.Bytes(), input) {
-					for _, line := range bytes.Split(input, []byte("\n")) {
-						line = bytes.TrimSpace(line)
-						if len(line) == 0 || bytes.Index(line, []byte("#")) == 0 {
-							continue
-						}
-						link := &insteon.LinkRecord{}
-						err = link.UnmarshalText(line)
-						if err == nil {
-							dbLinks = append(dbLinks, link)
-						} else {
-							fmt.Printf("Skipping invalid line %q: %v\n", string(line), err)
-						}
+	if err = tmpfile.Close(); err == nil {
+		cmd := exec.Command(editor, tmpfile.Name())
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Start()
+		err = cmd.Wait()
+		if err == nil {
+			dbLinks = nil
+			var input []byte
+			input, err = ioutil.ReadFile(tmpfile.Name())
+			if err == nil && !bytes.Equal(buf.Bytes(), input) {
+				for _, line := range bytes.Split(input, []byte("\n")) {
+					line = bytes.TrimSpace(line)
+					if len(line) == 0 || bytes.Index(line, []byte("#")) == 0 {
+						continue
+					}
+					link := &insteon.LinkRecord{}
+					err = link.UnmarshalText(line)
+					if err == nil {
+						dbLinks = append(dbLinks, link)
+					} else {
+						fmt.Printf("Skipping invalid line %q: %v\n", string(line), err)
 					}
-					linkable.WriteLinks(dbLinks...)
 				}
+				err = linkable.WriteLinks(dbLinks...)
 			}
 		}
-		return err
-	})
+	}
+	return err
 }
 
 func (dev *device) sendCmd() error {
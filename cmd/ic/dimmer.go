@@ -32,7 +32,7 @@ type dimmer struct {
 func init() {
 	dim := &dimmer{}
 
-	dimCmd := app.SubCommand("dimmer", cli.UsageOption("<device id> <command>"), cli.DescOption("Interact with a specific dimmer"), cli.CallbackOption(dim.init))
+	dimCmd := app.SubCommand("dimmer", cli.UsageOption("<device id> <command>"), cli.DescOption("Interact with a specific dimmer"), cli.CallbackOption(withModem(dim.init)))
 	dimCmd.Arguments.Var(&dim.addr, "<device id>")
 
 	dimCmd.SubCommand("config", cli.DescOption("retrieve dimmer configuration information"), cli.CallbackOption(dim.configCmd))
@@ -22,7 +22,7 @@ import (
 )
 
 func init() {
-	app.SubCommand("monitor", cli.DescOption("Monitor the Insteon network"), cli.CallbackOption(monCmd))
+	app.SubCommand("monitor", cli.DescOption("Monitor the Insteon network"), cli.CallbackOption(withModem(monCmd)))
 }
 
 func monCmd() (err error) {
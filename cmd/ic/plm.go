@@ -17,6 +17,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/abates/cli"
@@ -61,14 +62,58 @@ func (a *addresses) Set(str string) error {
 type plmCmd struct {
 	*plm.PLM
 	addresses addresses
+	group     int
+	cmd       cmd
+	devCat    insteon.DevCat
+	data      data
+	settings  configSettings
+}
+
+// configSettings is a list of key=value PLM config settings
+type configSettings []string
+
+var configSetters = map[string]func(*plm.Config, bool){
+	"autolink": (*plm.Config).SetAutomaticLinking,
+	"monitor":  (*plm.Config).SetMonitorMode,
+	"autoled":  (*plm.Config).SetAutomaticLED,
+	"deadman":  (*plm.Config).SetDeadmanMode,
+}
+
+func (cs *configSettings) Set(str string) error {
+	kv := strings.Split(str, "=")
+	if len(kv) != 2 {
+		return fmt.Errorf("expected key=value got %q", str)
+	}
+
+	if _, found := configSetters[kv[0]]; !found {
+		return fmt.Errorf("unknown config key %q, valid keys are {autolink|monitor|autoled|deadman}", kv[0])
+	}
+
+	if _, err := strconv.ParseBool(kv[1]); err != nil {
+		return fmt.Errorf("invalid value %q for %s: %v", kv[1], kv[0], err)
+	}
+	*cs = append(*cs, str)
+	return nil
+}
+
+func (cs *configSettings) String() string { return strings.Join(*cs, " ") }
+
+// apply updates the config with the settings
+func (cs configSettings) apply(config *plm.Config) {
+	for _, setting := range cs {
+		kv := strings.Split(setting, "=")
+		value, _ := strconv.ParseBool(kv[1])
+		configSetters[kv[0]](config, value)
+	}
 }
 
 func init() {
 	p := &plmCmd{PLM: modem}
 
-	pc := app.SubCommand("plm", cli.DescOption("Interact with the PLM"))
+	pc := app.SubCommand("plm", cli.DescOption("Interact with the PLM"), cli.CallbackOption(withModem(nil)))
 	pc.SubCommand("info", cli.DescOption("display information (device id, link database, etc)"), cli.CallbackOption(p.infoCmd))
 	pc.SubCommand("reset", cli.DescOption("Factory reset the IM"), cli.CallbackOption(p.resetCmd))
+	pc.SubCommand("edit", cli.DescOption("edit the PLM all-link database"), cli.CallbackOption(p.editCmd))
 
 	cmd := pc.SubCommand("link", cli.UsageOption("<device id>,..."), cli.DescOption("Link (as a controller) the PLM to one or more devices. Device IDs must be comma separated"), cli.CallbackOption(p.linkCmd))
 	cmd.Arguments.VarSlice((*addrList)(&p.addresses), "<device id>,...")
@@ -79,6 +124,31 @@ func init() {
 	cmd = pc.SubCommand("crosslink", cli.UsageOption("<device id>,..."), cli.DescOption("Crosslink the PLM to one or more devices. Device IDs must be comma separated"), cli.CallbackOption(p.crossLinkCmd))
 	cmd.Arguments.VarSlice((*addrList)(&p.addresses), "<device id>,...")
 
+	cmd = pc.SubCommand("group", cli.UsageOption("<group> <cmd1>.<cmd2>"), cli.DescOption("Send a command from the PLM to all responders in a group"), cli.CallbackOption(p.groupCmd))
+	cmd.Arguments.Int(&p.group, "<group>")
+	cmd.Arguments.Var(&p.cmd, "<cmd1>.<cmd2>")
+
+	configCmd := pc.SubCommand("config", cli.UsageOption("<show|set>"), cli.DescOption("Display or change the PLM configuration"))
+	configCmd.SubCommand("show", cli.DescOption("display the PLM configuration"), cli.CallbackOption(p.configShowCmd))
+	cmd = configCmd.SubCommand("set", cli.UsageOption("<key>=<value> ..."), cli.DescOption("change one or more settings (autolink, monitor, autoled, deadman)"), cli.CallbackOption(p.configSetCmd))
+	cmd.Arguments.VarSlice(&p.settings, "<key>=<value> ...")
+
+	cmd = pc.SubCommand("category", cli.UsageOption("<category>.<subcategory>"), cli.DescOption("Set the device category the PLM reports to other devices"), cli.CallbackOption(p.categoryCmd))
+	cmd.Arguments.Var(&p.devCat, "<category>.<subcategory>")
+
+	pc.SubCommand("lastlink", cli.DescOption("display the All-Link record matching the sender of the last received message"), cli.CallbackOption(p.lastLinkCmd))
+	pc.SubCommand("sleep", cli.DescOption("Put the PLM's radio to sleep until the next command"), cli.CallbackOption(p.sleepCmd))
+
+	ledCmd := pc.SubCommand("led", cli.UsageOption("<on|off>"), cli.DescOption("Turn the PLM's LED on or off"))
+	ledCmd.SubCommand("on", cli.DescOption("turn the LED on"), cli.CallbackOption(p.ledOnCmd))
+	ledCmd.SubCommand("off", cli.DescOption("turn the LED off"), cli.CallbackOption(p.ledOffCmd))
+
+	cmd = pc.SubCommand("ackbyte", cli.UsageOption("[<cmd1>] <cmd2>"), cli.DescOption("Set the command byte(s) the PLM sends when it ACKs a direct message"), cli.CallbackOption(p.ackByteCmd))
+	cmd.Arguments.VarSlice(&p.data, "[<cmd1>] <cmd2>")
+
+	cmd = pc.SubCommand("nakbyte", cli.UsageOption("<cmd2>"), cli.DescOption("Set the command 2 byte the PLM sends when it NAKs a direct message"), cli.CallbackOption(p.nakByteCmd))
+	cmd.Arguments.VarSlice(&p.data, "<cmd2>")
+
 	cmd = pc.SubCommand("alllink", cli.UsageOption("<device id>,..."), cli.DescOption("Put the PLM into linking mode for manual linking. Device IDs must be comma separated"), cli.CallbackOption(p.allLinkCmd))
 	cmd.Arguments.VarSlice((*addrList)(&p.addresses), "<device id>,...")
 }
@@ -105,6 +175,10 @@ func (p *plmCmd) infoCmd() (err error) {
 	return err
 }
 
+func (p *plmCmd) editCmd() error {
+	return isLinkable(modem, editLinks)
+}
+
 func (p *plmCmd) linkCmd() error      { return p.link(false) }
 func (p *plmCmd) crossLinkCmd() error { return p.link(true) }
 
@@ -119,16 +193,22 @@ func (p *plmCmd) link(crosslink bool) error {
 			}
 
 			if err == nil {
+				var links []*insteon.LinkRecord
 				err = isLinkable(device, func(ldevice insteon.Linkable) (err error) {
-					err = util.ForceLink(group, lmodem, ldevice)
+					link, err := util.ForceLink(group, lmodem, ldevice)
+					links = append(links, link)
 					if err == nil && crosslink {
-						err = util.ForceLink(group, ldevice, lmodem)
+						link, err = util.ForceLink(group, ldevice, lmodem)
+						links = append(links, link)
 					}
 					return err
 				})
 
 				if err == nil {
 					fmt.Printf("done\n")
+					for _, link := range links {
+						fmt.Printf("    %v\n", link)
+					}
 				} else {
 					fmt.Printf("failed: %v\n", err)
 				}
@@ -141,6 +221,77 @@ func (p *plmCmd) link(crosslink bool) error {
 	})
 }
 
+func (p *plmCmd) groupCmd() error {
+	if p.group < 1 || p.group > 255 {
+		return fmt.Errorf("group must be between 1 and 255")
+	}
+
+	failed, err := modem.SendGroupCommand(insteon.Group(p.group), p.cmd.Command)
+	for _, addr := range failed {
+		fmt.Printf("%s did not respond to the group command\n", addr)
+	}
+	return err
+}
+
+func (p *plmCmd) configShowCmd() error {
+	config, err := modem.Config()
+	if err == nil {
+		fmt.Printf("Automatic Linking: %v\n", config.AutomaticLinking())
+		fmt.Printf("     Monitor Mode: %v\n", config.MonitorMode())
+		fmt.Printf("    Automatic LED: %v\n", config.AutomaticLED())
+		fmt.Printf("     Deadman Mode: %v\n", config.DeadmanMode())
+	}
+	return err
+}
+
+func (p *plmCmd) configSetCmd() error {
+	config, err := modem.Config()
+	if err == nil {
+		p.settings.apply(&config)
+		err = modem.SetConfig(config)
+	}
+
+	if err == nil {
+		err = p.configShowCmd()
+	}
+	return err
+}
+
+func (p *plmCmd) categoryCmd() error {
+	return modem.SetDeviceCategory(p.devCat, 0xff)
+}
+
+func (p *plmCmd) lastLinkCmd() error {
+	link, err := modem.LinkForLastSender()
+	if err == nil {
+		fmt.Printf("%v\n", link)
+	} else if err == plm.ErrNak {
+		err = fmt.Errorf("no link record matches the last sender")
+	}
+	return err
+}
+
+func (p *plmCmd) sleepCmd() error  { return modem.RFSleep() }
+func (p *plmCmd) ledOnCmd() error  { return modem.LEDOn() }
+func (p *plmCmd) ledOffCmd() error { return modem.LEDOff() }
+
+func (p *plmCmd) ackByteCmd() error {
+	switch len(p.data) {
+	case 1:
+		return modem.SetAckMessageByte(p.data[0])
+	case 2:
+		return modem.SetAckMessageBytes(p.data[0], p.data[1])
+	}
+	return fmt.Errorf("expected one or two bytes")
+}
+
+func (p *plmCmd) nakByteCmd() error {
+	if len(p.data) != 1 {
+		return fmt.Errorf("expected exactly one byte")
+	}
+	return modem.SetNakMessageByte(p.data[0])
+}
+
 func (p *plmCmd) allLinkCmd() error {
 	return isLinkable(modem, func(linkable insteon.Linkable) error {
 		return linkable.EnterLinkingMode(insteon.Group(0x01))
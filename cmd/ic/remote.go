@@ -0,0 +1,100 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/abates/cli"
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/util"
+)
+
+type remote struct {
+	addr       insteon.Address
+	button     int
+	mode       insteon.ButtonMode
+	group      int
+	responders addresses
+}
+
+func init() {
+	r := &remote{}
+
+	remoteCmd := app.SubCommand("remote", cli.UsageOption("<device id> <command>"), cli.DescOption("Interact with a mini remote or other controller-only device"), cli.CallbackOption(withModem(nil)))
+	remoteCmd.Arguments.Var(&r.addr, "<device id>")
+	remoteCmd.SubCommand("monitor", cli.DescOption("print button events received from the remote"), cli.CallbackOption(r.monitorCmd))
+
+	cmd := remoteCmd.SubCommand("mode", cli.UsageOption("<button> <toggle|onoff>"), cli.DescOption("set the button mode (the remote must be awake)"), cli.CallbackOption(r.modeCmd))
+	cmd.Arguments.Int(&r.button, "<button>")
+	cmd.Arguments.Var(&r.mode, "<toggle|onoff>")
+
+	cmd = remoteCmd.SubCommand("link", cli.UsageOption("<button> <device id>,..."), cli.DescOption("add responder links for the button to one or more devices. Device IDs must be comma separated"), cli.CallbackOption(r.linkCmd))
+	cmd.Arguments.Int(&r.group, "<button>")
+	cmd.Arguments.VarSlice((*addrList)(&r.responders), "<device id>,...")
+}
+
+func (r *remote) monitorCmd() (err error) {
+	log.Printf("Waiting for button events from %s...", r.addr)
+	conn, err := modem.Connect(r.addr, insteon.ConnectionTimeout(timeoutFlag))
+	if err == nil {
+		var msg *insteon.Message
+		for msg, err = conn.Receive(); err == nil || err == insteon.ErrReadTimeout; msg, err = conn.Receive() {
+			if err == nil {
+				if event, ok := insteon.DecodeButtonEvent(msg); ok {
+					log.Printf("%s", event)
+				}
+			}
+		}
+	}
+	return err
+}
+
+func (r *remote) modeCmd() error {
+	device, err := connect(modem, r.addr)
+	if err == nil {
+		if rem, ok := device.(insteon.Remote); ok {
+			err = rem.SetButtonMode(r.button, r.mode)
+		} else {
+			err = fmt.Errorf("Device at %s is a %T not a remote", r.addr, device)
+		}
+	}
+	return err
+}
+
+func (r *remote) linkCmd() error {
+	if r.group < 1 || r.group > 255 {
+		return fmt.Errorf("button must be between 1 and 255")
+	}
+
+	for _, addr := range r.responders {
+		fmt.Printf("Linking %s to %s...", addr, r.addr)
+		device, err := connect(modem, addr)
+		if err == nil {
+			err = isLinkable(device, func(linkable insteon.Linkable) error {
+				return util.LinkResponders(insteon.Group(r.group), r.addr, linkable)
+			})
+		}
+
+		if err == nil {
+			fmt.Printf("done\n")
+		} else {
+			fmt.Printf("failed: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/abates/cli"
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+type replayCmd struct {
+	file  string
+	speed float64
+}
+
+func init() {
+	r := &replayCmd{}
+	cmd := app.SubCommand("replay", cli.UsageOption("<capture file>"), cli.DescOption("Play back a capture recorded with -record and print the traffic received by the PLM"), cli.CallbackOption(r.run))
+	cmd.Flags.Float64Var(&r.speed, "speed", 1, "playback speed relative to the recording (0 plays back without delays)")
+	cmd.Arguments.String(&r.file, "<capture file>")
+}
+
+func (r *replayCmd) run() error {
+	file, err := os.Open(r.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	replay, err := plm.NewReplay(file, plm.IgnoreWrites(), plm.ReplaySpeed(r.speed))
+	if err != nil {
+		return err
+	}
+
+	err = openModem(plm.NewPort(replay, timeoutFlag))
+	if err != nil {
+		return err
+	}
+
+	events := make(chan plm.Event, 16)
+	modem.Subscribe(events)
+	conn, err := modem.Monitor()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			msg, err := conn.Receive()
+			if err == nil {
+				log.Printf("%s", msg)
+			} else if err != insteon.ErrReadTimeout {
+				return
+			}
+		}
+	}()
+
+	// once the capture has been read, give the PLM a moment to
+	// dispatch whatever is left before printing the stats
+	done := replay.Done()
+	var finished <-chan time.Time
+	for {
+		select {
+		case event := <-events:
+			log.Printf("%v", event)
+		case <-done:
+			done = nil
+			finished = time.After(100 * time.Millisecond)
+		case <-finished:
+			log.Printf("Replay complete: %+v", modem.Stats())
+			return nil
+		}
+	}
+}
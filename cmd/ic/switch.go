@@ -30,7 +30,7 @@ type swtch struct {
 func init() {
 	sw := swtch{}
 
-	swCmd := app.SubCommand("switch", cli.UsageOption("<device id> <command>"), cli.DescOption("Interact with a specific switch"), cli.CallbackOption(sw.init))
+	swCmd := app.SubCommand("switch", cli.UsageOption("<device id> <command>"), cli.DescOption("Interact with a specific switch"), cli.CallbackOption(withModem(sw.init)))
 	swCmd.Arguments.Var(&sw.addr, "<device id>")
 	swCmd.SubCommand("config", cli.DescOption("retrieve switch configuration information"), cli.CallbackOption(sw.switchConfigCmd))
 	swCmd.SubCommand("on", cli.DescOption("turn the switch/light on"), cli.CallbackOption(sw.switchOnCmd))
@@ -0,0 +1,53 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+
+	"github.com/abates/cli"
+	"github.com/abates/insteon/plm"
+)
+
+type x10 struct {
+	addr plm.X10Address
+	cmd  plm.X10Command
+}
+
+func init() {
+	x := &x10{}
+
+	x10Cmd := app.SubCommand("x10", cli.UsageOption("<command>"), cli.DescOption("Send and receive X10 commands through the PLM"), cli.CallbackOption(withModem(nil)))
+	cmd := x10Cmd.SubCommand("send", cli.UsageOption("<address> <on|off|dim|bright|alloff|allon>"), cli.DescOption("send an X10 command to an address (e.g. A1)"), cli.CallbackOption(x.sendCmd))
+	cmd.Arguments.Var(&x.addr, "<address>")
+	cmd.Arguments.Var(&x.cmd, "<command>")
+
+	x10Cmd.SubCommand("monitor", cli.DescOption("print X10 commands received by the PLM"), cli.CallbackOption(x.monitorCmd))
+}
+
+func (x *x10) sendCmd() error {
+	return modem.SendX10(x.addr, x.cmd)
+}
+
+func (x *x10) monitorCmd() (err error) {
+	log.Printf("Waiting for X10 commands...")
+	var event plm.X10Event
+	for event, err = modem.ReceiveX10(); err == nil || err == plm.ErrReadTimeout; event, err = modem.ReceiveX10() {
+		if err == nil {
+			log.Printf("%s", event)
+		}
+	}
+	return err
+}
@@ -200,6 +200,21 @@ var (
 	CmdLightOffAtRampV67 = Command{0x00, 0x35, 0x00} // Light Off At Ramp
 )
 
+// Energy Management Standard Direct Messages
+var (
+	// CmdIMeterReset resets the accumulated energy usage of an iMeter
+	CmdIMeterReset = Command{0x00, 0x80, 0x00} // iMeter Reset
+
+	// CmdIMeterQuery requests the current power and accumulated energy usage of an iMeter
+	CmdIMeterQuery = Command{0x00, 0x82, 0x00} // iMeter Query
+)
+
+// Energy Management Extended Messages
+var (
+	// CmdIMeterReport is sent by an iMeter in response to a query or as a periodic status report
+	CmdIMeterReport = Command{0x01, 0x82, 0x00} // iMeter Report
+)
+
 var cmdStrings = map[Command]string{
 	CmdAssignToAllLinkGroup:       "Assign to All-Link Group",
 	CmdDeleteFromAllLinkGroup:     "Delete from All-Link Group",
@@ -258,4 +273,7 @@ var cmdStrings = map[Command]string{
 	CmdLightOnAtRampV67:           "Light On At Ramp",
 	CmdLightOffAtRamp:             "Light Off At Ramp",
 	CmdLightOffAtRampV67:          "Light Off At Ramp",
+	CmdIMeterReset:                "iMeter Reset",
+	CmdIMeterQuery:                "iMeter Query",
+	CmdIMeterReport:               "iMeter Report",
 }
@@ -45,14 +45,18 @@ type demux struct {
 	connections map[Address]*connection
 }
 
+// Dispatch delivers msg to the connection for the sender of the message
+// and to the Wildcard connection, if either exists.  Dispatch never blocks,
+// connections that aren't reading their messages lose the oldest ones
 func (d *demux) Dispatch(msg *Message) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	for addr, conn := range d.connections {
-		if addr == Wildcard || addr == msg.Src {
-			conn.dispatch(msg)
-			break
-		}
+	if conn, found := d.connections[Wildcard]; found {
+		conn.dispatch(msg)
+	}
+
+	if conn, found := d.connections[msg.Src]; found && msg.Src != Wildcard {
+		conn.dispatch(msg)
 	}
 }
 
@@ -107,6 +111,10 @@ type Connection interface {
 	Unlock()
 }
 
+// connectionQueueLen is the number of received messages a connection
+// holds before it starts dropping the oldest
+const connectionQueueLen = 8
+
 type connection struct {
 	*sync.Mutex
 
@@ -178,7 +186,7 @@ func newConnection(upstream Sender, addr Address, options ...ConnectionOption) (
 		timeout: 3 * time.Second,
 
 		upstream: upstream,
-		msgCh:    make(chan *Message, 1),
+		msgCh:    make(chan *Message, connectionQueueLen),
 		closeCh:  make(chan chan error),
 	}
 
@@ -202,12 +210,30 @@ func (conn *connection) dispatch(msg *Message) {
 		for _, m := range conn.match {
 			if (msg.Command == m) || (msg.Command[1] == m[1] && m[2] == 0x00) {
 				Log.Tracef("Connection %v RX %v", conn.addr, msg)
-				conn.msgCh <- msg
+				conn.deliver(msg)
 			}
 		}
 	} else {
 		Log.Tracef("Connection %v RX %v", conn.addr, msg)
-		conn.msgCh <- msg
+		conn.deliver(msg)
+	}
+}
+
+// deliver queues msg to be received without blocking. If the queue
+// is full then the oldest message is dropped to make room
+func (conn *connection) deliver(msg *Message) {
+	for {
+		select {
+		case conn.msgCh <- msg:
+			return
+		default:
+		}
+
+		select {
+		case dropped := <-conn.msgCh:
+			Log.Debugf("Connection %v is not receiving, dropping %v", conn.addr, dropped)
+		default:
+		}
 	}
 }
 
@@ -192,6 +192,52 @@ func TestConnectionReceive(t *testing.T) {
 	}
 }
 
+func TestDemuxDispatch(t *testing.T) {
+	src := Address{1, 2, 3}
+	tests := []struct {
+		desc         string
+		addrs        []Address
+		wantReceived []Address
+	}{
+		{"Sender", []Address{src}, []Address{src}},
+		{"Wildcard", []Address{Wildcard}, []Address{Wildcard}},
+		{"Both", []Address{Wildcard, src}, []Address{Wildcard, src}},
+		{"Other", []Address{{4, 5, 6}}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewDemux(&testSender{})
+			conns := make(map[Address]Connection)
+			for _, addr := range test.addrs {
+				conns[addr], _ = d.New(addr, ConnectionTimeout(time.Millisecond))
+			}
+
+			// nothing is reading the connections, so dispatching
+			// more than they can hold must not block
+			for i := 0; i <= connectionQueueLen; i++ {
+				d.Dispatch(&Message{Src: src, Command: Command{0x00, 0x11, byte(i)}})
+			}
+
+			var received []Address
+			for _, addr := range test.addrs {
+				msg, err := conns[addr].Receive()
+				if err == nil {
+					received = append(received, addr)
+					// the oldest message was dropped
+					if msg.Command[2] != 1 {
+						t.Errorf("%v: want oldest message 1 got %d", addr, msg.Command[2])
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(test.wantReceived, received) {
+				t.Errorf("want %v to receive got %v", test.wantReceived, received)
+			}
+		})
+	}
+}
+
 func TestConnectionIDRequest(t *testing.T) {
 	sender := &testSender{}
 	conn, _ := newConnection(sender, Address{}, ConnectionTimeout(time.Millisecond))
@@ -0,0 +1,132 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"fmt"
+	"time"
+)
+
+func init() {
+	Devices.Register(0x0e, coverFactory)
+}
+
+func coverFactory(info DeviceInfo, device Device, timeout time.Duration) (Device, error) {
+	return NewCover(device, timeout), nil
+}
+
+// Extended Get/Set data IDs (D2) used to configure window covering
+// modules
+const (
+	coverTravelTime = 0x07
+	coverDirection  = 0x0b
+)
+
+// Cover is a window covering device such as the Micro Open/Close module.
+// The cover methods are named OpenCover/CloseCover so that a Cover can never
+// be mistaken for an io.Closer
+type Cover interface {
+	Device
+
+	// OpenCover will fully open the cover
+	OpenCover() error
+
+	// CloseCover will fully close the cover
+	CloseCover() error
+
+	// StopCover will stop the cover's motor wherever it currently is
+	StopCover() error
+
+	// SetPosition moves the cover to the given position. The position
+	// is a percentage from 0 (closed) to 100 (open)
+	SetPosition(percent int) error
+
+	// Position queries the device for the current position of the cover
+	// as a percentage from 0 (closed) to 100 (open)
+	Position() (percent int, err error)
+
+	// SetMotorDirection will reverse the direction of the motor when
+	// reversed is true.  This is useful when the motor has been wired
+	// such that open and close are swapped
+	SetMotorDirection(reversed bool) error
+
+	// SetTravelTime sets the number of seconds it takes the cover to
+	// move from fully closed to fully open. The travel time determines the
+	// limits used to compute partial positions
+	SetTravelTime(seconds int) error
+}
+
+// LinkableCover is a Cover with a remotely manageable All-Link database
+type LinkableCover interface {
+	Cover
+	Linkable
+}
+
+type cover struct {
+	Device
+	timeout time.Duration
+}
+
+type linkableCover struct {
+	LinkableDevice
+	*cover
+}
+
+// NewCover will return a Cover for the given device
+func NewCover(device Device, timeout time.Duration) Cover {
+	c := &cover{Device: device, timeout: timeout}
+	if linkable, ok := device.(LinkableDevice); ok {
+		return &linkableCover{LinkableDevice: linkable, cover: c}
+	}
+	return c
+}
+
+func (c *cover) OpenCover() error  { return extractError(c.SendCommand(CmdLightOn, nil)) }
+func (c *cover) CloseCover() error { return extractError(c.SendCommand(CmdLightOff, nil)) }
+func (c *cover) StopCover() error  { return extractError(c.SendCommand(CmdLightStopManual, nil)) }
+
+func (c *cover) SetPosition(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("position %d is out of range (0-100)", percent)
+	}
+	return extractError(c.SendCommand(CmdLightOn.SubCommand((percent*255+50)/100), nil))
+}
+
+func (c *cover) Position() (percent int, err error) {
+	response, err := c.SendCommand(CmdLightStatusRequest, nil)
+	if err == nil {
+		percent = (int(response[2])*100 + 127) / 255
+	}
+	return percent, err
+}
+
+func (c *cover) SetMotorDirection(reversed bool) error {
+	direction := byte(0x00)
+	if reversed {
+		direction = 0x01
+	}
+	return extractError(c.SendCommand(CmdExtendedGetSet, []byte{0x00, coverDirection, direction}))
+}
+
+func (c *cover) SetTravelTime(seconds int) error {
+	if seconds < 0 || seconds > 0xffff {
+		return fmt.Errorf("travel time %d is out of range (0-65535)", seconds)
+	}
+	return extractError(c.SendCommand(CmdExtendedGetSet, []byte{0x00, coverTravelTime, byte(seconds >> 8), byte(seconds)}))
+}
+
+func (c *cover) String() string {
+	return fmt.Sprintf("Cover (%s)", c.Address())
+}
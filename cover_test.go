@@ -0,0 +1,78 @@
+package insteon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCoverFactory(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input Device
+		want  reflect.Type
+	}{
+		{"Cover", &i1Device{}, reflect.TypeOf(&cover{})},
+		{"Linkable Cover", &i2CsDevice{}, reflect.TypeOf(&linkableCover{})},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got := reflect.TypeOf(NewCover(test.input, 0))
+			if test.want != got {
+				t.Errorf("want type %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestCoverCommands(t *testing.T) {
+	tests := []*commandTest{
+		{"OpenCover", func(d Device) error { return d.(Cover).OpenCover() }, CmdLightOn, nil, nil},
+		{"CloseCover", func(d Device) error { return d.(Cover).CloseCover() }, CmdLightOff, nil, nil},
+		{"StopCover", func(d Device) error { return d.(Cover).StopCover() }, CmdLightStopManual, nil, nil},
+		{"SetPosition(0)", func(d Device) error { return d.(Cover).SetPosition(0) }, CmdLightOn.SubCommand(0), nil, nil},
+		{"SetPosition(50)", func(d Device) error { return d.(Cover).SetPosition(50) }, CmdLightOn.SubCommand(128), nil, nil},
+		{"SetPosition(100)", func(d Device) error { return d.(Cover).SetPosition(100) }, CmdLightOn.SubCommand(255), nil, nil},
+		{"SetMotorDirection(true)", func(d Device) error { return d.(Cover).SetMotorDirection(true) }, CmdExtendedGetSet, nil, []byte{0x00, 0x0b, 0x01}},
+		{"SetMotorDirection(false)", func(d Device) error { return d.(Cover).SetMotorDirection(false) }, CmdExtendedGetSet, nil, []byte{0x00, 0x0b, 0x00}},
+		{"SetTravelTime", func(d Device) error { return d.(Cover).SetTravelTime(300) }, CmdExtendedGetSet, nil, []byte{0x00, 0x07, 0x01, 0x2c}},
+	}
+
+	testDeviceCommands(t, func(conn *testConnection) Device { return NewCover(conn, time.Nanosecond) }, tests)
+}
+
+func TestCoverRange(t *testing.T) {
+	c := NewCover(&testConnection{}, 0)
+	if err := c.SetPosition(101); err == nil {
+		t.Errorf("want error for position 101")
+	}
+
+	if err := c.SetTravelTime(-1); err == nil {
+		t.Errorf("want error for travel time -1")
+	}
+}
+
+func TestCoverPosition(t *testing.T) {
+	tests := []struct {
+		input byte
+		want  int
+	}{
+		{0x00, 0},
+		{0x80, 50},
+		{0xff, 100},
+	}
+
+	for _, test := range tests {
+		t.Run(sprintf("%d", test.want), func(t *testing.T) {
+			conn := &testConnection{sendCh: make(chan *Message, 1), ackCh: make(chan *Message, 1)}
+			conn.ackCh <- &Message{Flags: StandardDirectAck, Command: CmdLightStatusRequest.SubCommand(int(test.input))}
+			got, err := NewCover(conn, 0).Position()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.want != got {
+				t.Errorf("want position %d got %d", test.want, got)
+			}
+		})
+	}
+}
@@ -196,13 +196,27 @@ type Linkable interface {
 	WriteLinks(...*LinkRecord) error
 }
 
+// LinkCompleter is a Linkable device, such as a PLM, that reports when
+// a link has been created while it is in linking mode
+type LinkCompleter interface {
+	// WaitLinkComplete waits for the device to report the link record
+	// created after EnterLinkingMode or EnterUnlinkingMode.  If no link
+	// is reported before the timeout then ErrReadTimeout is returned
+	WaitLinkComplete(timeout time.Duration) (*LinkRecord, error)
+}
+
+// LinkTimeout is the maximum amount of time to wait for a device that
+// was put into linking mode to send its Set-Button Pressed broadcast, and
+// for a LinkCompleter to confirm that a link was created
+var LinkTimeout = 10 * time.Second
+
 // DeviceInfo is a record of information about known
 // devices on the network
 type DeviceInfo struct {
-	Address         Address
-	DevCat          DevCat
-	FirmwareVersion FirmwareVersion
-	EngineVersion   EngineVersion
+	Address         Address         `json:"address"`
+	DevCat          DevCat          `json:"devCat"`
+	FirmwareVersion FirmwareVersion `json:"firmwareVersion"`
+	EngineVersion   EngineVersion   `json:"engineVersion"`
 }
 
 // Open will create a new device that is ready to be used. Open tries to contact
@@ -217,22 +231,26 @@ type DeviceInfo struct {
 // is encountered, then the I2CsDevice is returned with an ErrNotLinked error.  This
 // allows the application to initiate linking, if desired
 func Open(conn Connection, timeout time.Duration) (device Device, err error) {
-	version, err := conn.EngineVersion()
+	info, err := identify(conn)
 	if err == nil {
-		info := DeviceInfo{
-			Address:       conn.Address(),
-			EngineVersion: version,
-		}
-		info.FirmwareVersion, info.DevCat, err = conn.IDRequest()
-		if err == nil {
-			device, err = Devices.New(info, conn, timeout)
-		}
+		device, err = Devices.New(info, conn, timeout)
 	} else if err == ErrNotLinked {
 		device, _ = New(VerI2Cs, conn, timeout)
 	}
 	return device, err
 }
 
+// identify queries the device for its engine version, firmware version
+// and device category
+func identify(conn Connection) (info DeviceInfo, err error) {
+	info.Address = conn.Address()
+	info.EngineVersion, err = conn.EngineVersion()
+	if err == nil {
+		info.FirmwareVersion, info.DevCat, err = conn.IDRequest()
+	}
+	return info, err
+}
+
 // New will return either an I1Device, an I2Device or an I2CsDevice based on the
 // supplied EngineVersion
 func New(version EngineVersion, conn Connection, timeout time.Duration) (device Device, err error) {
@@ -0,0 +1,123 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"fmt"
+	"time"
+)
+
+func init() {
+	Devices.Register(0x09, energyMeterFactory)
+}
+
+func energyMeterFactory(info DeviceInfo, device Device, timeout time.Duration) (Device, error) {
+	return NewEnergyMeter(device, timeout), nil
+}
+
+// MeterReport is the power and energy usage reported by an iMeter
+type MeterReport struct {
+	// Power is the instantaneous power usage in watts
+	Power int
+
+	// Energy is the accumulated energy usage in kWh since the
+	// last reset
+	Energy float64
+}
+
+func (mr MeterReport) String() string {
+	return sprintf("%dW %.3fkWh", mr.Power, mr.Energy)
+}
+
+// UnmarshalBinary will parse the extended message payload of an iMeter report
+// into the receiver
+func (mr *MeterReport) UnmarshalBinary(buf []byte) error {
+	if len(buf) < 14 {
+		return newBufError(ErrBufferTooShort, 14, len(buf))
+	}
+
+	// power is a signed 16 bit value in D7 and D8
+	mr.Power = int(int16(uint16(buf[6])<<8 | uint16(buf[7])))
+
+	// D9-D12 are the accumulated pulse count. Each pulse is
+	// 65535 watt-minutes / 60
+	pulses := uint32(buf[8])<<24 | uint32(buf[9])<<16 | uint32(buf[10])<<8 | uint32(buf[11])
+	mr.Energy = float64(pulses) * 65535 / (1000 * 60 * 60 * 60)
+	return nil
+}
+
+// DecodeMeterReport will return the MeterReport contained in an
+// iMeter report message. If the message is not a report, then false
+// is returned
+func DecodeMeterReport(msg *Message) (report MeterReport, ok bool) {
+	if msg.Flags.Extended() && msg.Command[1] == CmdIMeterReport[1] {
+		ok = report.UnmarshalBinary(msg.Payload) == nil
+	}
+	return report, ok
+}
+
+// EnergyMeter is a device, such as the iMeter Solo, that reports power and
+// energy usage
+type EnergyMeter interface {
+	Device
+
+	// Query requests the current power usage and accumulated energy
+	// from the device
+	Query() (MeterReport, error)
+
+	// ResetEnergy clears the device's accumulated energy usage
+	ResetEnergy() error
+
+	// ReceiveReport waits for the next report sent by the meter. This
+	// includes both responses to queries and periodic status reports
+	ReceiveReport() (MeterReport, error)
+}
+
+type energyMeter struct {
+	Device
+	timeout time.Duration
+}
+
+// NewEnergyMeter will return an EnergyMeter for the given device
+func NewEnergyMeter(device Device, timeout time.Duration) EnergyMeter {
+	return &energyMeter{Device: device, timeout: timeout}
+}
+
+func (em *energyMeter) Query() (report MeterReport, err error) {
+	_, err = em.SendCommand(CmdIMeterQuery, nil)
+	if err == nil {
+		report, err = em.ReceiveReport()
+	}
+	return report, err
+}
+
+func (em *energyMeter) ResetEnergy() error {
+	return extractError(em.SendCommand(CmdIMeterReset, nil))
+}
+
+func (em *energyMeter) ReceiveReport() (report MeterReport, err error) {
+	err = Receive(em.Device, em.timeout, func(msg *Message) error {
+		var ok bool
+		if report, ok = DecodeMeterReport(msg); ok {
+			return ErrReceiveComplete
+		}
+		return nil
+	})
+	return report, err
+}
+
+func (em *energyMeter) String() string {
+	return fmt.Sprintf("Energy Meter (%s)", em.Address())
+}
@@ -0,0 +1,89 @@
+package insteon
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeterReportUnmarshalBinary(t *testing.T) {
+	tests := []struct {
+		desc       string
+		input      []byte
+		wantPower  int
+		wantEnergy float64
+		wantErr    error
+	}{
+		{"Zero", mkPayload(), 0, 0, nil},
+		{"Power", mkPayload(0, 0, 0, 0, 0, 0, 0x00, 0x64), 100, 0, nil},
+		{"Negative Power", mkPayload(0, 0, 0, 0, 0, 0, 0xff, 0x9c), -100, 0, nil},
+		{"Energy", mkPayload(0, 0, 0, 0, 0, 0, 0, 0, 0x00, 0x00, 0x0c, 0xe0), 0, 1.0000, nil},
+		{"Short Buffer", nil, 0, 0, ErrBufferTooShort},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			report := MeterReport{}
+			err := report.UnmarshalBinary(test.input)
+			if !IsError(err, test.wantErr) {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if err == nil {
+				if test.wantPower != report.Power {
+					t.Errorf("want power %d got %d", test.wantPower, report.Power)
+				}
+
+				if math.Abs(test.wantEnergy-report.Energy) > 0.001 {
+					t.Errorf("want energy %f got %f", test.wantEnergy, report.Energy)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMeterReport(t *testing.T) {
+	tests := []struct {
+		desc   string
+		input  *Message
+		wantOk bool
+	}{
+		{"Report", &Message{Flags: ExtendedDirectMessage, Command: CmdIMeterReport, Payload: mkPayload()}, true},
+		{"Standard", &Message{Flags: StandardDirectMessage, Command: CmdIMeterQuery}, false},
+		{"Other", &Message{Flags: ExtendedDirectMessage, Command: CmdExtendedGetSet, Payload: mkPayload()}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, gotOk := DecodeMeterReport(test.input)
+			if test.wantOk != gotOk {
+				t.Errorf("want ok %v got %v", test.wantOk, gotOk)
+			}
+		})
+	}
+}
+
+func TestEnergyMeterCommands(t *testing.T) {
+	tests := []*commandTest{
+		{"ResetEnergy", func(d Device) error { return d.(EnergyMeter).ResetEnergy() }, CmdIMeterReset, nil, nil},
+	}
+
+	testDeviceCommands(t, func(conn *testConnection) Device { return NewEnergyMeter(conn, time.Nanosecond) }, tests)
+}
+
+func TestEnergyMeterQuery(t *testing.T) {
+	conn := &testConnection{recvCh: make(chan *Message, 1), sendCh: make(chan *Message, 1), ackCh: make(chan *Message, 1)}
+	meter := NewEnergyMeter(conn, time.Second)
+	conn.ackCh <- TestAck
+	conn.recvCh <- &Message{Flags: ExtendedDirectMessage, Command: CmdIMeterReport, Payload: mkPayload(0, 0, 0, 0, 0, 0, 0x01, 0x00)}
+
+	got, err := meter.Query()
+	msg := <-conn.sendCh
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if got.Power != 256 {
+		t.Errorf("want power 256 got %d", got.Power)
+	}
+
+	if msg.Command != CmdIMeterQuery {
+		t.Errorf("want command %v got %v", CmdIMeterQuery, msg.Command)
+	}
+}
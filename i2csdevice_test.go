@@ -110,7 +110,7 @@ func TestI2CsDeviceEnterLinkingMode(t *testing.T) {
 	constructor := func(conn *testConnection) Device { return newI2CsDevice(conn, time.Millisecond) }
 	callback := func(d Device) error { return d.(*i2CsDevice).EnterLinkingMode(10) }
 	// happy path
-	testDeviceCommand(t, constructor, callback, CmdEnterLinkingModeExt.SubCommand(10), nil, nil)
+	testDeviceCommand(t, constructor, callback, CmdEnterLinkingModeExt.SubCommand(10), nil, nil, TestSetButtonPressed)
 }
 
 func TestI2CsDeviceReceive(t *testing.T) {
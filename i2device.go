@@ -39,8 +39,13 @@ func (i2 *i2Device) linkingMode(cmd Command, payload ...byte) error {
 	defer i2.Unlock()
 	_, err := i2.SendCommand(cmd, payload)
 	if err == nil {
-		Log.Tracef("Waiting %s for response (Set-Button Pressed Controller/Responder)", i2.timeout)
-		<-time.After(PropagationDelay(3, len(payload) > 0))
+		Log.Tracef("Waiting %s for response (Set-Button Pressed Controller/Responder)", LinkTimeout)
+		err = Receive(i2, LinkTimeout, func(msg *Message) error {
+			if msg.Broadcast() && (msg.Command[1] == CmdSetButtonPressedController[1] || msg.Command[1] == CmdSetButtonPressedResponder[1]) {
+				return ErrReceiveComplete
+			}
+			return nil
+		})
 	}
 	return err
 }
@@ -0,0 +1,215 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ind keeps an inventory of the Insteon devices on a network
+package ind
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/util"
+)
+
+var (
+	// ErrRecordNotFound is returned when a device isn't in the database
+	ErrRecordNotFound = errors.New("Device not found in database")
+)
+
+// DeviceRecord is an entry in the device database.  Each device is
+// assigned a unique ID when it is first saved
+type DeviceRecord struct {
+	ID       int              `json:"id"`
+	Address  insteon.Address  `json:"address"`
+	Category insteon.Category `json:"category"`
+}
+
+// DeviceDatabase is an inventory of devices that can be looked up by ID,
+// address or category. DeviceDatabase is safe to use from multiple go
+// routines
+type DeviceDatabase struct {
+	mu           sync.Mutex
+	devices      []*DeviceRecord
+	idIndex      map[int]*DeviceRecord
+	addressIndex map[insteon.Address]*DeviceRecord
+	lastID       int
+	filename     string
+}
+
+// NewDeviceDatabase returns an empty, in memory, device database
+func NewDeviceDatabase() *DeviceDatabase {
+	return &DeviceDatabase{
+		idIndex:      make(map[int]*DeviceRecord),
+		addressIndex: make(map[insteon.Address]*DeviceRecord),
+	}
+}
+
+// OpenDeviceDatabase returns a device database that is kept in a JSON file.
+// The devices already in the file are loaded and every change is written
+// back to the file.  If the file doesn't exist then an empty database is
+// returned and the file is created when the first device is saved
+func OpenDeviceDatabase(filename string) (*DeviceDatabase, error) {
+	ddb := NewDeviceDatabase()
+	ddb.filename = filename
+
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		err = json.Unmarshal(data, ddb)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return ddb, err
+}
+
+// Find returns the device with the given ID
+func (ddb *DeviceDatabase) Find(id int) (record DeviceRecord, err error) {
+	ddb.mu.Lock()
+	defer ddb.mu.Unlock()
+	if r, found := ddb.idIndex[id]; found {
+		return *r, nil
+	}
+	return record, ErrRecordNotFound
+}
+
+// FindByAddress returns the device with the given address
+func (ddb *DeviceDatabase) FindByAddress(address insteon.Address) (record DeviceRecord, err error) {
+	ddb.mu.Lock()
+	defer ddb.mu.Unlock()
+	if r, found := ddb.addressIndex[address]; found {
+		return *r, nil
+	}
+	return record, ErrRecordNotFound
+}
+
+// FindByCategory returns all the devices in the given category
+func (ddb *DeviceDatabase) FindByCategory(category insteon.Category) (records []DeviceRecord) {
+	ddb.mu.Lock()
+	defer ddb.mu.Unlock()
+	for _, r := range ddb.devices {
+		if r.Category == category {
+			records = append(records, *r)
+		}
+	}
+	return records
+}
+
+// Devices returns every device in the database in the order
+// they were added
+func (ddb *DeviceDatabase) Devices() []DeviceRecord {
+	ddb.mu.Lock()
+	defer ddb.mu.Unlock()
+	records := make([]DeviceRecord, len(ddb.devices))
+	for i, r := range ddb.devices {
+		records[i] = *r
+	}
+	return records
+}
+
+// Save adds the device to the database, or updates its category if the
+// device is already in the database.  The device's record is returned
+func (ddb *DeviceDatabase) Save(info insteon.DeviceInfo) (DeviceRecord, error) {
+	ddb.mu.Lock()
+	defer ddb.mu.Unlock()
+
+	record, found := ddb.addressIndex[info.Address]
+	if found {
+		if record.Category == info.DevCat.Category() {
+			return *record, nil
+		}
+		record.Category = info.DevCat.Category()
+	} else {
+		record = &DeviceRecord{
+			ID:       ddb.lastID + 1,
+			Address:  info.Address,
+			Category: info.DevCat.Category(),
+		}
+		ddb.add(record)
+	}
+	return *record, ddb.save()
+}
+
+// Delete removes the device with the given ID from the database. IDs
+// are not reused
+func (ddb *DeviceDatabase) Delete(id int) error {
+	ddb.mu.Lock()
+	defer ddb.mu.Unlock()
+
+	record, found := ddb.idIndex[id]
+	if !found {
+		return ErrRecordNotFound
+	}
+
+	for i, r := range ddb.devices {
+		if r == record {
+			ddb.devices = append(ddb.devices[:i], ddb.devices[i+1:]...)
+			break
+		}
+	}
+	delete(ddb.idIndex, id)
+	delete(ddb.addressIndex, record.Address)
+	return ddb.save()
+}
+
+func (ddb *DeviceDatabase) add(record *DeviceRecord) {
+	ddb.devices = append(ddb.devices, record)
+	ddb.idIndex[record.ID] = record
+	ddb.addressIndex[record.Address] = record
+	if record.ID > ddb.lastID {
+		ddb.lastID = record.ID
+	}
+}
+
+// save writes the database to its file, if it has one. Must be
+// called with the lock held
+func (ddb *DeviceDatabase) save() error {
+	if ddb.filename == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(ddb.devices, "", "  ")
+	if err == nil {
+		err = util.WriteFileAtomic(ddb.filename, data, 0644)
+	}
+	return err
+}
+
+// MarshalJSON returns the devices in the database as a JSON list
+func (ddb *DeviceDatabase) MarshalJSON() ([]byte, error) {
+	ddb.mu.Lock()
+	defer ddb.mu.Unlock()
+	return json.Marshal(ddb.devices)
+}
+
+// UnmarshalJSON adds the devices in the JSON list to the database
+func (ddb *DeviceDatabase) UnmarshalJSON(data []byte) error {
+	var devices []*DeviceRecord
+	err := json.Unmarshal(data, &devices)
+	if err == nil {
+		ddb.mu.Lock()
+		defer ddb.mu.Unlock()
+		if ddb.idIndex == nil {
+			ddb.idIndex = make(map[int]*DeviceRecord)
+			ddb.addressIndex = make(map[insteon.Address]*DeviceRecord)
+		}
+
+		for _, record := range devices {
+			ddb.add(record)
+		}
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package ind
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/abates/insteon"
+)
+
+var (
+	dimmer = insteon.DeviceInfo{Address: insteon.Address{1, 2, 3}, DevCat: insteon.DevCat{0x01, 0x20}}
+	sw     = insteon.DeviceInfo{Address: insteon.Address{4, 5, 6}, DevCat: insteon.DevCat{0x02, 0x2a}}
+	lamp   = insteon.DeviceInfo{Address: insteon.Address{7, 8, 9}, DevCat: insteon.DevCat{0x01, 0x0e}}
+)
+
+func TestDeviceDatabase(t *testing.T) {
+	ddb := NewDeviceDatabase()
+	for _, info := range []insteon.DeviceInfo{dimmer, sw, lamp} {
+		if _, err := ddb.Save(info); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := DeviceRecord{ID: 2, Address: sw.Address, Category: 0x02}
+	if got, err := ddb.Find(2); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if got != want {
+		t.Errorf("want %+v got %+v", want, got)
+	}
+
+	if got, err := ddb.FindByAddress(sw.Address); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if got != want {
+		t.Errorf("want %+v got %+v", want, got)
+	}
+
+	wantDimmers := []DeviceRecord{{1, dimmer.Address, 0x01}, {3, lamp.Address, 0x01}}
+	if got := ddb.FindByCategory(0x01); !reflect.DeepEqual(wantDimmers, got) {
+		t.Errorf("want %+v got %+v", wantDimmers, got)
+	}
+
+	// saving a known device keeps its ID
+	sw.DevCat = insteon.DevCat{0x01, 0x20}
+	if got, err := ddb.Save(sw); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if got.ID != 2 || got.Category != 0x01 {
+		t.Errorf("want ID 2 and category 01 got %+v", got)
+	}
+
+	if err := ddb.Delete(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := ddb.Find(1); err != ErrRecordNotFound {
+		t.Errorf("want error %v got %v", ErrRecordNotFound, err)
+	}
+
+	if _, err := ddb.FindByAddress(dimmer.Address); err != ErrRecordNotFound {
+		t.Errorf("want error %v got %v", ErrRecordNotFound, err)
+	}
+
+	if err := ddb.Delete(1); err != ErrRecordNotFound {
+		t.Errorf("want error %v got %v", ErrRecordNotFound, err)
+	}
+
+	// IDs are never reused
+	if got, _ := ddb.Save(dimmer); got.ID != 4 {
+		t.Errorf("want ID 4 got %d", got.ID)
+	}
+}
+
+func TestDeviceDatabaseJSON(t *testing.T) {
+	ddb := NewDeviceDatabase()
+	ddb.Save(dimmer)
+	ddb.Save(sw)
+
+	data, err := json.Marshal(ddb)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := &DeviceDatabase{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(ddb.Devices(), got.Devices()) {
+		t.Errorf("want %+v got %+v", ddb.Devices(), got.Devices())
+	}
+
+	if record, _ := got.Save(lamp); record.ID != 3 {
+		t.Errorf("want ID 3 got %d", record.ID)
+	}
+}
+
+func TestOpenDeviceDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insteon")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "devices.json")
+	ddb, err := OpenDeviceDatabase(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := ddb.Save(dimmer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ddb, err = OpenDeviceDatabase(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, err := ddb.FindByAddress(dimmer.Address); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if got != want {
+		t.Errorf("want %+v got %+v", want, got)
+	}
+}
@@ -82,6 +82,11 @@ var (
 	// ErrVersion is returned when an engine version value is not known
 	ErrVersion = errors.New("Unknown Insteon Engine Version")
 
+	// ErrStaleInfo is returned by devices opened with OpenWithInfo or OpenCached
+	// when the device no longer matches the DeviceInfo it was opened with. The
+	// device should be opened again
+	ErrStaleInfo = errors.New("Device info is out of date")
+
 	// ErrLinkIndexOutOfRange indicates that the index exceeds the length of the all-link database
 	ErrLinkIndexOutOfRange = errors.New("Link index is beyond the bounds of the link database")
 
@@ -136,6 +141,18 @@ func (dc DevCat) String() string {
 	return sprintf("%02x.%02x", dc[0], dc[1])
 }
 
+// Set satisfies the flag.Value interface. The input string must be
+// in the form of category.subcategory where both values are hex
+func (dc *DevCat) Set(str string) error {
+	var cat, subCat byte
+	n, err := fmt.Sscanf(str, "%02x.%02x", &cat, &subCat)
+	if n < 2 {
+		return fmt.Errorf("Expected category.subcategory (e.g. 03.15) got %q", str)
+	}
+	dc[0], dc[1] = cat, subCat
+	return err
+}
+
 // Category is type for the Category byte in the DevCat
 type Category byte
 
@@ -90,6 +90,31 @@ func TestDevCat(t *testing.T) {
 	}
 }
 
+func TestDevCatSet(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    DevCat
+		wantErr bool
+	}{
+		{"03.15", DevCat{0x03, 0x15}, false},
+		{"ff.0a", DevCat{0xff, 0x0a}, false},
+		{"03", DevCat{}, true},
+		{"zz.01", DevCat{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			var got DevCat
+			err := got.Set(test.input)
+			if (err != nil) != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if got != test.want {
+				t.Errorf("want DevCat %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
 func TestDevCatMarshaling(t *testing.T) {
 	tests := []struct {
 		input          string
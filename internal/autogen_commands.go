@@ -127,6 +127,21 @@ var commands = []commandGroup{
 			{"CmdLightOffAtRampV67", "", "Light Off At Ramp", "0x35", "0x00"},
 		},
 	},
+	{
+		Name:  "Energy Management Standard Direct Messages",
+		Byte0: "0x00",
+		Commands: []command{
+			{"CmdIMeterReset", "resets the accumulated energy usage of an iMeter", "iMeter Reset", "0x80", "0x00"},
+			{"CmdIMeterQuery", "requests the current power and accumulated energy usage of an iMeter", "iMeter Query", "0x82", "0x00"},
+		},
+	},
+	{
+		Name:  "Energy Management Extended Messages",
+		Byte0: "0x01",
+		Commands: []command{
+			{"CmdIMeterReport", "is sent by an iMeter in response to a query or as a periodic status report", "iMeter Report", "0x82", "0x00"},
+		},
+	},
 }
 
 const cmdsTemplate = `
@@ -31,6 +31,7 @@ var (
 	TestMessagePing          = &Message{testSrcAddr, testDstAddr, StandardDirectMessage, Command{0x00, 0x0f, 0x00}, nil}
 	TestMessagePingAck       = &Message{testDstAddr, testSrcAddr, StandardDirectAck, Command{0x00, 0x0f, 0x00}, nil}
 	TestAck                  = &Message{testSrcAddr, testDstAddr, StandardDirectAck, Command{0x00, 0x00, 0x00}, nil}
+	TestSetButtonPressed     = &Message{testSrcAddr, Address{0x01, 0x20, 0x45}, StandardBroadcast, CmdSetButtonPressedResponder, nil}
 
 	TestProductDataResponse = &Message{testDstAddr, testSrcAddr, ExtendedDirectMessage, CmdProductDataResp, []byte{0, 1, 2, 3, 4, 5, 0xff, 0xff, 0, 0, 0, 0, 0, 0}}
 	TestDeviceLink1         = &Message{testSrcAddr, testDstAddr, ExtendedDirectMessage, CmdReadWriteALDB, []byte{0, 1, 0x0f, 0xff, 0, 0xc0, 1, 7, 8, 9, 0, 0, 0, 0}}
@@ -15,9 +15,15 @@
 package network
 
 import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
 	"sync"
 
 	"github.com/abates/insteon"
+	"github.com/abates/insteon/util"
 )
 
 // ProductDatabase is a registry of all the devices that have been
@@ -30,11 +36,16 @@ type ProductDatabase interface {
 	UpdateEngineVersion(address insteon.Address, engineVersion insteon.EngineVersion)
 	UpdateFirmwareVersion(address insteon.Address, firmwareVersion insteon.FirmwareVersion)
 	Find(address insteon.Address) (deviceInfo insteon.DeviceInfo, found bool)
+
+	// Update replaces all of the information for info.Address.  This
+	// allows the database to be used as an insteon.DeviceInfoCache
+	Update(info insteon.DeviceInfo)
 }
 
 type productDatabase struct {
-	devices map[insteon.Address]*insteon.DeviceInfo
-	mutex   sync.Mutex
+	devices  map[insteon.Address]*insteon.DeviceInfo
+	mutex    sync.Mutex
+	filename string
 }
 
 // NewProductDB will initialize a product database for
@@ -45,6 +56,77 @@ func NewProductDB() ProductDatabase {
 	}
 }
 
+// OpenProductDB returns a product database that is kept in a JSON file.
+// The devices already in the file are loaded and every change to the
+// database is written back to the file.  The file is replaced atomically
+// so it is never left partially written. If the file doesn't exist then
+// an empty database is returned and the file is created on the first change
+func OpenProductDB(filename string) (ProductDatabase, error) {
+	pdb := &productDatabase{
+		devices:  make(map[insteon.Address]*insteon.DeviceInfo),
+		filename: filename,
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		err = json.Unmarshal(data, pdb)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return pdb, err
+}
+
+// MarshalJSON returns the devices in the database as a JSON list
+// sorted by address
+func (pdb *productDatabase) MarshalJSON() ([]byte, error) {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+	return pdb.marshal()
+}
+
+func (pdb *productDatabase) marshal() ([]byte, error) {
+	devices := make([]*insteon.DeviceInfo, 0, len(pdb.devices))
+	for _, deviceInfo := range pdb.devices {
+		devices = append(devices, deviceInfo)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return bytes.Compare(devices[i].Address[:], devices[j].Address[:]) < 0
+	})
+	return json.MarshalIndent(devices, "", "  ")
+}
+
+// UnmarshalJSON adds the devices in the JSON list to the database
+func (pdb *productDatabase) UnmarshalJSON(data []byte) error {
+	var devices []*insteon.DeviceInfo
+	err := json.Unmarshal(data, &devices)
+	if err == nil {
+		pdb.mutex.Lock()
+		defer pdb.mutex.Unlock()
+		for _, deviceInfo := range devices {
+			pdb.devices[deviceInfo.Address] = deviceInfo
+		}
+	}
+	return err
+}
+
+// save writes the database to its file, if it has one. Must be
+// called with the lock held
+func (pdb *productDatabase) save() {
+	if pdb.filename == "" {
+		return
+	}
+
+	data, err := pdb.marshal()
+	if err == nil {
+		err = util.WriteFileAtomic(pdb.filename, data, 0644)
+	}
+
+	if err != nil {
+		insteon.Log.Infof("Failed to save product database %s: %v", pdb.filename, err)
+	}
+}
+
 func (pdb *productDatabase) Find(address insteon.Address) (deviceInfo insteon.DeviceInfo, found bool) {
 	pdb.mutex.Lock()
 	di, found := pdb.devices[address]
@@ -57,6 +139,7 @@ func (pdb *productDatabase) Find(address insteon.Address) (deviceInfo insteon.De
 
 func (pdb *productDatabase) update(address insteon.Address, callback func(*insteon.DeviceInfo)) {
 	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
 	deviceInfo, found := pdb.devices[address]
 	if !found {
 		deviceInfo = &insteon.DeviceInfo{
@@ -64,8 +147,12 @@ func (pdb *productDatabase) update(address insteon.Address, callback func(*inste
 		}
 		pdb.devices[address] = deviceInfo
 	}
+
+	previous := *deviceInfo
 	callback(deviceInfo)
-	pdb.mutex.Unlock()
+	if !found || previous != *deviceInfo {
+		pdb.save()
+	}
 }
 
 func (pdb *productDatabase) UpdateFirmwareVersion(address insteon.Address, firmwareVersion insteon.FirmwareVersion) {
@@ -79,3 +166,7 @@ func (pdb *productDatabase) UpdateEngineVersion(address insteon.Address, engineV
 func (pdb *productDatabase) UpdateDevCat(address insteon.Address, devCat insteon.DevCat) {
 	pdb.update(address, func(deviceInfo *insteon.DeviceInfo) { deviceInfo.DevCat = devCat })
 }
+
+func (pdb *productDatabase) Update(info insteon.DeviceInfo) {
+	pdb.update(info.Address, func(deviceInfo *insteon.DeviceInfo) { *deviceInfo = info })
+}
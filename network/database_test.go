@@ -15,6 +15,9 @@
 package network
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
@@ -42,6 +45,7 @@ import (
 
 type testProductDB struct {
 	updates    sync.Map
+	writes     int
 	deviceInfo *insteon.DeviceInfo
 }
 
@@ -68,6 +72,27 @@ func (tpd *testProductDB) UpdateFirmwareVersion(address insteon.Address, firmwar
 	tpd.updates.Store("FirmwareVersion", true)
 }
 
+// Update records which fields changed from the known device info
+func (tpd *testProductDB) Update(info insteon.DeviceInfo) {
+	tpd.writes++
+	previous := insteon.DeviceInfo{Address: info.Address}
+	if tpd.deviceInfo != nil {
+		previous = *tpd.deviceInfo
+	}
+
+	if info.FirmwareVersion != previous.FirmwareVersion {
+		tpd.updates.Store("FirmwareVersion", true)
+	}
+
+	if info.DevCat != previous.DevCat {
+		tpd.updates.Store("DevCat", true)
+	}
+
+	if info.EngineVersion != previous.EngineVersion {
+		tpd.updates.Store("EngineVersion", true)
+	}
+}
+
 func (tpd *testProductDB) Find(address insteon.Address) (deviceInfo insteon.DeviceInfo, found bool) {
 	if tpd.deviceInfo == nil {
 		return insteon.DeviceInfo{}, false
@@ -85,6 +110,11 @@ func TestProductDatabaseUpdateFind(t *testing.T) {
 		{"UpdateFirmwareVersion", func(pdb *productDatabase) { pdb.UpdateFirmwareVersion(address, insteon.FirmwareVersion(42)) }, func(di insteon.DeviceInfo) bool { return di.FirmwareVersion == insteon.FirmwareVersion(42) }},
 		{"UpdateEngineVersion", func(pdb *productDatabase) { pdb.UpdateEngineVersion(address, insteon.EngineVersion(42)) }, func(di insteon.DeviceInfo) bool { return di.EngineVersion == insteon.EngineVersion(42) }},
 		{"UpdateDevCat", func(pdb *productDatabase) { pdb.UpdateDevCat(address, insteon.DevCat{42, 42}) }, func(di insteon.DeviceInfo) bool { return di.DevCat == insteon.DevCat{42, 42} }},
+		{"Update", func(pdb *productDatabase) {
+			pdb.Update(insteon.DeviceInfo{Address: address, DevCat: insteon.DevCat{42, 42}, EngineVersion: insteon.VerI2Cs})
+		}, func(di insteon.DeviceInfo) bool {
+			return di == insteon.DeviceInfo{Address: address, DevCat: insteon.DevCat{42, 42}, EngineVersion: insteon.VerI2Cs}
+		}},
 	}
 
 	for _, test := range tests {
@@ -105,3 +135,43 @@ func TestProductDatabaseUpdateFind(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenProductDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insteon")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "products.json")
+	pdb, err := OpenProductDB(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	address := insteon.Address{0, 1, 2}
+	want := insteon.DeviceInfo{Address: address, DevCat: insteon.DevCat{0x01, 0x20}, FirmwareVersion: 0x45, EngineVersion: insteon.VerI2Cs}
+	pdb.UpdateDevCat(address, want.DevCat)
+	pdb.UpdateFirmwareVersion(address, want.FirmwareVersion)
+	pdb.UpdateEngineVersion(address, want.EngineVersion)
+
+	// reopening the database loads the saved devices
+	pdb, err = OpenProductDB(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, found := pdb.Find(address); !found {
+		t.Errorf("did not find device for address %s", address)
+	} else if got != want {
+		t.Errorf("want %+v got %+v", want, got)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("{"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := OpenProductDB(filename); err == nil {
+		t.Errorf("want error got nil")
+	}
+}
@@ -16,149 +16,186 @@ package network
 
 import (
 	"io"
+	"sync"
 	"time"
 
 	"github.com/abates/insteon"
 )
 
-// PacketRequest is used to request that a packetized (marshaled) insteon
-// message be sent to the network. Once the upstream device (PLM usually)
-// has attempted to send the packet, the Err field will be assigned and
-// DoneCh will be written to and closed
-type PacketRequest struct {
-	Payload []byte
-	Err     error
-	DoneCh  chan<- *PacketRequest
+// monitorTimeout is how long the network waits for traffic from the
+// bridge before checking whether it has been closed
+var monitorTimeout = 100 * time.Millisecond
+
+// Bridge is the upstream device, usually a PLM, that connects the network
+// to the Insteon devices.  Messages are sent to devices through the bridge
+// and every message the bridge receives is read from its Monitor connection
+type Bridge interface {
+	insteon.Sender
+	Monitor(options ...insteon.ConnectionOption) (insteon.Connection, error)
+}
+
+// Option customizes a Network
+type Option func(*Network)
+
+// ProductDB sets the database that the network keeps device information
+// in. Devices that are found in the database are connected without querying
+// them first
+func ProductDB(db ProductDatabase) Option {
+	return func(network *Network) {
+		network.DB = db
+	}
 }
 
-// MessageRequest is used to request a message be sent to a specific device.
-// Once the connection has sent the message and either received an ack or
-// encountered an error, the Ack and Err fields will be filled and DoneCh
-// will be written to and closed
-type MessageRequest struct {
-	Message *insteon.Message
-	timeout time.Time
-	Ack     *insteon.Message
-	Err     error
-	DoneCh  chan<- *MessageRequest
+// partialInfo is device information that has been learned from traffic
+// but isn't complete enough to be added to the product database
+type partialInfo struct {
+	info    insteon.DeviceInfo
+	version bool
+	devCat  bool
 }
 
-// Network is the main means to communicate with
-// devices on the Insteon network
+// Network is the main means to communicate with devices on the Insteon network.
+// The network watches all the traffic received by the bridge and learns the
+// device category, firmware version and engine version of devices as they
+// are seen.  This information is kept in the product database so that
+// devices can be connected without querying them first. The network reads
+// the bridge's Monitor connection, which receives every message the bridge
+// receives, so devices opened directly on the bridge don't hide their
+// traffic from the network
 type Network struct {
-	timeout     time.Duration
-	DB          ProductDatabase
-	connections []insteon.Connection
-
-	connection   insteon.Connection
-	connectCh    chan insteon.Connection
-	disconnectCh chan insteon.Connection
-	closeCh      chan chan error
+	timeout time.Duration
+	DB      ProductDatabase
+
+	bridge  Bridge
+	monitor insteon.Connection
+	demux   insteon.Demux
+
+	mu      sync.Mutex
+	partial map[insteon.Address]*partialInfo
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
 }
 
-// New creates a new Insteon network instance for the send and receive channels.  The timeout
-// indicates how long the network (and subsuquent devices) should wait when expecting incoming
-// messages/responses
-func New(connection insteon.Connection, timeout time.Duration) *Network {
+// New creates a new Insteon network that reaches devices through the given
+// bridge.  The timeout indicates how long the network (and subsequent devices)
+// should wait when expecting incoming messages/responses
+func New(bridge Bridge, timeout time.Duration, options ...Option) (*Network, error) {
+	monitor, err := bridge.Monitor(insteon.ConnectionTimeout(monitorTimeout))
+	if err != nil {
+		return nil, err
+	}
+
 	network := &Network{
-		timeout:    timeout,
-		DB:         NewProductDB(),
-		connection: connection,
+		timeout: timeout,
+		DB:      NewProductDB(),
+
+		bridge:  bridge,
+		monitor: monitor,
+		demux:   insteon.NewDemux(bridge),
+		partial: make(map[insteon.Address]*partialInfo),
+
+		closeCh: make(chan struct{}),
+	}
 
-		connectCh:    make(chan insteon.Connection),
-		disconnectCh: make(chan insteon.Connection),
-		closeCh:      make(chan chan error),
+	for _, option := range options {
+		option(network)
 	}
 
 	go network.process()
-	return network
+	return network, nil
 }
 
 func (network *Network) process() {
-	/*defer network.close()
 	for {
 		select {
-		case buf := <-network.connection.Receive():
-			network.receive(buf)
-		case connection := <-network.connectCh:
-			network.connections = append(network.connections, connection)
-		case connection := <-network.disconnectCh:
-			network.disconnect(connection)
-		case ch := <-network.closeCh:
-			ch <- network.close()
+		case <-network.closeCh:
 			return
+		default:
 		}
-	}*/
-}
 
-func (network *Network) receive(buf []byte) {
-	msg := &insteon.Message{}
-	err := msg.UnmarshalBinary(buf)
-	if err == nil {
-		insteon.Log.Tracef("Received Insteon Message %v", msg)
-		if msg.Broadcast() {
-			// Set Button Pressed Controller/Responder
-			if msg.Command[1] == 0x01 || msg.Command[1] == 0x02 {
-				network.DB.UpdateFirmwareVersion(msg.Src, insteon.FirmwareVersion(msg.Dst[2]))
-				network.DB.UpdateDevCat(msg.Src, insteon.DevCat{msg.Dst[0], msg.Dst[1]})
-			}
-		} else if msg.Ack() && msg.Command[1] == 0x0d {
-			// Engine Version Request ACK
-			network.DB.UpdateEngineVersion(msg.Src, insteon.EngineVersion(msg.Command[2]))
+		msg, err := network.monitor.Receive()
+		if err == nil {
+			network.receive(msg)
+		} else if err != insteon.ErrReadTimeout {
+			insteon.Log.Infof("Failed to receive from bridge: %v", err)
+			return
 		}
+	}
+}
 
-		for range network.connections {
-			// TODO: FIX THIS
-			//connection.Push(msg)
+// receive learns what it can about the sender of the message and then
+// passes the message on to the sender's connection
+func (network *Network) receive(msg *insteon.Message) {
+	insteon.Log.Tracef("Received Insteon Message %v", msg)
+	if msg.Flags.Type() == insteon.MsgTypeBroadcast {
+		// Set Button Pressed Controller/Responder
+		if msg.Command[1] == 0x01 || msg.Command[1] == 0x02 {
+			network.learn(msg.Src, func(partial *partialInfo) {
+				partial.info.FirmwareVersion = insteon.FirmwareVersion(msg.Dst[2])
+				partial.info.DevCat = insteon.DevCat{msg.Dst[0], msg.Dst[1]}
+				partial.devCat = true
+			})
 		}
-	} else {
-		insteon.Log.Infof("Failed to unmarshal buffer: %v", err)
+	} else if msg.Command[1] == 0x0d && (msg.Ack() || (msg.Nak() && msg.Command[2] == 0xff)) {
+		// Engine Version Request ACK, a NAK means the device is an
+		// I2CS device that isn't linked to the bridge
+		version := insteon.EngineVersion(msg.Command[2])
+		if msg.Nak() {
+			version = insteon.VerI2Cs
+		}
+
+		network.learn(msg.Src, func(partial *partialInfo) {
+			partial.info.EngineVersion = version
+			partial.version = true
+		})
 	}
+
+	network.demux.Dispatch(msg)
 }
 
-func (network *Network) disconnect(connection insteon.Connection) {
-	for i, conn := range network.connections {
-		if conn == connection {
-			if closer, ok := conn.(io.Closer); ok {
-				closer.Close()
-			}
-			network.connections = append(network.connections[0:i], network.connections[i+1:]...)
-			break
+// learn updates what is known about the device at address.  Devices that are
+// already in the product database are updated there, otherwise the device
+// is added to the database once both the engine version and device category
+// are known.  Each learned device is saved with a single database update,
+// which is made after network.mu is released
+func (network *Network) learn(address insteon.Address, update func(*partialInfo)) {
+	network.mu.Lock()
+	partial, found := network.partial[address]
+	if !found {
+		partial = &partialInfo{info: insteon.DeviceInfo{Address: address}}
+		if info, found := network.DB.Find(address); found {
+			partial.info = info
+			partial.version, partial.devCat = true, true
 		}
 	}
-}
 
-/*func (network *Network) sendMessage(msg *insteon.Message) error {
-	buf, err := msg.MarshalBinary()
+	update(partial)
+	complete := partial.version && partial.devCat
+	if complete {
+		delete(network.partial, address)
+	} else {
+		network.partial[address] = partial
+	}
+	network.mu.Unlock()
 
-	if err == nil {
-		insteon.Log.Tracef("Sending %v to network", msg)
-		if info, found := network.DB.Find(msg.Dst); found {
-			if msg.Flags.Extended() && info.EngineVersion == insteon.VerI2Cs {
-				buf[len(buf)-1] = checksum(buf[7:22])
-			}
-		}
-		insteon.Log.Tracef("Sending %v to network", msg)
-		err = network.bridge.Send(buf)
+	if complete {
+		network.DB.Update(partial.info)
 	}
-	return err
-}*/
+}
+
+func (network *Network) connect(dst insteon.Address) (insteon.Connection, error) {
+	return network.demux.New(dst, insteon.ConnectionTimeout(network.timeout))
+}
 
 // EngineVersion will query the dst device to determine its Insteon engine
 // version
 func (network *Network) EngineVersion(dst insteon.Address) (engineVersion insteon.EngineVersion, err error) {
-	/*conn := network.connect(dst, 1, CmdGetEngineVersion)
-	defer func() { close(conn.sendCh) }()
-
-	doneCh := make(chan *MessageRequest, 1)
-	request := &MessageRequest{Message: &Message{Command: CmdGetEngineVersion, Flags: StandardDirectMessage}, DoneCh: doneCh}
-	conn.sendCh <- request
-	<-doneCh
-
-	if request.Err == nil {
-		engineVersion = EngineVersion(request.Ack.Command[2])
-	}*/
-	return engineVersion, nil
+	conn, err := network.connect(dst)
+	if err == nil {
+		engineVersion, err = conn.EngineVersion()
+	}
+	return engineVersion, err
 }
 
 // IDRequest will send an ID Request message to the destination device and wait for
@@ -168,79 +205,76 @@ func (network *Network) EngineVersion(dst insteon.Address) (engineVersion insteo
 // DeviceInfo object will not have the engine version field populated as this information
 // is not included in the broadcast response.
 func (network *Network) IDRequest(dst insteon.Address) (info insteon.DeviceInfo, err error) {
-	info = insteon.DeviceInfo{
-		Address: dst,
+	info.Address = dst
+	conn, err := network.connect(dst)
+	if err == nil {
+		info.FirmwareVersion, info.DevCat, err = conn.IDRequest()
 	}
-	conn := network.connect(dst, 1, insteon.CmdSetButtonPressedResponder, insteon.CmdSetButtonPressedController)
+	return info, err
+}
 
-	_, err = conn.Send(&insteon.Message{Command: insteon.CmdIDRequest, Flags: insteon.StandardDirectMessage})
-	timeout := time.Now().Add(network.timeout)
-	for err == nil {
-		var msg *insteon.Message
-		msg, err = conn.Receive()
-		if err == nil {
-			if msg.Broadcast() {
-				info, _ = network.DB.Find(dst)
-				return
-			} else if timeout.Before(time.Now()) {
-				err = insteon.ErrReadTimeout
-			}
-		}
+// identify queries the device for whatever information hasn't
+// already been learned from traffic
+func (network *Network) identify(conn insteon.Connection) (info insteon.DeviceInfo, err error) {
+	network.mu.Lock()
+	partial := partialInfo{info: insteon.DeviceInfo{Address: conn.Address()}}
+	if p, found := network.partial[conn.Address()]; found {
+		partial = *p
 	}
+	network.mu.Unlock()
 
-	return
-}
+	info = partial.info
+	if !partial.version {
+		info.EngineVersion, err = conn.EngineVersion()
+	}
 
-func (network *Network) connect(dst insteon.Address, version insteon.EngineVersion, match ...insteon.Command) insteon.Connection {
-	/*connection := insteon.NewConnection(network.bridge, dst, version, network.timeout, match...)
-	network.connectCh <- connection
-	return connection
-	*/
-	return nil
+	if err == nil && !partial.devCat {
+		info.FirmwareVersion, info.DevCat, err = conn.IDRequest()
+	}
+	return info, err
 }
 
-// Connect will Dial the destination device and then determine the device category
-// in order to return a category specific device (dimmer, switch, etc). If, for
-// some reason, the devcat cannot be determined, then the device returned
-// by Dial is returned
+// Connect returns a category specific device (dimmer, switch, etc) for the
+// destination device.  If the device is in the product database then no
+// messages are sent to the device, otherwise the device is queried for
+// its engine version and device category.  If the device category is not
+// registered then the base device (I1Device, I2Device or I2CsDevice) is
+// returned.  An I2CS device that is not linked to the bridge is returned
+// as an I2CsDevice along with ErrNotLinked.  Devices are re-validated the
+// same way as insteon.OpenCached, so if a device returns insteon.ErrStaleInfo
+// the product database has been corrected and the device should be
+// connected again
 func (network *Network) Connect(dst insteon.Address) (device insteon.Device, err error) {
-	/*
-		var info insteon.DeviceInfo
-		var found bool
-		if info, found = network.DB.Find(dst); !found {
-			info.EngineVersion, err = network.EngineVersion(dst)
-			if err == nil {
-				info, err = network.IDRequest(dst)
-			}
+	conn, err := network.connect(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, found := network.DB.Find(dst); !found {
+		info, err := network.identify(conn)
+		if err == insteon.ErrNotLinked {
+			device, _ = insteon.New(insteon.VerI2Cs, conn, network.timeout)
+			return device, err
+		} else if err != nil {
+			return nil, err
 		}
+		network.DB.Update(info)
+	}
 
-		if err == nil {
-			if constructor, found := insteon.Devices.Find(info.DevCat.Category()); found {
-				bridge := network.connect(dst, info.EngineVersion)
-				device, err = constructor(info, dst, bridge, network.timeout)
-			} else {
-				device, err = network.Dial(dst)
-			}
-		}*/
-	return
+	return insteon.OpenCached(conn, network.timeout, network.DB)
 }
 
-func (network *Network) close() error {
-	network.connections = nil
-	return nil
-}
+// Close stops processing traffic from the bridge and then closes the bridge
+func (network *Network) Close() (err error) {
+	network.closeOnce.Do(func() {
+		close(network.closeCh)
 
-// Close will cleanup/close open connections and disconnect gracefully
-func (network *Network) Close() error {
-	ch := make(chan error)
-	network.closeCh <- ch
-	close(network.closeCh)
-	err := <-ch
-	if closer, ok := network.connection.(io.Closer); ok {
-		err1 := closer.Close()
-		if err == nil {
-			err = err1
+		switch closer := network.bridge.(type) {
+		case io.Closer:
+			err = closer.Close()
+		case interface{ Close() }:
+			closer.Close()
 		}
-	}
+	})
 	return err
 }
@@ -14,336 +14,270 @@
 
 package network
 
-/*
 import (
-	"reflect"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+	"github.com/abates/insteon/plm/plmtest"
 )
 
 var (
-	testSrcAddr = insteon.Address{1, 2, 3}
-	testDstAddr = insteon.Address{3, 4, 5}
-
-	TestMessageSetButtonPressedController = &insteon.Message{testDstAddr, testSrcAddr, insteon.StandardBroadcast, insteon.Command{0x00, 0x02, 0xff}, nil}
-	TestMessageEngineVersionAck           = &insteon.Message{testDstAddr, testSrcAddr, insteon.StandardDirectAck, insteon.Command{0x00, 0x0d, 0x01}, nil}
-
-	TestProductDataResponse = &insteon.Message{testDstAddr, testSrcAddr, insteon.ExtendedDirectMessage, insteon.CmdProductDataResp, []byte{0, 1, 2, 3, 4, 5, 0xff, 0xff, 0, 0, 0, 0, 0, 0}}
+	testModemAddr = insteon.Address{1, 2, 3}
+	testDstAddr   = insteon.Address{3, 4, 5}
 )
 
-type testBridge struct {
-	rx      chan []byte
-	send    chan []byte
-	sendErr error
-}
-
-func (tb *testBridge) Send(buf []byte) error {
-	tb.send <- buf
-	return tb.sendErr
-}
-
-func (tb *testBridge) Receive() <-chan []byte {
-	return tb.rx
-}
-
-func newTestNetwork() (*Network, *testBridge) {
-	bridge := &testBridge{
-		rx:   make(chan []byte),
-		send: make(chan []byte, 1),
+func newTestNetwork(t *testing.T, options ...plmtest.NetworkOption) (*Network, *plmtest.Network) {
+	t.Helper()
+	sim := plmtest.NewNetwork(options...)
+	p, err := plm.New(plm.NewPort(sim.NewModem(testModemAddr), time.Second), time.Second, plm.WriteDelay(0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	return New(bridge, time.Millisecond), bridge
-}
 
-/*func TestNetworkProcess(t *testing.T) {
-	connection := make(chan *Message, 1)
-	network, _, recvCh := newTestNetwork(0)
-	network.connectCh <- connection
-
-	buf, _ := TestMessagePingAck.MarshalBinary()
-	recvCh <- buf
-	select {
-	case <-connection:
-	case <-time.After(time.Millisecond):
-		t.Error("Expected connection to receive a message")
+	network, err := New(p, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-
-	network.disconnectCh <- connection
-	network.Close()
-
-	if len(network.connections) != 0 {
-		t.Errorf("Expected connnection queue to be empty, got %d", len(network.connections))
-	}
-}
-
-type testConnection struct {
-	rxCh chan *insteon.Message
-}
-
-func (tc *testConnection) Send(msg *insteon.Message) (*insteon.Message, error) { return nil, nil }
-func (tc *testConnection) Push(msg *insteon.Message)                           { tc.rxCh <- msg }
-func (tc *testConnection) Receive() (*insteon.Message, error) {
-	return <-tc.rxCh, nil
+	return network, sim
 }
 
 func TestNetworkReceive(t *testing.T) {
+	setButton := &insteon.Message{Dst: insteon.Address{0x01, 0x20, 0x45}, Src: testDstAddr, Flags: insteon.StandardBroadcast, Command: insteon.CmdSetButtonPressedController}
+	versionAck := &insteon.Message{Dst: testModemAddr, Src: testDstAddr, Flags: insteon.StandardDirectAck, Command: insteon.Command{0x00, 0x0d, 0x02}}
+	notLinked := &insteon.Message{Dst: testModemAddr, Src: testDstAddr, Flags: insteon.StandardDirectNak, Command: insteon.Command{0x00, 0x0d, 0xff}}
+	groupCmd := &insteon.Message{Dst: insteon.Address{0x00, 0x00, 0x01}, Src: testDstAddr, Flags: insteon.StandardAllLinkBroadcast, Command: insteon.Command{0x00, 0x02, 0x00}}
 
 	tests := []struct {
 		desc            string
-		input           *insteon.Message
+		known           bool
+		input           []*insteon.Message
 		expectedUpdates []string
+		wantWrites      int
 	}{
-		{"SetButtonPressed", TestMessageSetButtonPressedController, []string{"FirmwareVersion", "DevCat"}},
-		{"EngineVersion", TestMessageEngineVersionAck, []string{"EngineVersion"}},
+		{"Known SetButtonPressed", true, []*insteon.Message{setButton}, []string{"FirmwareVersion", "DevCat"}, 1},
+		{"Known EngineVersion", true, []*insteon.Message{versionAck}, []string{"EngineVersion"}, 1},
+		{"Unknown SetButtonPressed", false, []*insteon.Message{setButton}, nil, 0},
+		{"Unknown EngineVersion", false, []*insteon.Message{versionAck}, nil, 0},
+		{"Unknown Complete", false, []*insteon.Message{setButton, versionAck}, []string{"FirmwareVersion", "DevCat", "EngineVersion"}, 1},
+		{"Not Linked", false, []*insteon.Message{notLinked, setButton}, []string{"FirmwareVersion", "DevCat", "EngineVersion"}, 1},
+		{"Group Command", true, []*insteon.Message{groupCmd}, nil, 0},
 	}
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
 			testDb := newTestProductDB()
-			conn := &testConnection{
-				rxCh: make(chan *insteon.Message, 1),
+			if test.known {
+				testDb.deviceInfo = &insteon.DeviceInfo{Address: testDstAddr}
 			}
 
-			bridge := &testBridge{rx: make(chan []byte)}
-
 			network := &Network{
-				bridge:      bridge,
-				DB:          testDb,
-				connections: []insteon.Connection{conn},
-				closeCh:     make(chan chan error, 1),
+				DB:      testDb,
+				demux:   insteon.NewDemux(nil),
+				partial: make(map[insteon.Address]*partialInfo),
 			}
 
-			go network.process()
-			buf, _ := test.input.MarshalBinary()
-			bridge.rx <- buf
-			closeCh := make(chan error)
-			network.closeCh <- closeCh
-			<-closeCh
-
-			for _, update := range test.expectedUpdates {
-				if !testDb.WasUpdated(update) {
-					t.Errorf("expected %v to be updated in the database", update)
-				}
+			for _, msg := range test.input {
+				network.receive(msg)
 			}
 
-			if len(conn.rxCh) != 1 {
-				t.Error("expected connection to have received the message")
-			}
-		})
-	}
-}
-
-func TestNetworkEngineVersion(t *testing.T) {
-	tests := []struct {
-		desc            string
-		returnedAck     *insteon.Message
-		returnedErr     error
-		expectedVersion insteon.EngineVersion
-	}{
-		{"v1", TestMessageEngineVersionAck, nil, 1},
-		{"v2", TestMessageEngineVersionAck, nil, 2},
-		{"v3", TestMessageEngineVersionAck, nil, 3},
-		{"err", TestMessageEngineVersionAck, insteon.ErrReadTimeout, 0},
-	}
-
-	for _, test := range tests {
-		t.Run(test.desc, func(t *testing.T) {
-			network, bridge := newTestNetwork()
-
-			go func() {
-				if test.returnedErr == nil {
-					<-bridge.send
-					ack := *test.returnedAck
-					ack.Src = testDstAddr
-					ack.Command = insteon.Command{0x00, ack.Command[1], byte(test.expectedVersion)}
-					buf, _ := ack.MarshalBinary()
-					bridge.rx <- buf
+			for _, update := range []string{"FirmwareVersion", "DevCat", "EngineVersion"} {
+				expected := false
+				for _, e := range test.expectedUpdates {
+					expected = expected || e == update
 				}
-			}()
 
-			version, err := network.EngineVersion(testDstAddr)
-			if err != test.returnedErr {
-				t.Errorf("got error %v, want %v", err, test.returnedErr)
+				if testDb.WasUpdated(update) != expected {
+					t.Errorf("want %v updated %v", update, expected)
+				}
 			}
 
-			if version != test.expectedVersion {
-				t.Errorf("got version %v, want %v", version, test.expectedVersion)
+			// each learned device is written to the database once
+			if testDb.writes != test.wantWrites {
+				t.Errorf("want %d writes got %d", test.wantWrites, testDb.writes)
 			}
-			network.Close()
 		})
 	}
 }
 
-func TestNetworkIDRequest(t *testing.T) {
+func TestNetworkConnect(t *testing.T) {
 	tests := []struct {
-		desc             string
-		timeout          bool
-		returnedErr      error
-		expectedErr      error
-		expectedDevCat   insteon.DevCat
-		expectedFirmware insteon.FirmwareVersion
+		desc    string
+		version insteon.EngineVersion
+		devCat  insteon.DevCat
+		links   []*insteon.LinkRecord
+		want    string
+		wantErr error
 	}{
-		{"1", false, insteon.ErrReadTimeout, insteon.ErrReadTimeout, insteon.DevCat{0, 0}, 0},
-		{"2", false, nil, nil, insteon.DevCat{1, 2}, 3},
-		{"3", false, nil, nil, insteon.DevCat{2, 3}, 4},
-		{"4", true, nil, insteon.ErrReadTimeout, insteon.DevCat{0, 0}, 0},
+		{"I1 Device", insteon.VerI1, insteon.DevCat{0x07, 0x00}, nil, "I1 Device (03.04.05)", nil},
+		{"I2 Dimmer", insteon.VerI2, insteon.DevCat{0x01, 0x20}, nil, "Dimmer (03.04.05)", nil},
+		{"I2CS Switch", insteon.VerI2Cs, insteon.DevCat{0x02, 0x2a}, []*insteon.LinkRecord{insteon.ResponderLink(1, testModemAddr)}, "Switch (03.04.05)", nil},
+		{"I2CS Not Linked", insteon.VerI2Cs, insteon.DevCat{0x02, 0x2a}, nil, "I2CS Device (03.04.05)", insteon.ErrNotLinked},
 	}
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			network, bridge := newTestNetwork()
-
-			go func() {
-				buf := <-bridge.send
-				if test.returnedErr == nil {
-					// the test has to send an ACK, since the device would ack the set button pressed
-					// command before sending a broadcast response
-					ack := &insteon.Message{}
-					ack.UnmarshalBinary(buf)
-					src := ack.Dst
-					ack.Dst = ack.Src
-					ack.Src = src
-					ack.Flags = insteon.StandardDirectAck
-					buf, _ := ack.MarshalBinary()
-					bridge.rx <- buf
-
-					if !test.timeout {
-						// send the broadcast
-						msg := *TestMessageSetButtonPressedController
-						msg.Src = src
-						msg.Dst = insteon.Address{test.expectedDevCat[0], test.expectedDevCat[1], byte(test.expectedFirmware)}
-						buf, _ := msg.MarshalBinary()
-						bridge.rx <- buf
-					}
-				}
-			}()
-
-			info, err := network.IDRequest(testDstAddr)
+			network, sim := newTestNetwork(t)
+			defer sim.Close()
+			defer network.Close()
+			sim.NewDevice(testDstAddr, test.version, test.devCat, plmtest.Firmware(0x45), plmtest.Links(test.links...))
+
+			device, err := network.Connect(testDstAddr)
+			if err != test.wantErr {
+				t.Fatalf("want error %v got %v", test.wantErr, err)
+			}
 
-			if err != test.expectedErr {
-				t.Errorf("got error %v, want %v", err, test.expectedErr)
+			if got := fmt.Sprintf("%v", device); got != test.want {
+				t.Errorf("want %q got %q", test.want, got)
 			}
 
-			if info.FirmwareVersion != test.expectedFirmware {
-				t.Errorf("got FirmwareVersion %v, want %v", info.FirmwareVersion, test.expectedFirmware)
+			info, found := network.DB.Find(testDstAddr)
+			if test.wantErr != nil {
+				if found {
+					t.Errorf("want device to not be in the database got %+v", info)
+				}
+				return
 			}
 
-			if info.DevCat != test.expectedDevCat {
-				t.Errorf("got DevCat %v, want %v", info.DevCat, test.expectedDevCat)
+			want := insteon.DeviceInfo{Address: testDstAddr, DevCat: test.devCat, FirmwareVersion: 0x45, EngineVersion: test.version}
+			if !found {
+				t.Errorf("want device to be in the database")
+			} else if info != want {
+				t.Errorf("want %+v got %+v", want, info)
 			}
-			network.Close()
 		})
 	}
 }
 
-func TestNetworkDial(t *testing.T) {
-	tests := []struct {
-		desc          string
-		deviceInfo    *insteon.DeviceInfo
-		engineVersion byte
-		sendError     error
-		expectedErr   error
-		expected      interface{}
-	}{
-		{"1", &insteon.DeviceInfo{EngineVersion: insteon.VerI1}, 0, nil, nil, &insteon.I1Device{}},
-		{"2", &insteon.DeviceInfo{EngineVersion: insteon.VerI2}, 0, nil, nil, &insteon.I2Device{}},
-		{"3", &insteon.DeviceInfo{EngineVersion: insteon.VerI2Cs}, 0, nil, nil, &insteon.I2CsDevice{}},
-		{"4", nil, 0, nil, nil, &insteon.I1Device{}},
-		{"5", nil, 1, nil, nil, &insteon.I2Device{}},
-		{"6", nil, 2, nil, nil, &insteon.I2CsDevice{}},
-		{"7", nil, 3, nil, insteon.ErrVersion, nil},
-		{"8", nil, 0, insteon.ErrNotLinked, insteon.ErrNotLinked, &insteon.I2CsDevice{}},
+func TestNetworkConnectCached(t *testing.T) {
+	// every message is lost, so the device must be
+	// created from the product database
+	network, sim := newTestNetwork(t, plmtest.MessageLoss(1))
+	defer sim.Close()
+	defer network.Close()
+
+	network.DB.UpdateEngineVersion(testDstAddr, insteon.VerI2)
+	network.DB.UpdateDevCat(testDstAddr, insteon.DevCat{0x01, 0x20})
+
+	device, err := network.Connect(testDstAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	for _, test := range tests {
-		t.Run(test.desc, func(t *testing.T) {
-			testDb := newTestProductDB()
-			network, bridge := newTestNetwork()
-			network.DB = testDb
-
-			if test.deviceInfo == nil {
-				bridge.sendErr = test.sendError
-				go func() {
-					<-bridge.send
-					if test.sendError == nil {
-						msg := *TestMessageEngineVersionAck
-						msg.Src = insteon.Address{1, 2, 3}
-						msg.Command = insteon.Command{0x00, msg.Command[1], byte(test.engineVersion)}
-						buf, _ := msg.MarshalBinary()
-						bridge.rx <- buf
-					}
-				}()
-			} else {
-				testDb.deviceInfo = test.deviceInfo
-			}
+	if _, ok := device.(insteon.Dimmer); !ok {
+		t.Errorf("want insteon.Dimmer got %T", device)
+	}
+}
 
-			device, err := network.Dial(insteon.Address{1, 2, 3})
+func TestNetworkLearn(t *testing.T) {
+	network, sim := newTestNetwork(t)
+	defer sim.Close()
+	defer network.Close()
+	dev := sim.NewDevice(testDstAddr, insteon.VerI2, insteon.DevCat{0x01, 0x20}, plmtest.Firmware(0x45))
+
+	// the device category is learned from the set button broadcast, so
+	// only the engine version is needed to complete the device info
+	dev.PressSetButton(1)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		network.mu.Lock()
+		_, found := network.partial[testDstAddr]
+		network.mu.Unlock()
+		if found {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
 
-			if err != test.expectedErr {
-				t.Errorf("got error %v, want %v", err, test.expectedErr)
-			} else if reflect.TypeOf(device) != reflect.TypeOf(test.expected) {
-				t.Fatalf("got type %T, want type %T", device, test.expected)
-			}
+	if _, err := network.EngineVersion(testDstAddr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
-			network.Close()
-		})
+	want := insteon.DeviceInfo{Address: testDstAddr, DevCat: insteon.DevCat{0x01, 0x20}, FirmwareVersion: 0x45, EngineVersion: insteon.VerI2}
+	if info, found := network.DB.Find(testDstAddr); !found {
+		t.Errorf("want device to be in the database")
+	} else if info != want {
+		t.Errorf("want %+v got %+v", want, info)
 	}
 }
 
 func TestNetworkClose(t *testing.T) {
-	network, _ := newTestNetwork()
-	network.Close()
+	network, sim := newTestNetwork(t)
+	defer sim.Close()
+
+	if err := network.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
 
 	select {
-	case _, open := <-network.closeCh:
-		if open {
-			t.Error("Expected closeCh to be closed")
-		}
+	case <-network.closeCh:
 	default:
-		t.Error("Expected read from closeCh to indicate a closed channel")
+		t.Error("Expected closeCh to be closed")
+	}
+
+	// closing twice is harmless
+	if err := network.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
 	}
 }
 
-func TestNetworkConnect(t *testing.T) {
-	tests := []struct {
-		deviceInfo    *DeviceInfo
-		engineVersion EngineVersion
-		dst           Address
-		expected      Device
-	}{
-		{&DeviceInfo{DevCat: DevCat{42, 2}}, VerI1, Address{}, &I2Device{}},
-		{nil, VerI1, Address{42, 2, 3}, &I2Device{}},
+func TestNetworkConnectStale(t *testing.T) {
+	network, sim := newTestNetwork(t)
+	defer sim.Close()
+	defer network.Close()
+
+	// the database says the device is an I2 dimmer but it has been
+	// replaced with an I2CS switch that isn't linked to the modem
+	sim.NewDevice(testDstAddr, insteon.VerI2Cs, insteon.DevCat{0x02, 0x2a}, plmtest.Firmware(0x45))
+	network.DB.Update(insteon.DeviceInfo{Address: testDstAddr, DevCat: insteon.DevCat{0x01, 0x20}, FirmwareVersion: 0x45, EngineVersion: insteon.VerI2})
+
+	device, err := network.Connect(testDstAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	for _, test := range tests {
-		var category Category
-		testDb := newTestProductDB()
-		bridge := &testBridge{}
-
-		if test.deviceInfo == nil {
-			msg := *TestMessageEngineVersionAck
-			msg.Command[1] = byte(test.engineVersion)
-
-			msg = *TestMessageSetButtonPressedController
-			msg.Dst = test.dst
-			category = Category(test.dst[0])
-		} else {
-			testDb.deviceInfo = test.deviceInfo
-			category = test.deviceInfo.DevCat.Category()
-		}
-		Devices.Register(category, func(Device, DeviceInfo) Device { return test.expected })
+	if err := device.(insteon.Dimmer).On(); err != insteon.ErrStaleInfo {
+		t.Errorf("want error %v got %v", insteon.ErrStaleInfo, err)
+	}
 
-		network := &NetworkImpl{
-			db:     testDb,
-			bridge: bridge,
-		}
+	want := insteon.DeviceInfo{Address: testDstAddr, DevCat: insteon.DevCat{0x02, 0x2a}, FirmwareVersion: 0x45, EngineVersion: insteon.VerI2Cs}
+	if info, _ := network.DB.Find(testDstAddr); info != want {
+		t.Errorf("want %+v got %+v", want, info)
+	}
 
-		device, _ := network.Connect(Address{1, 2, 3})
+	device, err = network.Connect(testDstAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
-		if device != test.expected {
-			t.Fatalf("expected %v got %v", test.expected, device)
-		}
-		Devices.Delete(category)
+	if _, ok := device.(insteon.Dimmer); ok {
+		t.Errorf("want insteon.Switch got %T", device)
 	}
-}*/
+}
+
+func TestNetworkUnreadConnection(t *testing.T) {
+	network, sim := newTestNetwork(t)
+	defer sim.Close()
+	defer network.Close()
+
+	otherAddr := insteon.Address{6, 7, 8}
+	dev := sim.NewDevice(testDstAddr, insteon.VerI2, insteon.DevCat{0x01, 0x20}, plmtest.Firmware(0x45))
+	sim.NewDevice(otherAddr, insteon.VerI2, insteon.DevCat{0x02, 0x2a}, plmtest.Firmware(0x45))
+
+	// nothing ever reads from this connection
+	if _, err := network.connect(testDstAddr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the paddle is pressed over and over, filling the connection
+	for i := 0; i < 20; i++ {
+		dev.SendGroupCommand(1, insteon.CmdLightOn)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := network.EngineVersion(otherAddr); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+var (
+	// ErrCaptureFormat is returned when a capture file can't be parsed
+	ErrCaptureFormat = errors.New("invalid capture format")
+
+	// ErrReplayMismatch is returned by Replay.Write when the bytes written
+	// are not the next bytes that were sent in the capture
+	ErrReplayMismatch = errors.New("write does not match capture")
+
+	// ErrReplayEnded is returned by Replay.Write when every write in the
+	// capture has already been replayed
+	ErrReplayEnded = errors.New("capture has no more writes")
+)
+
+// Capture directions
+const (
+	CaptureTX = "TX"
+	CaptureRX = "RX"
+)
+
+// CaptureEntry is a single read or write in a capture.  TX entries are
+// bytes written to the PLM and RX entries are bytes read from the PLM
+type CaptureEntry struct {
+	Time      time.Time
+	Direction string
+	Data      []byte
+}
+
+func (ce CaptureEntry) String() string {
+	return fmt.Sprintf("%s %s %s", ce.Time.Format(time.RFC3339Nano), ce.Direction, hexDump("%02x", ce.Data, " "))
+}
+
+// ReadCapture parses a capture that was written by a Recorder.  Each line
+// of a capture is a timestamp, a direction (TX or RX) and the hex bytes that
+// were transferred.  Blank lines and lines beginning with # are ignored
+func ReadCapture(reader io.Reader) (entries []CaptureEntry, err error) {
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 || (fields[1] != CaptureTX && fields[1] != CaptureRX) {
+			return nil, fmt.Errorf("line %d: %v", lineNum, ErrCaptureFormat)
+		}
+
+		entry := CaptureEntry{Direction: fields[1]}
+		entry.Time, err = time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, ErrCaptureFormat)
+		}
+
+		entry.Data, err = hex.DecodeString(strings.Join(fields[2:], ""))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, ErrCaptureFormat)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Recorder wraps the byte stream that is passed to NewPort and writes every
+// read and write to a capture.  Writes are recorded as TX lines and reads
+// as RX lines, each with the time of the transfer.  Since a read
+// returns whatever bytes were available, an RX line may hold part of a frame
+// or several frames.  The capture can be read back with ReadCapture or
+// replayed with NewReplay
+type Recorder struct {
+	rw      io.ReadWriter
+	mu      sync.Mutex
+	capture io.Writer
+}
+
+// NewRecorder returns a Recorder that records the traffic on rw to capture
+func NewRecorder(rw io.ReadWriter, capture io.Writer) *Recorder {
+	return &Recorder{rw: rw, capture: capture}
+}
+
+func (r *Recorder) record(direction string, data []byte) {
+	entry := CaptureEntry{Time: time.Now(), Direction: direction, Data: data}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := fmt.Fprintln(r.capture, entry); err != nil {
+		insteon.Log.Infof("Failed to write capture: %v", err)
+	}
+}
+
+// Read reads from the underlying stream and records the bytes that were read
+func (r *Recorder) Read(p []byte) (n int, err error) {
+	n, err = r.rw.Read(p)
+	if n > 0 {
+		r.record(CaptureRX, p[:n])
+	}
+	return n, err
+}
+
+// Write records p and then writes it to the underlying stream.  Writes are
+// recorded first so that the response can never precede the command in
+// the capture
+func (r *Recorder) Write(p []byte) (n int, err error) {
+	r.record(CaptureTX, p)
+	return r.rw.Write(p)
+}
+
+// NotifyState passes connection state changes from the underlying stream
+// through to the PLM, if the underlying stream reports them
+func (r *Recorder) NotifyState(cb func(ConnState)) {
+	if notifier, ok := r.rw.(stateNotifier); ok {
+		notifier.NotifyState(cb)
+	}
+}
+
+// Close closes the underlying stream. The capture is left open
+func (r *Recorder) Close() error {
+	if closer, ok := r.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ReplayOption customizes a Replay
+type ReplayOption func(r *Replay)
+
+// ReplaySpeed scales the delays between entries in the capture.  A speed
+// of 2 replays the capture twice as fast as it was recorded. A speed of zero
+// returns RX data as soon as it is read
+func ReplaySpeed(speed float64) ReplayOption {
+	return func(r *Replay) {
+		r.speed = speed
+	}
+}
+
+// IgnoreWrites replays the RX data in the capture without waiting for, or
+// checking, the writes.  Anything written to the Replay is discarded
+func IgnoreWrites() ReplayOption {
+	return func(r *Replay) {
+		r.ignoreWrites = true
+	}
+}
+
+// Replay is a byte stream that plays back a capture so that it can be
+// passed to NewPort in place of a real PLM.  Replay is deterministic: RX
+// data that followed a write in the capture is not returned until the same
+// bytes are written to the Replay, and is then returned with the same delay
+// that was recorded.  This allows timeouts seen in the field to be reproduced
+// offline.  Writes that don't match the capture fail with ErrReplayMismatch
+type Replay struct {
+	entries      []CaptureEntry
+	speed        float64
+	ignoreWrites bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	rxNext  int
+	txNext  int
+	pending []byte
+
+	// anchor is the real time that the capture entry at anchorAt
+	// was replayed, RX delays are computed relative to it
+	anchor   time.Time
+	anchorAt time.Time
+
+	closed  bool
+	closeCh chan struct{}
+	doneCh  chan struct{}
+}
+
+// NewReplay parses the capture read from reader and returns a Replay for it
+func NewReplay(reader io.Reader, options ...ReplayOption) (*Replay, error) {
+	entries, err := ReadCapture(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replay{
+		entries: entries,
+		speed:   1,
+		anchor:  time.Now(),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mu)
+
+	if len(entries) > 0 {
+		r.anchorAt = entries[0].Time
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+	return r, nil
+}
+
+// Done returns a channel that is closed once all of the RX data in the
+// capture has been read
+func (r *Replay) Done() <-chan struct{} {
+	return r.doneCh
+}
+
+// nextRX skips over TX entries that have already been written and returns
+// the next entry to be replayed or nil if the capture has been completely
+// replayed. Must be called with the lock held
+func (r *Replay) nextRX() *CaptureEntry {
+	for ; r.rxNext < len(r.entries); r.rxNext++ {
+		entry := &r.entries[r.rxNext]
+		if entry.Direction == CaptureRX {
+			return entry
+		}
+
+		if !r.ignoreWrites && r.rxNext >= r.txNext {
+			// still waiting on the host
+			return entry
+		}
+	}
+	return nil
+}
+
+// Read returns the next RX data from the capture. Read blocks until the
+// writes that preceded the data in the capture have been made and the
+// recorded delay has passed. Read returns io.EOF once the capture has been
+// completely replayed or the Replay is closed
+func (r *Replay) Read(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(r.pending) == 0 {
+		if r.closed {
+			return 0, io.EOF
+		}
+
+		entry := r.nextRX()
+		if entry == nil {
+			select {
+			case <-r.doneCh:
+			default:
+				close(r.doneCh)
+			}
+			return 0, io.EOF
+		}
+
+		if entry.Direction == CaptureTX {
+			r.cond.Wait()
+			continue
+		}
+
+		if r.speed > 0 {
+			delay := time.Duration(float64(entry.Time.Sub(r.anchorAt)) / r.speed)
+			if wait := time.Until(r.anchor.Add(delay)); wait > 0 {
+				r.mu.Unlock()
+				select {
+				case <-time.After(wait):
+				case <-r.closeCh:
+				}
+				r.mu.Lock()
+				continue
+			}
+		}
+
+		r.pending = entry.Data
+		r.rxNext++
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Write checks that p is the next write in the capture and releases the
+// RX data that followed it
+func (r *Replay) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, ErrDisconnected
+	}
+
+	if r.ignoreWrites {
+		return len(p), nil
+	}
+
+	for ; r.txNext < len(r.entries); r.txNext++ {
+		if r.entries[r.txNext].Direction == CaptureTX {
+			break
+		}
+	}
+
+	if r.txNext >= len(r.entries) {
+		insteon.Log.Infof("Replay received unexpected write %s", hexDump("%02x", p, " "))
+		return 0, ErrReplayEnded
+	}
+
+	entry := r.entries[r.txNext]
+	if !bytes.Equal(entry.Data, p) {
+		insteon.Log.Infof("Replay expected write %s got %s", hexDump("%02x", entry.Data, " "), hexDump("%02x", p, " "))
+		return 0, ErrReplayMismatch
+	}
+
+	r.txNext++
+	r.anchor = time.Now()
+	r.anchorAt = entry.Time
+	r.cond.Broadcast()
+	return len(p), nil
+}
+
+// Close stops the replay, any blocked reads return io.EOF
+func (r *Replay) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.closeCh)
+		r.cond.Broadcast()
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package plm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+const testCapture = `# plm info
+2019-06-01T12:00:00Z TX 02 60
+2019-06-01T12:00:00.05Z RX 02 60 01 02 03 03 15 9b 06
+`
+
+func TestReadCapture(t *testing.T) {
+	tests := []struct {
+		desc    string
+		input   string
+		want    []CaptureEntry
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"comments", "# comment\n\n", nil, false},
+		{"entries", "2019-06-01T12:00:00Z TX 02 60\n2019-06-01T12:00:01Z RX 02 60 01\n", []CaptureEntry{
+			{time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), CaptureTX, []byte{0x02, 0x60}},
+			{time.Date(2019, 6, 1, 12, 0, 1, 0, time.UTC), CaptureRX, []byte{0x02, 0x60, 0x01}},
+		}, false},
+		{"bad direction", "2019-06-01T12:00:00Z XX 02 60\n", nil, true},
+		{"bad time", "noon TX 02 60\n", nil, true},
+		{"bad hex", "2019-06-01T12:00:00Z TX 02 6\n", nil, true},
+		{"no data", "2019-06-01T12:00:00Z TX\n", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := ReadCapture(strings.NewReader(test.input))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("want error %v got %v", test.wantErr, err)
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("want %d entries got %d", len(test.want), len(got))
+			}
+
+			for i, want := range test.want {
+				if !want.Time.Equal(got[i].Time) || want.Direction != got[i].Direction || !bytes.Equal(want.Data, got[i].Data) {
+					t.Errorf("want entry %v got %v", want, got[i])
+				}
+			}
+		})
+	}
+}
+
+type testReadWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func TestRecorder(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	capture := bytes.NewBuffer(nil)
+	recorder := NewRecorder(testReadWriter{bytes.NewReader([]byte{0x02, 0x6d, 0x06}), out}, capture)
+
+	if _, err := recorder.Write([]byte{0x02, 0x6d}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := make([]byte, 8)
+	if n, err := recorder.Read(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !bytes.Equal([]byte{0x02, 0x6d, 0x06}, buf[:n]) {
+		t.Errorf("want % x got % x", []byte{0x02, 0x6d, 0x06}, buf[:n])
+	}
+
+	if !bytes.Equal([]byte{0x02, 0x6d}, out.Bytes()) {
+		t.Errorf("want % x written got % x", []byte{0x02, 0x6d}, out.Bytes())
+	}
+
+	entries, err := ReadCapture(capture)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []CaptureEntry{{Direction: CaptureTX, Data: []byte{0x02, 0x6d}}, {Direction: CaptureRX, Data: []byte{0x02, 0x6d, 0x06}}}
+	if len(entries) != len(want) {
+		t.Fatalf("want %d entries got %d", len(want), len(entries))
+	}
+
+	for i, entry := range entries {
+		if entry.Direction != want[i].Direction || !bytes.Equal(entry.Data, want[i].Data) {
+			t.Errorf("want entry %v got %v", want[i], entry)
+		}
+	}
+}
+
+func TestReplayPLM(t *testing.T) {
+	tests := []struct {
+		desc    string
+		timeout time.Duration
+		wantErr error
+	}{
+		{"reply", time.Second, nil},
+		// the recorded reply took 50ms, so it can be used to reproduce a timeout
+		{"timeout", 10 * time.Millisecond, ErrAckTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			replay, err := NewReplay(strings.NewReader(testCapture))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			plm, err := New(NewPort(replay, test.timeout), test.timeout)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer plm.Close()
+
+			info, err := plm.Info()
+			if err != test.wantErr {
+				t.Fatalf("want error %v got %v", test.wantErr, err)
+			} else if err == nil && info.Address != (insteon.Address{1, 2, 3}) {
+				t.Errorf("want address 01.02.03 got %v", info.Address)
+			}
+
+			select {
+			case <-replay.Done():
+			case <-time.After(time.Second):
+				t.Errorf("timed out waiting for replay to finish")
+			}
+		})
+	}
+}
+
+func TestReplayWrite(t *testing.T) {
+	replay, err := NewReplay(strings.NewReader(testCapture), ReplaySpeed(0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer replay.Close()
+
+	if _, err := replay.Write([]byte{0x02, 0x6d}); err != ErrReplayMismatch {
+		t.Errorf("want error %v got %v", ErrReplayMismatch, err)
+	}
+
+	if _, err := replay.Write([]byte{0x02, 0x60}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := replay.Write([]byte{0x02, 0x60}); err != ErrReplayEnded {
+		t.Errorf("want error %v got %v", ErrReplayEnded, err)
+	}
+}
+
+func TestReplayIgnoreWrites(t *testing.T) {
+	replay, err := NewReplay(strings.NewReader(testCapture), IgnoreWrites(), ReplaySpeed(0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer replay.Close()
+
+	got, err := ioutil.ReadAll(replay)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []byte{0x02, 0x60, 0x01, 0x02, 0x03, 0x03, 0x15, 0x9b, 0x06}
+	if !bytes.Equal(want, got) {
+		t.Errorf("want % x got % x", want, got)
+	}
+}
@@ -47,7 +47,7 @@ const (
 	CmdLedOff                Command = 0x6e // LED Off
 	CmdManageAllLinkRecord   Command = 0x6f // Manage All Link Record
 	CmdSetNakMsgByte         Command = 0x70 // Set NAK Msg Byte
-	CmdSetNameMsgTwoBytes    Command = 0x71 // Set NAK Msg Two Bytes
+	CmdSetAckMsgTwoBytes     Command = 0x71 // Set ACK Msg Two Bytes
 	CmdRfSleep               Command = 0x72 // RF Sleep
 	CmdGetConfig             Command = 0x73 // Get Config
 )
@@ -81,7 +81,7 @@ func init() {
 		CmdLedOff:                1,
 		CmdManageAllLinkRecord:   10,
 		CmdSetNakMsgByte:         2,
-		CmdSetNameMsgTwoBytes:    3,
+		CmdSetAckMsgTwoBytes:     3,
 		CmdRfSleep:               1,
 		CmdGetConfig:             4,
 	}
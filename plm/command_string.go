@@ -7,7 +7,7 @@ import "strconv"
 const (
 	_Command_name_0 = "NAK"
 	_Command_name_1 = "Std Msg ReceivedExt Msg ReceivedX10 Msg ReceivedAll Link CompleteButton Event ReportUser Reset DetectedLink Cleanup ReportLink Record RespLink Cleanup Status"
-	_Command_name_2 = "Get InfoSend All LinkSend INSTEON MsgSend X10 MsgStart All LinkCancel All LinkSet Host CategoryResetSet ACK MsgGet First All LinkGet Next All LinkSet ConfigGet Sender All LinkLED OnLED OffManage All Link RecordSet NAK Msg ByteSet NAK Msg Two BytesRF SleepGet Config"
+	_Command_name_2 = "Get InfoSend All LinkSend INSTEON MsgSend X10 MsgStart All LinkCancel All LinkSet Host CategoryResetSet ACK MsgGet First All LinkGet Next All LinkSet ConfigGet Sender All LinkLED OnLED OffManage All Link RecordSet NAK Msg ByteSet ACK Msg Two BytesRF SleepGet Config"
 )
 
 var (
@@ -16,28 +16,61 @@ package plm
 
 import "fmt"
 
+// Config is the PLM's configuration byte.  The PLM uses bits 7, 5 and 4
+// to disable features, so the getters and setters below account for the
+// inverted bits
 type Config byte
 
-func (config *Config) setBit(pos uint) {
-	*config |= (1 << pos)
+const (
+	configDisableAutoLinking = 0x80
+	configMonitorMode        = 0x40
+	configDisableAutoLED     = 0x20
+	configDisableDeadman     = 0x10
+)
+
+func (config *Config) setBits(bits Config, set bool) {
+	if set {
+		*config |= bits
+	} else {
+		*config &= ^bits
+	}
+}
+
+// AutomaticLinking indicates that holding the PLM's set button will
+// put it into linking mode
+func (config Config) AutomaticLinking() bool { return config&configDisableAutoLinking == 0 }
+
+// SetAutomaticLinking enables or disables linking with the PLM's set button
+func (config *Config) SetAutomaticLinking(enabled bool) {
+	config.setBits(configDisableAutoLinking, !enabled)
 }
 
-func (config *Config) clearBit(pos uint) {
-	*config &= ^(1 << pos)
+// MonitorMode indicates that the PLM reports all messages it sees on the
+// network, not just the messages addressed to it
+func (config Config) MonitorMode() bool { return config&configMonitorMode == configMonitorMode }
+
+// SetMonitorMode enables or disables monitor mode
+func (config *Config) SetMonitorMode(enabled bool) {
+	config.setBits(configMonitorMode, enabled)
+}
+
+// AutomaticLED indicates that the PLM controls its own LED. When disabled
+// the LED is controlled with LEDOn and LEDOff
+func (config Config) AutomaticLED() bool { return config&configDisableAutoLED == 0 }
+
+// SetAutomaticLED enables or disables automatic LED control
+func (config *Config) SetAutomaticLED(enabled bool) {
+	config.setBits(configDisableAutoLED, !enabled)
 }
 
-func (config *Config) AutomaticLinking() bool { return (*config)&0x80 == 0x80 }
-func (config *Config) setAutomaticLinking()   { config.setBit(7) }
-func (config *Config) clearAutomaticLinking() { config.clearBit(7) }
-func (config *Config) MonitorMode() bool      { return (*config)&0x40 == 0x40 }
-func (config *Config) SetMonitorMode()        { config.setBit(6) }
-func (config *Config) clearMonitorMode()      { config.clearBit(6) }
-func (config *Config) AutomaticLED() bool     { return (*config)&0x20 == 0x20 }
-func (config *Config) setAutomaticLED()       { config.setBit(5) }
-func (config *Config) clearAutomaticLED()     { config.clearBit(5) }
-func (config *Config) DeadmanMode() bool      { return (*config)&0x10 == 0x10 }
-func (config *Config) setDeadmanMode()        { config.setBit(4) }
-func (config *Config) clearDeadmanMode()      { config.clearBit(4) }
+// DeadmanMode indicates that the PLM will discard a command if the host
+// pauses more than 240 milliseconds between bytes
+func (config Config) DeadmanMode() bool { return config&configDisableDeadman == 0 }
+
+// SetDeadmanMode enables or disables the deadman timer
+func (config *Config) SetDeadmanMode(enabled bool) {
+	config.setBits(configDisableDeadman, !enabled)
+}
 
 func (config Config) String() string {
 	str := ""
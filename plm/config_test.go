@@ -20,39 +20,38 @@ import (
 )
 
 func TestSettingConfigFlags(t *testing.T) {
-	config := Config(0x00)
-
 	tests := []struct {
 		desc     string
-		getter   func() bool
-		setter   func()
-		clearer  func()
-		expected byte
+		getter   func(Config) bool
+		setter   func(*Config, bool)
+		disabled byte
+		enabled  byte
 	}{
-		{"AutomaticLinking", config.AutomaticLinking, config.setAutomaticLinking, config.clearAutomaticLinking, 0x80},
-		{"MonitorMode", config.MonitorMode, config.SetMonitorMode, config.clearMonitorMode, 0x40},
-		{"AutomaticLED", config.AutomaticLED, config.setAutomaticLED, config.clearAutomaticLED, 0x20},
-		{"DeadmanMode", config.DeadmanMode, config.setDeadmanMode, config.clearDeadmanMode, 0x10},
+		{"AutomaticLinking", Config.AutomaticLinking, (*Config).SetAutomaticLinking, 0x80, 0x00},
+		{"MonitorMode", Config.MonitorMode, (*Config).SetMonitorMode, 0x00, 0x40},
+		{"AutomaticLED", Config.AutomaticLED, (*Config).SetAutomaticLED, 0x20, 0x00},
+		{"DeadmanMode", Config.DeadmanMode, (*Config).SetDeadmanMode, 0x10, 0x00},
 	}
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			if test.getter() {
-				t.Errorf("getter got %v, want false", test.getter())
+			config := Config(0x00)
+			test.setter(&config, false)
+			if test.getter(config) {
+				t.Errorf("getter got %v, want false", test.getter(config))
 			}
 
-			test.setter()
-			if !test.getter() {
-				t.Errorf("getter got %v, want true", test.getter())
+			if byte(config) != test.disabled {
+				t.Errorf("config got 0x%02x, want 0x%02x", byte(config), test.disabled)
 			}
 
-			if byte(config) != test.expected {
-				t.Errorf("config got 0x%02x, want 0x%02x", byte(config), test.expected)
+			test.setter(&config, true)
+			if !test.getter(config) {
+				t.Errorf("getter got %v, want true", test.getter(config))
 			}
 
-			test.clearer()
-			if byte(config) != 0x00 {
-				t.Errorf("config got 0x%02x, want 0x00", byte(config))
+			if byte(config) != test.enabled {
+				t.Errorf("config got 0x%02x, want 0x%02x", byte(config), test.enabled)
 			}
 		})
 	}
@@ -0,0 +1,190 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/abates/insteon"
+)
+
+// Event is an unsolicited report from the PLM. Events are one of
+// *AllLinkComplete, *ButtonEvent or *UserReset
+type Event interface {
+	fmt.Stringer
+}
+
+// AllLinkComplete is reported by the PLM when a link has been created
+// or deleted, either by the set button or after EnterLinkingMode
+type AllLinkComplete struct {
+	// Address is the address of the device on the other end of the link
+	Address insteon.Address
+
+	// Group is the All-Link group of the link
+	Group insteon.Group
+
+	// DevCat is the device category of the linked device
+	DevCat insteon.DevCat
+
+	// Firmware is the firmware version of the linked device
+	Firmware insteon.FirmwareVersion
+
+	// Controller indicates that the PLM is the controller for the link
+	Controller bool
+
+	// Deleted indicates that the link was removed rather than added
+	Deleted bool
+}
+
+func (alc *AllLinkComplete) UnmarshalBinary(buf []byte) error {
+	if len(buf) < 8 {
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 8, Got: len(buf)}
+	}
+
+	alc.Controller = buf[0] == 0x01
+	alc.Deleted = buf[0] == 0xff
+	alc.Group = insteon.Group(buf[1])
+	copy(alc.Address[:], buf[2:5])
+	copy(alc.DevCat[:], buf[5:7])
+	alc.Firmware = insteon.FirmwareVersion(buf[7])
+	return nil
+}
+
+func (alc *AllLinkComplete) String() string {
+	linkType := "responder"
+	if alc.Deleted {
+		linkType = "deleted"
+	} else if alc.Controller {
+		linkType = "controller"
+	}
+	return fmt.Sprintf("All-Link Complete %s group %v %s (category %v firmware %v)", alc.Address, alc.Group, linkType, alc.DevCat, alc.Firmware)
+}
+
+// ButtonAction is the action of the PLM's set button
+type ButtonAction byte
+
+// Set button actions reported by the PLM
+const (
+	ButtonTapped   ButtonAction = 0x02
+	ButtonHeld     ButtonAction = 0x03
+	ButtonReleased ButtonAction = 0x04
+)
+
+func (ba ButtonAction) String() string {
+	switch ba {
+	case ButtonTapped:
+		return "Tapped"
+	case ButtonHeld:
+		return "Held"
+	case ButtonReleased:
+		return "Released"
+	}
+	return fmt.Sprintf("ButtonAction(0x%02x)", byte(ba))
+}
+
+// ButtonEvent is reported by the PLM when its set button (or one of the
+// auxiliary buttons on some IMs) is used
+type ButtonEvent struct {
+	// Button is the button number. The set button is button 1
+	Button int
+
+	// Action is what was done with the button
+	Action ButtonAction
+}
+
+func (be *ButtonEvent) UnmarshalBinary(buf []byte) error {
+	if len(buf) < 1 {
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 1, Got: len(buf)}
+	}
+
+	be.Button = int(buf[0]>>4) + 1
+	be.Action = ButtonAction(buf[0] & 0x0f)
+	return nil
+}
+
+func (be *ButtonEvent) String() string {
+	return fmt.Sprintf("Button %d %v", be.Button, be.Action)
+}
+
+// UserReset is reported by the PLM when the user has factory reset it with
+// the set button.  The All-Link database and configuration have been erased
+type UserReset struct{}
+
+func (*UserReset) String() string { return "User Reset" }
+
+func decodeEvent(packet *Packet) (event Event, err error) {
+	switch packet.Command {
+	case CmdAllLinkComplete:
+		alc := &AllLinkComplete{}
+		event, err = alc, alc.UnmarshalBinary(packet.Payload)
+	case CmdButtonEventReport:
+		be := &ButtonEvent{}
+		event, err = be, be.UnmarshalBinary(packet.Payload)
+	case CmdUserResetDetected:
+		event = &UserReset{}
+	}
+	return event, err
+}
+
+// eventBus delivers events to subscribers.  Delivery never blocks, if a
+// subscriber's channel is full then the event is dropped for that subscriber
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers []chan<- Event
+}
+
+func (eb *eventBus) subscribe(ch chan<- Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.subscribers = append(eb.subscribers, ch)
+}
+
+func (eb *eventBus) unsubscribe(ch chan<- Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	for i, s := range eb.subscribers {
+		if s == ch {
+			eb.subscribers = append(eb.subscribers[:i], eb.subscribers[i+1:]...)
+			break
+		}
+	}
+}
+
+func (eb *eventBus) publish(event Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	insteon.Log.Debugf("PLM event: %v", event)
+	for _, ch := range eb.subscribers {
+		select {
+		case ch <- event:
+		default:
+			insteon.Log.Infof("Subscriber not ready, dropping event %v", event)
+		}
+	}
+}
+
+// Subscribe will deliver all subsequent PLM events to the given channel.
+// Events are delivered without blocking, so the channel should be buffered
+// and read promptly or events will be dropped
+func (plm *PLM) Subscribe(ch chan<- Event) {
+	plm.events.subscribe(ch)
+}
+
+// Unsubscribe stops event delivery to a channel previously passed to
+// Subscribe
+func (plm *PLM) Unsubscribe(ch chan<- Event) {
+	plm.events.unsubscribe(ch)
+}
@@ -0,0 +1,87 @@
+package plm
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	tests := []struct {
+		desc    string
+		input   *Packet
+		want    Event
+		wantErr error
+	}{
+		{"Controller", &Packet{Command: CmdAllLinkComplete, Payload: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x01, 0x20, 0x45}}, &AllLinkComplete{Address: insteon.Address{3, 4, 5}, Group: 2, DevCat: insteon.DevCat{0x01, 0x20}, Firmware: 0x45, Controller: true}, nil},
+		{"Responder", &Packet{Command: CmdAllLinkComplete, Payload: []byte{0x00, 0x01, 0x03, 0x04, 0x05, 0x02, 0x2a, 0x41}}, &AllLinkComplete{Address: insteon.Address{3, 4, 5}, Group: 1, DevCat: insteon.DevCat{0x02, 0x2a}, Firmware: 0x41}, nil},
+		{"Deleted", &Packet{Command: CmdAllLinkComplete, Payload: []byte{0xff, 0x01, 0x03, 0x04, 0x05, 0x02, 0x2a, 0x41}}, &AllLinkComplete{Address: insteon.Address{3, 4, 5}, Group: 1, DevCat: insteon.DevCat{0x02, 0x2a}, Firmware: 0x41, Deleted: true}, nil},
+		{"Short", &Packet{Command: CmdAllLinkComplete, Payload: []byte{0x01}}, nil, insteon.ErrBufferTooShort},
+		{"Set Button Tapped", &Packet{Command: CmdButtonEventReport, Payload: []byte{0x02}}, &ButtonEvent{Button: 1, Action: ButtonTapped}, nil},
+		{"Button 2 Held", &Packet{Command: CmdButtonEventReport, Payload: []byte{0x13}}, &ButtonEvent{Button: 2, Action: ButtonHeld}, nil},
+		{"User Reset", &Packet{Command: CmdUserResetDetected}, &UserReset{}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := decodeEvent(test.input)
+			if !insteon.IsError(err, test.wantErr) {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if err == nil && !reflect.DeepEqual(test.want, got) {
+				t.Errorf("want event %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	eb := &eventBus{}
+	ch1 := make(chan Event, 1)
+	ch2 := make(chan Event, 1)
+	eb.subscribe(ch1)
+	eb.subscribe(ch2)
+
+	eb.publish(&UserReset{})
+	// ch1 is full so the second event should be dropped instead of blocking
+	eb.unsubscribe(ch2)
+	eb.publish(&ButtonEvent{})
+
+	if len(ch1) != 1 || len(ch2) != 1 {
+		t.Fatalf("want one event in each channel got %d and %d", len(ch1), len(ch2))
+	}
+
+	if _, ok := (<-ch1).(*UserReset); !ok {
+		t.Errorf("expected first event to be a user reset")
+	}
+}
+
+func TestReadLoopEvents(t *testing.T) {
+	r, w := io.Pipe()
+	plm, _ := New(&Port{in: bufio.NewReader(r), out: bytes.NewBuffer(nil)}, time.Second)
+	ch := make(chan Event, 1)
+	plm.Subscribe(ch)
+
+	go func() {
+		// unsolicited packets that nobody is waiting for must not block
+		// the read loop
+		for i := 0; i < cap(plm.plmCh)+2; i++ {
+			w.Write([]byte{0x02, byte(CmdAllLinkCleanupStatus), 0x06})
+		}
+		w.Write([]byte{0x02, byte(CmdUserResetDetected)})
+	}()
+
+	select {
+	case event := <-ch:
+		if _, ok := event.(*UserReset); !ok {
+			t.Errorf("want *UserReset got %T", event)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for event")
+	}
+	w.Close()
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/abates/insteon"
+)
+
+// ErrCleanupInterrupted is returned when the PLM reports that the All-Link
+// cleanup sequence following a group command was interrupted by other
+// traffic
+var ErrCleanupInterrupted = errors.New("All-Link cleanup sequence was interrupted")
+
+// CleanupRetries can be passed as a parameter to New to have SendGroupCommand
+// retry responders that failed the PLM's cleanup sequence.  Each failed
+// responder is sent up to retries direct All-Link cleanup messages
+func CleanupRetries(retries int) Option {
+	return func(p *PLM) error {
+		if retries < 0 {
+			return fmt.Errorf("invalid cleanup retries %d, must be zero or greater", retries)
+		}
+		p.cleanupRetries = retries
+		return nil
+	}
+}
+
+// cleanupFailure is the payload of an All-Link Cleanup Failure Report
+type cleanupFailure struct {
+	group   insteon.Group
+	address insteon.Address
+}
+
+func (cf *cleanupFailure) UnmarshalBinary(buf []byte) error {
+	if len(buf) < 5 {
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 5, Got: len(buf)}
+	}
+	cf.group = insteon.Group(buf[1])
+	copy(cf.address[:], buf[2:5])
+	return nil
+}
+
+// SendGroupCommand will send the command to all the responders in the
+// given group. The PLM broadcasts the command and then follows up with
+// a direct cleanup message to each of the responders in its All-Link
+// database.  The addresses of any responders that did not acknowledge
+// the cleanup are returned.  If the PLM was configured with CleanupRetries
+// then the failed responders are retried before returning
+func (plm *PLM) SendGroupCommand(group insteon.Group, cmd insteon.Command) (failed []insteon.Address, err error) {
+	plm.Lock()
+	_, err = plm.send(&Packet{Command: CmdSendAllLink, Payload: []byte{byte(group), cmd[1], cmd[2]}})
+	for done := err != nil; !done; {
+		var pkt *Packet
+		pkt, err = plm.receive(plm.timeout)
+		if err != nil {
+			break
+		}
+
+		switch pkt.Command {
+		case CmdAllLinkCleanupFailure:
+			report := &cleanupFailure{}
+			if err = report.UnmarshalBinary(pkt.Payload); err == nil && report.group == group {
+				insteon.Log.Debugf("Group %v cleanup failed for %v", group, report.address)
+				failed = append(failed, report.address)
+			}
+		case CmdAllLinkCleanupStatus:
+			if len(pkt.Payload) > 0 && pkt.Payload[0] == 0x15 {
+				err = ErrCleanupInterrupted
+			}
+			done = true
+		}
+	}
+	plm.Unlock()
+
+	if err == nil && plm.cleanupRetries > 0 && len(failed) > 0 {
+		failed, err = plm.cleanup(group, cmd, failed)
+	}
+	return failed, err
+}
+
+// cleanup sends direct All-Link cleanup messages to each of the given
+// responders and returns the responders that still did not acknowledge
+// the cleanup
+func (plm *PLM) cleanup(group insteon.Group, cmd insteon.Command, responders []insteon.Address) (failed []insteon.Address, err error) {
+	for _, addr := range responders {
+		acked := false
+		for i := 0; i < plm.cleanupRetries && !acked && err == nil; i++ {
+			insteon.Log.Debugf("Retrying group %v cleanup for %v (attempt %d)", group, addr, i+1)
+			acked, err = plm.sendCleanup(addr, group, cmd)
+		}
+
+		if err != nil {
+			break
+		}
+
+		if !acked {
+			failed = append(failed, addr)
+		}
+	}
+	return failed, err
+}
+
+func (plm *PLM) sendCleanup(addr insteon.Address, group insteon.Group, cmd insteon.Command) (acked bool, err error) {
+	conn, err := plm.Connect(addr, insteon.ConnectionTimeout(plm.timeout))
+	if err == nil {
+		err = plm.Send(&insteon.Message{
+			Dst:     addr,
+			Flags:   insteon.Flag(insteon.MsgTypeAllLinkCleanup, false, 3, 3),
+			Command: insteon.Command{0x00, cmd[1], byte(group)},
+		})
+	}
+
+	if err == nil {
+		err = insteon.Receive(conn, plm.timeout, func(msg *insteon.Message) error {
+			if msg.Flags.Type() == insteon.MsgTypeAllLinkCleanupAck {
+				acked = true
+				return insteon.ErrReceiveComplete
+			} else if msg.Flags.Type() == insteon.MsgTypeAllLinkCleanupNak {
+				return insteon.ErrReceiveComplete
+			}
+			return nil
+		})
+
+		if err == insteon.ErrReadTimeout {
+			err = nil
+		}
+	}
+	return acked, err
+}
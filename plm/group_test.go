@@ -0,0 +1,84 @@
+package plm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+func TestSendGroupCommand(t *testing.T) {
+	failure := func(group byte, addr insteon.Address) *Packet {
+		return &Packet{Command: CmdAllLinkCleanupFailure, Payload: []byte{0x01, group, addr[0], addr[1], addr[2]}}
+	}
+
+	status := func(ack byte) *Packet {
+		return &Packet{Command: CmdAllLinkCleanupStatus, Payload: []byte{ack}}
+	}
+
+	sendAck := &Packet{Command: CmdSendAllLink, Payload: []byte{0x05, 0x11, 0x00}, Ack: 0x06}
+	msgAck := &Packet{Command: CmdSendInsteonMsg, Payload: []byte{0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x4f, 0x11, 0x05}, Ack: 0x06}
+	cleanupAck := &insteon.Message{Src: insteon.Address{1, 2, 3}, Flags: insteon.Flag(insteon.MsgTypeAllLinkCleanupAck, false, 3, 3), Command: insteon.Command{0x00, 0x11, 0x05}}
+
+	tests := []struct {
+		name       string
+		retries    int
+		rx         []*Packet
+		dispatch   []*insteon.Message
+		wantTx     []byte
+		wantFailed []insteon.Address
+		wantErr    error
+	}{
+		{
+			name:   "Success",
+			rx:     []*Packet{sendAck, status(0x06)},
+			wantTx: []byte{0x02, 0x61, 0x05, 0x11, 0x00},
+		},
+		{
+			name:       "Failed Responders",
+			rx:         []*Packet{sendAck, failure(5, insteon.Address{1, 2, 3}), failure(5, insteon.Address{4, 5, 6}), status(0x06)},
+			wantTx:     []byte{0x02, 0x61, 0x05, 0x11, 0x00},
+			wantFailed: []insteon.Address{{1, 2, 3}, {4, 5, 6}},
+		},
+		{
+			name:    "Interrupted",
+			rx:      []*Packet{sendAck, status(0x15)},
+			wantTx:  []byte{0x02, 0x61, 0x05, 0x11, 0x00},
+			wantErr: ErrCleanupInterrupted,
+		},
+		{
+			name:     "Retry",
+			retries:  1,
+			rx:       []*Packet{sendAck, failure(5, insteon.Address{1, 2, 3}), status(0x06), msgAck},
+			dispatch: []*insteon.Message{cleanupAck},
+			wantTx:   []byte{0x02, 0x61, 0x05, 0x11, 0x00, 0x02, 0x62, 0x01, 0x02, 0x03, 0x4f, 0x11, 0x05},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+			plm := &PLM{timeout: 10 * time.Millisecond, port: &Port{out: out}, plmCh: make(chan *Packet, len(test.rx)), cleanupRetries: test.retries}
+			plm.demux = insteon.NewDemux(plm)
+			for _, pkt := range test.rx {
+				plm.plmCh <- pkt
+			}
+
+			for _, msg := range test.dispatch {
+				plm.Connect(msg.Src)
+				plm.demux.Dispatch(msg)
+			}
+
+			gotFailed, err := plm.SendGroupCommand(5, insteon.Command{0x00, 0x11, 0x00})
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if !reflect.DeepEqual(test.wantFailed, gotFailed) {
+				t.Errorf("want failed %v got %v", test.wantFailed, gotFailed)
+			} else if !bytes.Equal(test.wantTx, out.Bytes()) {
+				t.Errorf("want tx %x got %x", test.wantTx, out.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+var (
+	// ErrHubResponse is returned when the hub responds to an HTTP request
+	// with something other than 200 OK
+	ErrHubResponse = errors.New("unexpected response from hub")
+
+	// ErrHubBuffer is returned when the hub's buffer status can't be parsed
+	ErrHubBuffer = errors.New("invalid hub buffer status")
+
+	// HTTPHubPort is the default port of the Hub 2 HTTP interface
+	HTTPHubPort = "25105"
+)
+
+// HTTPHubOption customizes an HTTPHub
+type HTTPHubOption func(hub *HTTPHub)
+
+// HubPollInterval sets how often the hub's receive buffer is polled
+func HubPollInterval(interval time.Duration) HTTPHubOption {
+	return func(hub *HTTPHub) {
+		hub.pollInterval = interval
+	}
+}
+
+// HubClient sets the HTTP client used to communicate with the hub
+func HubClient(client *http.Client) HTTPHubOption {
+	return func(hub *HTTPHub) {
+		hub.client = client
+	}
+}
+
+// HTTPHub communicates with the PLM in an Insteon Hub 2 (2245) through the
+// hub's HTTP interface.  Commands are sent to the PLM with GET requests and
+// bytes received from the PLM are read by polling the hub's circular receive
+// buffer.  HTTPHub is a byte stream that can be passed to NewPort
+type HTTPHub struct {
+	url          string
+	username     string
+	password     string
+	client       *http.Client
+	pollInterval time.Duration
+
+	// pending is data that has been read from the hub but not
+	// yet returned by Read
+	pending []byte
+	readPtr int
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// NewHTTPHub returns an HTTPHub for the hub at the given address.  The
+// address may include a scheme and port, http and port 25105 are used
+// if they are not specified. The hub's receive buffer is cleared before
+// NewHTTPHub returns
+func NewHTTPHub(address, username, password string, options ...HTTPHubOption) (*HTTPHub, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), HTTPHubPort)
+	}
+
+	hub := &HTTPHub{
+		url:          strings.TrimRight(u.Scheme+"://"+u.Host+u.Path, "/"),
+		username:     username,
+		password:     password,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		pollInterval: 250 * time.Millisecond,
+		closeCh:      make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(hub)
+	}
+
+	_, err = hub.get("/1?XB=M=1")
+	if err != nil {
+		return nil, err
+	}
+	return hub, nil
+}
+
+func (hub *HTTPHub) get(path string) ([]byte, error) {
+	req, err := http.NewRequest("GET", hub.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(hub.username, hub.password)
+
+	resp, err := hub.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		insteon.Log.Debugf("Hub responded with %s", resp.Status)
+		return nil, ErrHubResponse
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+type buffStatus struct {
+	BS string `xml:"BS"`
+}
+
+// poll reads the hub's receive buffer and returns any bytes that were added
+// since the last poll.  The buffer status is a hex string where the last two
+// characters are the write pointer as an offset into the preceding characters.
+func (hub *HTTPHub) poll() ([]byte, error) {
+	body, err := hub.get("/buffstatus.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	status := buffStatus{}
+	err = xml.Unmarshal(body, &status)
+	if err != nil || len(status.BS) < 4 {
+		insteon.Log.Debugf("Invalid buffer status %q", body)
+		return nil, ErrHubBuffer
+	}
+
+	data := status.BS[:len(status.BS)-2]
+	writePtr, err := strconv.ParseUint(status.BS[len(status.BS)-2:], 16, 8)
+	if err != nil || int(writePtr) > len(data) || writePtr%2 != 0 {
+		insteon.Log.Debugf("Invalid buffer write pointer %q", status.BS)
+		return nil, ErrHubBuffer
+	}
+
+	var str string
+	if int(writePtr) >= hub.readPtr {
+		str = data[hub.readPtr:writePtr]
+	} else {
+		// the write pointer wrapped around the end of the buffer
+		str = data[hub.readPtr:] + data[:writePtr]
+	}
+
+	buf, err := hex.DecodeString(str)
+	if err != nil {
+		insteon.Log.Debugf("Invalid buffer data %q: %v", str, err)
+		return nil, ErrHubBuffer
+	}
+	hub.readPtr = int(writePtr)
+	return buf, nil
+}
+
+// Read returns data received from the PLM. Read blocks until data is
+// available and only returns an error (io.EOF) after the hub has been
+// closed.  Errors polling the hub are logged and polling continues
+func (hub *HTTPHub) Read(p []byte) (n int, err error) {
+	for len(hub.pending) == 0 {
+		select {
+		case <-hub.closeCh:
+			return 0, io.EOF
+		default:
+		}
+
+		hub.pending, err = hub.poll()
+		if err != nil {
+			insteon.Log.Infof("Failed to read hub buffer: %v", err)
+		}
+
+		if len(hub.pending) == 0 {
+			select {
+			case <-time.After(hub.pollInterval):
+			case <-hub.closeCh:
+				return 0, io.EOF
+			}
+		}
+	}
+
+	n = copy(p, hub.pending)
+	hub.pending = hub.pending[n:]
+	return n, nil
+}
+
+// Write sends a complete PLM command to the hub
+func (hub *HTTPHub) Write(p []byte) (int, error) {
+	select {
+	case <-hub.closeCh:
+		return 0, ErrDisconnected
+	default:
+	}
+
+	_, err := hub.get(fmt.Sprintf("/3?%s=I=3", strings.ToUpper(hex.EncodeToString(p))))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close stops polling the hub
+func (hub *HTTPHub) Close() error {
+	hub.closeOnce.Do(func() { close(hub.closeCh) })
+	return nil
+}
@@ -0,0 +1,201 @@
+package plm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// testHTTPHub emulates the Hub 2 HTTP interface. Commands sent to the PLM
+// are recorded and the PLM's responses are written into a circular buffer
+type testHTTPHub struct {
+	sync.Mutex
+	buf      []byte
+	writePtr int
+	commands [][]byte
+	respond  func(cmd []byte) []byte
+}
+
+func newTestHTTPHub(size int) *testHTTPHub {
+	return &testHTTPHub{buf: make([]byte, size)}
+}
+
+func (th *testHTTPHub) write(data []byte) {
+	for _, b := range data {
+		th.buf[th.writePtr/2] = b
+		th.writePtr = (th.writePtr + 2) % (2 * len(th.buf))
+	}
+}
+
+func (th *testHTTPHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if username, password, _ := r.BasicAuth(); username != "user" || password != "pass" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	th.Lock()
+	defer th.Unlock()
+	switch {
+	case r.URL.Path == "/buffstatus.xml":
+		fmt.Fprintf(w, "<response><BS>%s%02X</BS></response>", strings.ToUpper(hex.EncodeToString(th.buf)), th.writePtr)
+	case r.URL.Path == "/1" && r.URL.RawQuery == "XB=M=1":
+		th.buf = make([]byte, len(th.buf))
+		th.writePtr = 0
+	case r.URL.Path == "/3" && strings.HasSuffix(r.URL.RawQuery, "=I=3"):
+		cmd, err := hex.DecodeString(strings.TrimSuffix(r.URL.RawQuery, "=I=3"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		th.commands = append(th.commands, cmd)
+		if th.respond != nil {
+			th.write(th.respond(cmd))
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestHTTPHubPoll(t *testing.T) {
+	tests := []struct {
+		desc     string
+		readPtr  int
+		writePtr int
+		data     []byte
+		want     []byte
+	}{
+		{"empty", 0, 0, nil, []byte{}},
+		{"simple", 0, 0, []byte{0x02, 0x6d, 0x06}, []byte{0x02, 0x6d, 0x06}},
+		{"offset", 4, 4, []byte{0x02, 0x6d, 0x06}, []byte{0x02, 0x6d, 0x06}},
+		{"wraparound", 16, 16, []byte{0x02, 0x60, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x06}, []byte{0x02, 0x60, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x06}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			th := newTestHTTPHub(10)
+			server := httptest.NewServer(th)
+			defer server.Close()
+
+			hub, err := NewHTTPHub(server.URL, "user", "pass")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			hub.readPtr = test.readPtr
+			th.writePtr = test.writePtr
+			th.write(test.data)
+
+			got, err := hub.poll()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !bytes.Equal(test.want, got) {
+				t.Errorf("want %x got %x", test.want, got)
+			}
+
+			if hub.readPtr != th.writePtr {
+				t.Errorf("want read pointer %d got %d", th.writePtr, hub.readPtr)
+			}
+		})
+	}
+}
+
+func TestHTTPHubErrors(t *testing.T) {
+	th := newTestHTTPHub(10)
+	server := httptest.NewServer(th)
+	defer server.Close()
+
+	_, err := NewHTTPHub(server.URL, "user", "wrong")
+	if err != ErrHubResponse {
+		t.Errorf("want error %v got %v", ErrHubResponse, err)
+	}
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<response><BS>0G</BS></response>")
+	}))
+	defer bad.Close()
+
+	hub, err := NewHTTPHub(bad.URL, "user", "pass")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = hub.poll()
+	if err != ErrHubBuffer {
+		t.Errorf("want error %v got %v", ErrHubBuffer, err)
+	}
+}
+
+func TestHTTPHubPLM(t *testing.T) {
+	th := newTestHTTPHub(100)
+	th.respond = func(cmd []byte) []byte {
+		if Command(cmd[1]) == CmdGetInfo {
+			return []byte{0x02, 0x60, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x06}
+		}
+		return append(cmd, 0x06)
+	}
+	server := httptest.NewServer(th)
+	defer server.Close()
+
+	hub, err := NewHTTPHub(server.URL, "user", "pass", HubPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	modem, _ := New(NewPort(hub, time.Second), time.Second)
+	defer modem.Close()
+
+	// send enough commands to wrap around the buffer
+	for i := 0; i < 15; i++ {
+		info, err := modem.Info()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := &Info{Address: insteon.Address{1, 2, 3}, DevCat: insteon.DevCat{4, 5}, Firmware: 6}
+		if *want != *info {
+			t.Fatalf("want %v got %v", want, info)
+		}
+	}
+
+	if err := modem.LEDOn(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	th.Lock()
+	defer th.Unlock()
+	if !bytes.Equal(th.commands[len(th.commands)-1], []byte{0x02, 0x6d}) {
+		t.Errorf("want 02 6d got %x", th.commands[len(th.commands)-1])
+	}
+}
+
+func TestHTTPHubClose(t *testing.T) {
+	th := newTestHTTPHub(10)
+	server := httptest.NewServer(th)
+	defer server.Close()
+
+	hub, _ := NewHTTPHub(server.URL, "user", "pass", HubPollInterval(time.Millisecond))
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(hub).ReadByte()
+		readErr <- err
+	}()
+
+	hub.Close()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Errorf("expected an error after close")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for read to return")
+	}
+}
@@ -21,6 +21,23 @@ import (
 	"github.com/abates/insteon"
 )
 
+// RestoreError is returned when deleting a link from the PLM's All-Link
+// database removed other records that could not be added back
+type RestoreError struct {
+	Cause   error                 // the error that stopped the delete, if any
+	Err     error                 // the first error restoring a record
+	Records []*insteon.LinkRecord // the records that were not restored
+}
+
+// Error describes the original error, if any, and the records that were lost
+func (re *RestoreError) Error() string {
+	str := fmt.Sprintf("failed to restore %d records: %v", len(re.Records), re.Err)
+	if re.Cause != nil {
+		str = fmt.Sprintf("%v (%s)", re.Cause, str)
+	}
+	return str
+}
+
 type recordRequestCommand byte
 
 const (
@@ -68,7 +85,7 @@ func (alr *allLinkReq) MarshalBinary() ([]byte, error) {
 
 func (alr *allLinkReq) UnmarshalBinary(buf []byte) (err error) {
 	if len(buf) < 2 {
-		err = &insteon.BufError{insteon.ErrBufferTooShort, 2, len(buf)}
+		err = &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 2, Got: len(buf)}
 	} else {
 		alr.Mode = linkingMode(buf[0])
 		alr.Group = insteon.Group(buf[1])
@@ -85,6 +102,8 @@ type linkdbPLM interface {
 	Unlock()
 	receive(timeout time.Duration) (*Packet, error)
 	send(packet *Packet) (ack *Packet, err error)
+	Subscribe(ch chan<- Event)
+	Unsubscribe(ch chan<- Event)
 }
 
 type linkdb struct {
@@ -92,6 +111,7 @@ type linkdb struct {
 	links   []*insteon.LinkRecord
 	plm     linkdbPLM
 	timeout time.Duration
+	linkCh  chan Event
 }
 
 func (ldb *linkdb) old() bool {
@@ -125,6 +145,7 @@ func (ldb *linkdb) refresh() error {
 	if err == ErrNak {
 		err = nil
 		ldb.links = links
+		ldb.age = time.Now()
 	}
 	return err
 }
@@ -136,39 +157,218 @@ func (ldb *linkdb) Links() ([]*insteon.LinkRecord, error) {
 	return ldb.links, err
 }
 
-func (ldb *linkdb) WriteLinks(...*insteon.LinkRecord) error {
+// WriteLinks will replace the PLM's entire All-Link database with the
+// given links. The new records are written first, and only then are the
+// existing records that aren't part of the new set deleted. If writing
+// fails part way through, the PLM is left with a mix of old and new records
+// but none of the old records have been lost
+func (ldb *linkdb) WriteLinks(links ...*insteon.LinkRecord) error {
 	ldb.plm.Lock()
 	defer ldb.plm.Unlock()
-	return insteon.ErrNotImplemented
+	err := ldb.refresh()
+	if err != nil {
+		return err
+	}
+
+	// the first existing record matching each new record is overwritten
+	// by modify, every other existing record is deleted
+	existing := ldb.links
+	remove := []*insteon.LinkRecord{}
+	kept := make([]bool, len(links))
+	for _, link := range existing {
+		found := false
+		for i := 0; i < len(links) && !found; i++ {
+			if !kept[i] && links[i].Flags.InUse() && links[i].Equal(link) {
+				kept[i] = true
+				found = true
+			}
+		}
+
+		if !found {
+			remove = append(remove, link)
+		}
+	}
+
+	for i := 0; i < len(links) && err == nil; i++ {
+		if links[i].Flags.InUse() {
+			err = ldb.modify(links[i])
+		}
+	}
+
+	for i := 0; i < len(remove) && err == nil; i++ {
+		err = ldb.delete(remove[i])
+	}
+	ldb.age = time.Time{}
+	return err
 }
 
-func (ldb *linkdb) UpdateLinks(...*insteon.LinkRecord) error {
+// UpdateLinks will add, modify or delete the given links in the PLM's
+// All-Link database. Links that are marked available are deleted, all
+// other links are added or, if a record with the same type, group and
+// address already exists, modified
+func (ldb *linkdb) UpdateLinks(links ...*insteon.LinkRecord) error {
 	ldb.plm.Lock()
 	defer ldb.plm.Unlock()
-	return insteon.ErrNotImplemented
+	err := ldb.refresh()
+	for i := 0; i < len(links) && err == nil; i++ {
+		if links[i].Flags.Available() {
+			err = ldb.delete(links[i])
+		} else {
+			err = ldb.modify(links[i])
+		}
+	}
+	ldb.age = time.Time{}
+	return err
 }
 
-func (ldb *linkdb) EnterLinkingMode(group insteon.Group) error {
-	ldb.plm.Lock()
-	defer ldb.plm.Unlock()
-	lr := &allLinkReq{Mode: linkingMode(0x03), Group: group}
-	payload, _ := lr.MarshalBinary()
-	_, err := ldb.plm.send(&Packet{Command: CmdStartAllLink, Payload: payload})
+func (ldb *linkdb) manage(command recordRequestCommand, link *insteon.LinkRecord) error {
+	request := &manageRecordRequest{command: command, link: link}
+	insteon.Log.Debugf("Managing PLM link record %v", request)
+	payload, err := request.MarshalBinary()
 	if err == nil {
-		// arbitrary wait to allow plm's set-button message to be propogated
-		<-time.After(600 * time.Millisecond)
+		_, err = ldb.plm.send(&Packet{Command: CmdManageAllLinkRecord, Payload: payload})
+	}
+	return err
+}
+
+// modify will update the first record that has the same type, group and
+// address as the given link.  If no matching record exists the PLM adds
+// a new record.  Since the PLM chooses where a new record goes, the cached
+// links are marked old so that the next delete reads them again
+func (ldb *linkdb) modify(link *insteon.LinkRecord) (err error) {
+	if link.Flags.Controller() {
+		err = ldb.manage(LinkCmdModFirstCtrl, link)
+	} else {
+		err = ldb.manage(LinkCmdModFirstResp, link)
+	}
+	ldb.age = time.Time{}
+	return err
+}
+
+// delete will remove the matching record from the PLM's All-Link database.
+// The PLM deletes the first record with the same group and address regardless
+// of whether it is a controller or responder record.  Any records deleted
+// ahead of the target are re-added once the target is removed.  The cached
+// links are kept in step with each deletion so that later deletes in the
+// same batch see the records the PLM actually has.  The other records are
+// restored even when deleting the target fails, and if any of them can't be
+// restored a RestoreError is returned
+func (ldb *linkdb) delete(link *insteon.LinkRecord) error {
+	err := ldb.refresh()
+	restore := []*insteon.LinkRecord{}
+	for i := 0; i < len(ldb.links) && err == nil; {
+		existing := ldb.links[i]
+		if existing.Group != link.Group || existing.Address != link.Address {
+			i++
+			continue
+		}
+
+		err = ldb.manage(LinkCmdDeleteFirst, existing)
+		if err == nil {
+			ldb.links = append(ldb.links[:i:i], ldb.links[i+1:]...)
+			if existing.Equal(link) {
+				break
+			}
+			restore = append(restore, existing)
+		}
+	}
+
+	var restoreErr error
+	var lost []*insteon.LinkRecord
+	for _, existing := range restore {
+		if e := ldb.modify(existing); e != nil {
+			lost = append(lost, existing)
+			if restoreErr == nil {
+				restoreErr = e
+			}
+		}
+	}
+
+	if restoreErr != nil {
+		return &RestoreError{Cause: err, Err: restoreErr, Records: lost}
 	}
 	return err
 }
 
+func (ldb *linkdb) EnterLinkingMode(group insteon.Group) error {
+	ldb.plm.Lock()
+	defer ldb.plm.Unlock()
+	return ldb.startLinking(linkingMode(0x03), group)
+}
+
 func (ldb *linkdb) ExitLinkingMode() error {
+	ldb.plm.Lock()
+	defer ldb.plm.Unlock()
 	_, err := ldb.plm.send(&Packet{Command: CmdCancelAllLink})
+	ldb.stopLinking()
 	return err
 }
 
 func (ldb *linkdb) EnterUnlinkingMode(group insteon.Group) error {
-	lr := &allLinkReq{Mode: linkingMode(0xff), Group: group}
+	ldb.plm.Lock()
+	defer ldb.plm.Unlock()
+	return ldb.startLinking(linkingMode(0xff), group)
+}
+
+// startLinking subscribes to PLM events before entering linking mode so
+// that the All-Link Complete event cannot be missed.  The linking channel
+// and the link database age are only changed with the PLM locked
+func (ldb *linkdb) startLinking(mode linkingMode, group insteon.Group) error {
+	ldb.stopLinking()
+	ldb.linkCh = make(chan Event, 1)
+	ldb.plm.Subscribe(ldb.linkCh)
+
+	lr := &allLinkReq{Mode: mode, Group: group}
 	payload, _ := lr.MarshalBinary()
+	// send returns once the PLM has echoed the command, the All-Link
+	// Complete event confirms the link itself
 	_, err := ldb.plm.send(&Packet{Command: CmdStartAllLink, Payload: payload})
+	if err != nil {
+		ldb.stopLinking()
+	}
 	return err
 }
+
+func (ldb *linkdb) stopLinking() {
+	if ldb.linkCh != nil {
+		ldb.plm.Unsubscribe(ldb.linkCh)
+		ldb.linkCh = nil
+	}
+}
+
+// WaitLinkComplete waits for the PLM to report that a link was created (or
+// deleted) after EnterLinkingMode or EnterUnlinkingMode.  The returned
+// record is the PLM's record of the link. Deleted links are returned with
+// the record marked available
+func (ldb *linkdb) WaitLinkComplete(timeout time.Duration) (*insteon.LinkRecord, error) {
+	// the PLM isn't kept locked while waiting, so that linking mode
+	// can be exited from another goroutine
+	ldb.plm.Lock()
+	linkCh := ldb.linkCh
+	ldb.plm.Unlock()
+	if linkCh == nil {
+		return nil, ErrNotLinking
+	}
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event := <-linkCh:
+			if alc, ok := event.(*AllLinkComplete); ok {
+				ldb.plm.Lock()
+				ldb.age = time.Time{}
+				ldb.plm.Unlock()
+				link := insteon.ResponderLink(alc.Group, alc.Address)
+				if alc.Controller {
+					link = insteon.ControllerLink(alc.Group, alc.Address)
+				} else if alc.Deleted {
+					link.Flags.SetAvailable()
+				}
+				insteon.Log.Debugf("PLM reported link %v", link)
+				return link, nil
+			}
+		case <-deadline:
+			return nil, insteon.ErrReadTimeout
+		}
+	}
+}
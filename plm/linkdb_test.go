@@ -5,6 +5,7 @@ import (
 	"encoding"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -62,15 +63,29 @@ func TestLinkDBOld(t *testing.T) {
 }
 
 type testLinkdbPLM struct {
+	sync.Mutex
+
 	rx    []*Packet
 	rxErr error
 	tx    []*Packet
 	txErr error
 	ack   []*Packet
+
+	subscribers []chan<- Event
+}
+
+func (tlplm *testLinkdbPLM) Subscribe(ch chan<- Event) {
+	tlplm.subscribers = append(tlplm.subscribers, ch)
 }
 
-func (tlplm *testLinkdbPLM) Lock()   {}
-func (tlplm *testLinkdbPLM) Unlock() {}
+func (tlplm *testLinkdbPLM) Unsubscribe(ch chan<- Event) {
+	for i, s := range tlplm.subscribers {
+		if s == ch {
+			tlplm.subscribers = append(tlplm.subscribers[:i], tlplm.subscribers[i+1:]...)
+			break
+		}
+	}
+}
 
 func (tlplm *testLinkdbPLM) receive(timeout time.Duration) (p *Packet, err error) {
 	err = tlplm.rxErr
@@ -198,3 +213,251 @@ func TestLinkdbCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestLinkdbUpdate(t *testing.T) {
+	mrr := func(command recordRequestCommand, link *insteon.LinkRecord) *Packet {
+		packet := &Packet{Command: CmdManageAllLinkRecord}
+		packet.Payload, _ = (&manageRecordRequest{command: command, link: link}).MarshalBinary()
+		return packet
+	}
+
+	available := func(link *insteon.LinkRecord) *insteon.LinkRecord {
+		link.Flags.SetAvailable()
+		return link
+	}
+
+	ctrl1 := insteon.ControllerLink(1, insteon.Address{1, 2, 3})
+	resp1 := insteon.ResponderLink(1, insteon.Address{1, 2, 3})
+	resp2 := insteon.ResponderLink(2, insteon.Address{4, 5, 6})
+
+	// reread is the packets sent when the link database is read
+	// again after a record has been added
+	reread := []*Packet{{Command: CmdGetFirstAllLink}, {Command: CmdGetNextAllLink}, {Command: CmdGetNextAllLink}, {Command: CmdGetNextAllLink}}
+
+	tests := []struct {
+		name     string
+		existing []*insteon.LinkRecord
+		write    bool
+		input    []*insteon.LinkRecord
+		reread   []*insteon.LinkRecord
+		wantTx   []*Packet
+	}{
+		{
+			name:   "Add Controller",
+			input:  []*insteon.LinkRecord{insteon.ControllerLink(1, insteon.Address{1, 2, 3})},
+			wantTx: []*Packet{mrr(LinkCmdModFirstCtrl, ctrl1)},
+		},
+		{
+			name:   "Add Responder",
+			input:  []*insteon.LinkRecord{insteon.ResponderLink(2, insteon.Address{4, 5, 6})},
+			wantTx: []*Packet{mrr(LinkCmdModFirstResp, resp2)},
+		},
+		{
+			name:     "Delete",
+			existing: []*insteon.LinkRecord{ctrl1, resp2},
+			input:    []*insteon.LinkRecord{available(insteon.ResponderLink(2, insteon.Address{4, 5, 6}))},
+			wantTx:   []*Packet{mrr(LinkCmdDeleteFirst, resp2)},
+		},
+		{
+			name:     "Delete Second Match",
+			existing: []*insteon.LinkRecord{ctrl1, resp1},
+			input:    []*insteon.LinkRecord{available(insteon.ResponderLink(1, insteon.Address{1, 2, 3}))},
+			wantTx:   []*Packet{mrr(LinkCmdDeleteFirst, ctrl1), mrr(LinkCmdDeleteFirst, resp1), mrr(LinkCmdModFirstCtrl, ctrl1)},
+		},
+		{
+			name:     "Delete Missing",
+			existing: []*insteon.LinkRecord{ctrl1},
+			input:    []*insteon.LinkRecord{available(insteon.ResponderLink(2, insteon.Address{4, 5, 6}))},
+		},
+		{
+			name:     "Delete Pair",
+			existing: []*insteon.LinkRecord{ctrl1, resp1},
+			input:    []*insteon.LinkRecord{available(insteon.ControllerLink(1, insteon.Address{1, 2, 3})), available(insteon.ResponderLink(1, insteon.Address{1, 2, 3}))},
+			wantTx:   []*Packet{mrr(LinkCmdDeleteFirst, ctrl1), mrr(LinkCmdDeleteFirst, resp1)},
+		},
+		{
+			name:     "Delete Pair Reversed",
+			existing: []*insteon.LinkRecord{ctrl1, resp1},
+			input:    []*insteon.LinkRecord{available(insteon.ResponderLink(1, insteon.Address{1, 2, 3})), available(insteon.ControllerLink(1, insteon.Address{1, 2, 3}))},
+			reread:   []*insteon.LinkRecord{resp2, ctrl1},
+			wantTx:   append([]*Packet{mrr(LinkCmdDeleteFirst, ctrl1), mrr(LinkCmdDeleteFirst, resp1), mrr(LinkCmdModFirstCtrl, ctrl1)}, append(reread[:3:3], mrr(LinkCmdDeleteFirst, ctrl1))...),
+		},
+		{
+			name:     "Write",
+			existing: []*insteon.LinkRecord{ctrl1, resp1},
+			write:    true,
+			input:    []*insteon.LinkRecord{insteon.ResponderLink(2, insteon.Address{4, 5, 6})},
+			reread:   []*insteon.LinkRecord{ctrl1, resp1, resp2},
+			wantTx:   append([]*Packet{mrr(LinkCmdModFirstResp, resp2)}, append(reread[:4:4], mrr(LinkCmdDeleteFirst, ctrl1), mrr(LinkCmdDeleteFirst, resp1))...),
+		},
+		{
+			name:     "Write Keeps Matching",
+			existing: []*insteon.LinkRecord{ctrl1, resp1},
+			write:    true,
+			input:    []*insteon.LinkRecord{insteon.ResponderLink(1, insteon.Address{1, 2, 3}), insteon.ResponderLink(2, insteon.Address{4, 5, 6})},
+			reread:   []*insteon.LinkRecord{ctrl1, resp1, resp2},
+			wantTx:   append([]*Packet{mrr(LinkCmdModFirstResp, resp1), mrr(LinkCmdModFirstResp, resp2)}, append(reread[:4:4], mrr(LinkCmdDeleteFirst, ctrl1))...),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plm := &testLinkdbPLM{}
+			for i, tx := range test.wantTx {
+				ack := &Packet{Command: tx.Command, Ack: 0x06}
+				// reading the link database ends with a NAK
+				if (tx.Command == CmdGetFirstAllLink || tx.Command == CmdGetNextAllLink) && (i+1 == len(test.wantTx) || test.wantTx[i+1].Command != CmdGetNextAllLink) {
+					ack.Ack = 0x15
+				}
+				plm.ack = append(plm.ack, ack)
+			}
+
+			for _, link := range test.reread {
+				packet := &Packet{Command: CmdAllLinkRecordResp}
+				packet.Payload, _ = link.MarshalBinary()
+				plm.rx = append(plm.rx, packet)
+			}
+			ldb := &linkdb{plm: plm, age: time.Now(), timeout: time.Hour, links: test.existing}
+
+			var err error
+			if test.write {
+				err = ldb.WriteLinks(test.input...)
+			} else {
+				err = ldb.UpdateLinks(test.input...)
+			}
+
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			} else if !reflect.DeepEqual(test.wantTx, plm.tx) {
+				t.Errorf("Wanted packets %v got %v", test.wantTx, plm.tx)
+			}
+
+			if !ldb.old() {
+				t.Errorf("Expected database to be marked old after modification")
+			}
+		})
+	}
+}
+
+func TestLinkdbWaitLinkComplete(t *testing.T) {
+	deleted := insteon.ResponderLink(1, insteon.Address{1, 2, 3})
+	deleted.Flags.SetAvailable()
+
+	tests := []struct {
+		name    string
+		events  []Event
+		want    *insteon.LinkRecord
+		wantErr error
+	}{
+		{"Controller", []Event{&AllLinkComplete{Address: insteon.Address{1, 2, 3}, Group: 1, Controller: true}}, insteon.ControllerLink(1, insteon.Address{1, 2, 3}), nil},
+		{"Responder", []Event{&ButtonEvent{}, &AllLinkComplete{Address: insteon.Address{1, 2, 3}, Group: 1}}, insteon.ResponderLink(1, insteon.Address{1, 2, 3}), nil},
+		{"Deleted", []Event{&AllLinkComplete{Address: insteon.Address{1, 2, 3}, Group: 1, Deleted: true}}, deleted, nil},
+		{"Timeout", nil, nil, insteon.ErrReadTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plm := &testLinkdbPLM{ack: []*Packet{{Ack: 0x06}, {Ack: 0x06}}}
+			ldb := &linkdb{plm: plm}
+			ldb.linkCh = make(chan Event, len(test.events))
+			for _, event := range test.events {
+				ldb.linkCh <- event
+			}
+
+			got, err := ldb.WaitLinkComplete(time.Millisecond)
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if !reflect.DeepEqual(test.want, got) {
+				t.Errorf("want link %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLinkdbLinkingSubscription(t *testing.T) {
+	plm := &testLinkdbPLM{ack: []*Packet{{Ack: 0x06}, {Ack: 0x06}}}
+	ldb := &linkdb{plm: plm}
+
+	if _, err := ldb.WaitLinkComplete(time.Millisecond); err != ErrNotLinking {
+		t.Errorf("want error %v got %v", ErrNotLinking, err)
+	}
+
+	ldb.EnterLinkingMode(1)
+	if len(plm.subscribers) != 1 {
+		t.Errorf("want 1 subscriber after entering linking mode got %d", len(plm.subscribers))
+	}
+
+	ldb.ExitLinkingMode()
+	if len(plm.subscribers) != 0 {
+		t.Errorf("want 0 subscribers after exiting linking mode got %d", len(plm.subscribers))
+	}
+}
+
+func TestLinkdbExitWhileWaiting(t *testing.T) {
+	plm := &testLinkdbPLM{ack: []*Packet{{Ack: 0x06}, {Ack: 0x06}}}
+	ldb := &linkdb{plm: plm}
+	if err := ldb.EnterLinkingMode(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ldb.WaitLinkComplete(100 * time.Millisecond)
+		done <- err
+	}()
+
+	if err := ldb.ExitLinkingMode(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// depending on which goroutine gets the lock first, the wait either
+	// finds linking mode already exited or times out
+	if err := <-done; err != ErrNotLinking && err != insteon.ErrReadTimeout {
+		t.Errorf("want error %v or %v got %v", ErrNotLinking, insteon.ErrReadTimeout, err)
+	}
+}
+
+func TestLinkdbDeleteRestore(t *testing.T) {
+	mrr := func(command recordRequestCommand, link *insteon.LinkRecord) *Packet {
+		packet := &Packet{Command: CmdManageAllLinkRecord}
+		packet.Payload, _ = (&manageRecordRequest{command: command, link: link}).MarshalBinary()
+		return packet
+	}
+
+	ctrl1 := insteon.ControllerLink(1, insteon.Address{1, 2, 3})
+	resp1 := insteon.ResponderLink(1, insteon.Address{1, 2, 3})
+	target := insteon.ResponderLink(1, insteon.Address{1, 2, 3})
+	target.Flags.SetAvailable()
+
+	tests := []struct {
+		name    string
+		ack     []byte
+		wantErr error
+	}{
+		{"Restored", []byte{0x06, 0x15, 0x06}, ErrNak},
+		{"Not Restored", []byte{0x06, 0x15, 0x15}, &RestoreError{Cause: ErrNak, Err: ErrNak, Records: []*insteon.LinkRecord{ctrl1}}},
+		{"Deleted Not Restored", []byte{0x06, 0x06, 0x15}, &RestoreError{Err: ErrNak, Records: []*insteon.LinkRecord{ctrl1}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plm := &testLinkdbPLM{}
+			for _, ack := range test.ack {
+				plm.ack = append(plm.ack, &Packet{Command: CmdManageAllLinkRecord, Ack: ack})
+			}
+			ldb := &linkdb{plm: plm, age: time.Now(), timeout: time.Hour, links: []*insteon.LinkRecord{ctrl1, resp1}}
+
+			// the controller record is deleted ahead of the responder and
+			// must be added back even though deleting the responder fails
+			err := ldb.UpdateLinks(target)
+			if !reflect.DeepEqual(test.wantErr, err) {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			}
+
+			wantTx := []*Packet{mrr(LinkCmdDeleteFirst, ctrl1), mrr(LinkCmdDeleteFirst, resp1), mrr(LinkCmdModFirstCtrl, ctrl1)}
+			if !reflect.DeepEqual(wantTx, plm.tx) {
+				t.Errorf("want packets %v got %v", wantTx, plm.tx)
+			}
+		})
+	}
+}
@@ -15,7 +15,10 @@
 package plm
 
 import (
+	"bytes"
 	"fmt"
+
+	"github.com/abates/insteon"
 )
 
 type Packet struct {
@@ -38,6 +41,33 @@ func (p *Packet) NAK() bool {
 	return false
 }
 
+// echoes determines if the packet is the PLM's echo of the transmitted
+// packet. The PLM echoes the command and payload of everything it is sent
+// and appends an ACK or NAK. Responses to queries (such as Get Info) have
+// the requested information following the echoed payload
+func (p *Packet) echoes(tx *Packet) bool {
+	if p.Command != tx.Command {
+		return false
+	}
+
+	payload := p.Payload
+	if p.Command == CmdSendInsteonMsg && len(payload) >= 3 {
+		// skip the padding added for the missing source address
+		payload = payload[3:]
+	}
+
+	return len(tx.Payload) <= len(payload) && bytes.Equal(tx.Payload, payload[:len(tx.Payload)])
+}
+
+// EchoedFlags returns the message flags from the PLM's echo of an Insteon
+// message.  ok is false if the packet is not an echo of an Insteon message
+func (p *Packet) EchoedFlags() (flags insteon.Flags, ok bool) {
+	if p.Command == CmdSendInsteonMsg && len(p.Payload) > 6 {
+		return insteon.Flags(p.Payload[6]), true
+	}
+	return 0, false
+}
+
 func (p *Packet) Format(f fmt.State, c rune) {
 	if c == 'x' || c == 'X' {
 		format := fmt.Sprintf("%%02%c%%s%%02%c", c, c)
@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/abates/insteon"
 )
 
 func TestPacketAckNak(t *testing.T) {
@@ -120,3 +122,51 @@ func TestPacketFormat(t *testing.T) {
 		})
 	}
 }
+
+func TestPacketEchoes(t *testing.T) {
+	msg := []byte{0x01, 0x02, 0x03, 0x0f, 0x11, 0xff}
+	tests := []struct {
+		desc string
+		tx   *Packet
+		rx   *Packet
+		want bool
+	}{
+		{"no payload", &Packet{Command: CmdGetInfo}, &Packet{Command: CmdGetInfo, Payload: []byte{1, 2, 3, 4, 5, 6}, Ack: 0x06}, true},
+		{"different command", &Packet{Command: CmdGetInfo}, &Packet{Command: CmdGetConfig, Ack: 0x06}, false},
+		{"matching payload", &Packet{Command: CmdSetConfig, Payload: []byte{0x40}}, &Packet{Command: CmdSetConfig, Payload: []byte{0x40}, Ack: 0x06}, true},
+		{"different payload", &Packet{Command: CmdSetConfig, Payload: []byte{0x40}}, &Packet{Command: CmdSetConfig, Payload: []byte{0x80}, Ack: 0x06}, false},
+		{"short payload", &Packet{Command: CmdSetConfig, Payload: []byte{0x40}}, &Packet{Command: CmdSetConfig, Ack: 0x06}, false},
+		{"insteon message", &Packet{Command: CmdSendInsteonMsg, Payload: msg}, &Packet{Command: CmdSendInsteonMsg, Payload: append([]byte{0, 0, 0}, msg...), Ack: 0x06}, true},
+		{"other insteon message", &Packet{Command: CmdSendInsteonMsg, Payload: msg}, &Packet{Command: CmdSendInsteonMsg, Payload: []byte{0, 0, 0, 0x04, 0x05, 0x06, 0x0f, 0x11, 0xff}, Ack: 0x06}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := test.rx.echoes(test.tx); got != test.want {
+				t.Errorf("want %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestPacketEchoedFlags(t *testing.T) {
+	tests := []struct {
+		desc      string
+		input     *Packet
+		wantFlags insteon.Flags
+		wantOk    bool
+	}{
+		{"insteon message", &Packet{Command: CmdSendInsteonMsg, Payload: []byte{0, 0, 0, 1, 2, 3, 0x1f, 0x11, 0xff}}, insteon.Flags(0x1f), true},
+		{"short", &Packet{Command: CmdSendInsteonMsg, Payload: []byte{0, 0, 0}}, 0, false},
+		{"other command", &Packet{Command: CmdGetInfo, Payload: []byte{1, 2, 3, 4, 5, 6}}, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			gotFlags, gotOk := test.input.EchoedFlags()
+			if gotOk != test.wantOk || gotFlags != test.wantFlags {
+				t.Errorf("want %v %v got %v %v", test.wantFlags, test.wantOk, gotFlags, gotOk)
+			}
+		})
+	}
+}
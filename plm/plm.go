@@ -20,6 +20,7 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abates/insteon"
@@ -32,6 +33,11 @@ var (
 	ErrAckTimeout         = errors.New("Timeout waiting for Ack from the PLM")
 	ErrRetryCountExceeded = errors.New("Retry count exceeded sending command")
 	ErrNak                = errors.New("PLM responded with a NAK.  Resend command")
+	ErrNotLinking         = errors.New("PLM is not in linking mode")
+
+	// errBusy indicates the PLM NAKed a command because it was busy,
+	// these commands are retried
+	errBusy = errors.New("PLM is busy")
 
 	MaxRetries = 3
 )
@@ -50,11 +56,26 @@ type PLM struct {
 	portMutex  sync.Mutex
 	timeout    time.Duration
 	writeDelay time.Duration
-	nextWrite  time.Time
-	port       *Port
-	demux      insteon.Demux
 
-	plmCh chan *Packet
+	cleanupRetries int
+	maxRetries     int
+	retryBackoff   time.Duration
+	retries        uint64
+	queue          sendQueue
+	port           *Port
+	demux          insteon.Demux
+	cache          insteon.DeviceInfoCache
+
+	plmCh    chan *Packet
+	x10Ch    chan X10Event
+	events   eventBus
+	x10      x10Receiver
+	watchdog watchdog
+
+	// disconnectCh is closed when the connection to the PLM
+	// is lost so that pending requests fail immediately
+	connMu       sync.Mutex
+	disconnectCh chan struct{}
 }
 
 // The Option mechanism is based on the method described at https://dave.cheney.net/2014/10/17/functional-options-for-friendly-apis
@@ -63,11 +84,14 @@ type Option func(p *PLM) error
 // New creates a new PLM instance.
 func New(port *Port, timeout time.Duration, options ...Option) (*PLM, error) {
 	plm := &PLM{
-		timeout:    timeout,
-		writeDelay: 500 * time.Millisecond,
-		port:       port,
-
-		plmCh: make(chan *Packet),
+		timeout:      timeout,
+		port:         port,
+		maxRetries:   MaxRetries,
+		retryBackoff: 100 * time.Millisecond,
+
+		plmCh:        make(chan *Packet, 16),
+		x10Ch:        make(chan X10Event, 16),
+		disconnectCh: make(chan struct{}),
 	}
 	plm.demux = insteon.NewDemux(plm)
 	plm.linkdb.plm = plm
@@ -81,11 +105,54 @@ func New(port *Port, timeout time.Duration, options ...Option) (*PLM, error) {
 		}
 	}
 
+	if notifier, ok := port.out.(stateNotifier); ok {
+		notifier.NotifyState(plm.connStateChanged)
+	}
+
 	go plm.readLoop()
+	if plm.watchdog.interval > 0 {
+		plm.watchdog.stopCh = make(chan struct{})
+		go plm.watch(plm.watchdog.stopCh)
+	}
 	return plm, nil
 }
 
-// WriteDelay can be passed as a parameter to New to change the delay used after writing a command before reading the response.
+// connStateChanged fails any pending requests when the connection
+// to the PLM is lost
+func (plm *PLM) connStateChanged(state ConnState) {
+	if state != Disconnected {
+		return
+	}
+
+	plm.connMu.Lock()
+	defer plm.connMu.Unlock()
+	if plm.disconnectCh != nil {
+		close(plm.disconnectCh)
+		plm.disconnectCh = make(chan struct{})
+	}
+}
+
+func (plm *PLM) disconnected() <-chan struct{} {
+	plm.connMu.Lock()
+	defer plm.connMu.Unlock()
+	return plm.disconnectCh
+}
+
+// RetryPolicy can be passed as a parameter to New to change how commands are resent when the PLM is
+// busy. The command is resent up to retries times, waiting backoff before the first retry and doubling
+// the wait for each retry after that. A command that is still NAKed after the last retry fails with
+// ErrRetryCountExceeded rather than ErrNak
+func RetryPolicy(retries int, backoff time.Duration) Option {
+	return func(p *PLM) error {
+		p.maxRetries = retries
+		p.retryBackoff = backoff
+		return nil
+	}
+}
+
+// WriteDelay can be passed as a parameter to New to change how long an Insteon message is considered in flight to its
+// destination. By default, or when the delay is zero, the time is computed from each message's flags (see
+// insteon.PropagationDelay)
 func WriteDelay(d time.Duration) Option {
 	return func(p *PLM) error {
 		p.writeDelay = d
@@ -93,6 +160,16 @@ func WriteDelay(d time.Duration) Option {
 	}
 }
 
+// DeviceCache can be passed as a parameter to New so that Open takes device
+// information from the cache instead of querying devices every time they
+// are opened (see insteon.OpenCached)
+func DeviceCache(cache insteon.DeviceInfoCache) Option {
+	return func(p *PLM) error {
+		p.cache = cache
+		return nil
+	}
+}
+
 func (plm *PLM) readLoop() {
 	for {
 		buf, err := plm.port.Read()
@@ -110,8 +187,22 @@ func (plm *PLM) readLoop() {
 					} else {
 						insteon.Log.Infof("Failed to unmarshal Insteon Message: %v", err)
 					}
+				} else if packet.Command == CmdX10MsgReceived {
+					plm.dispatchX10(packet)
+				} else if packet.Command == CmdAllLinkComplete || packet.Command == CmdButtonEventReport || packet.Command == CmdUserResetDetected {
+					if event, err := decodeEvent(packet); err == nil {
+						plm.events.publish(event)
+					} else {
+						insteon.Log.Infof("Failed to unmarshal PLM event: %v", err)
+					}
 				} else {
-					plm.plmCh <- packet
+					// never block the read loop, packets that nobody is
+					// waiting for are dropped once the channel is full
+					select {
+					case plm.plmCh <- packet:
+					default:
+						insteon.Log.Infof("No receiver for packet, dropping %v", packet)
+					}
 				}
 			} else {
 				insteon.Log.Infof("Failed to unmarshal packet: %v", err)
@@ -119,6 +210,7 @@ func (plm *PLM) readLoop() {
 		} else {
 			if err != io.EOF {
 				insteon.Log.Infof("Failed to read from PLM port: %v", err)
+				plm.connStateChanged(Disconnected)
 			}
 			break
 		}
@@ -126,23 +218,46 @@ func (plm *PLM) readLoop() {
 }
 
 func (plm *PLM) Send(msg *insteon.Message) error {
+	_, err := plm.SendWithEcho(msg)
+	return err
+}
+
+// SendWithEcho sends the message and returns the PLM's echo of it.  The echo
+// is matched to the message that was sent, so the flags in the echo are the
+// flags that the PLM used to transmit the message
+func (plm *PLM) SendWithEcho(msg *insteon.Message) (echo *Packet, err error) {
 	buf, err := msg.MarshalBinary()
 	if err == nil {
 		// slice off the source address since the PLM doesn't want it
 		buf = buf[3:]
-		_, err = plm.send(&Packet{Command: CmdSendInsteonMsg, Payload: buf})
+		echo, err = plm.send(&Packet{Command: CmdSendInsteonMsg, Payload: buf})
 	}
-	return err
+	return echo, err
 }
 
 // send a packet and wait for the PLM to ack that the packet was
-// sent.  This is a blocking function. Only callers that have acquired
-// the mutex should call this function
+// sent.  This is a blocking function. Packets are queued and sent
+// in priority order (see sendQueue) and resent if the PLM is busy
 func (plm *PLM) send(txPacket *Packet) (ack *Packet, err error) {
-	plm.portMutex.Lock()
-	defer plm.portMutex.Unlock()
+	return retry(plm, txPacket, plm.maxRetries)
+}
+
+// busyNak indicates that the PLM NAKed a command because it was busy
+// rather than rejecting it.  A NAK only carries meaning for the commands
+// that search the All-Link database, where it means there are no more
+// (or no matching) records. Every other NAK means the PLM couldn't
+// process the command right away and the command is retried
+func busyNak(packet *Packet) bool {
+	switch packet.Command {
+	case CmdGetFirstAllLink, CmdGetNextAllLink, CmdGetAllLinkForSender, CmdManageAllLinkRecord:
+		return false
+	}
+	return packet.NAK()
+}
 
-	writeDelay := time.Duration(0)
+// writeDelayFor is how long the packet is considered in flight once the PLM
+// has accepted it
+func (plm *PLM) writeDelayFor(txPacket *Packet) (writeDelay time.Duration) {
 	if txPacket.Command == CmdSendInsteonMsg {
 		writeDelay = plm.writeDelay
 		if writeDelay == 0 {
@@ -151,37 +266,55 @@ func (plm *PLM) send(txPacket *Packet) (ack *Packet, err error) {
 			flags := insteon.Flags(txPacket.Payload[3])
 			writeDelay = insteon.PropagationDelay(flags.TTL(), flags.Extended())
 		}
+	} else if txPacket.Command == CmdSendX10 {
+		writeDelay = X10Delay
 	}
+	return writeDelay
+}
+
+// sendOnce writes the packet a single time and waits for the PLM's ack. The
+// caller must have acquired the send queue
+func (plm *PLM) sendOnce(txPacket *Packet) (ack *Packet, err error) {
+	plm.portMutex.Lock()
+	defer plm.portMutex.Unlock()
 
 	buf, err := txPacket.MarshalBinary()
 	if err == nil {
-		if time.Now().Before(plm.nextWrite) {
-			delay := plm.nextWrite.Sub(time.Now())
-			insteon.Log.Tracef("Delaying write for %s", delay)
-			<-time.After(delay)
+		insteon.Log.Tracef("Sending packet %v", txPacket)
+		disconnected := plm.disconnected()
+		err = plm.port.Write(buf)
+		if err != nil {
+			return nil, err
 		}
 
-		insteon.Log.Tracef("Sending packet %v (write delay %v)", txPacket, writeDelay)
-		plm.port.Write(buf)
-		plm.nextWrite = time.Now().Add(writeDelay)
-
 		// loop until either timeout or the appropriate ack is received
 		timeout := time.Now().Add(plm.timeout)
 		for err == nil {
 			select {
 			case rxPacket := <-plm.plmCh:
-				if txPacket.Command == rxPacket.Command {
+				if rxPacket.echoes(txPacket) {
 					ack = rxPacket
-					if rxPacket.NAK() {
+					if busyNak(rxPacket) {
+						atomic.AddUint64(&plm.port.stats.BusyNaks, 1)
+						err = errBusy
+					} else if rxPacket.NAK() {
 						err = ErrNak
 					}
 					return
+				} else if rxPacket.Command == CmdNak {
+					// the PLM was too busy to accept the command, the
+					// port has already counted the NAK
+					return nil, errBusy
+				} else if rxPacket.Command == txPacket.Command {
+					insteon.Log.Debugf("Discarding stale echo %v", rxPacket)
 				}
+			case <-disconnected:
+				err = ErrDisconnected
 			case <-time.After(plm.timeout):
 				err = ErrAckTimeout
 			}
 
-			if timeout.Before(time.Now()) {
+			if err == nil && timeout.Before(time.Now()) {
 				err = ErrAckTimeout
 			}
 		}
@@ -194,12 +327,61 @@ func (plm *PLM) receive(timeout time.Duration) (pkt *Packet, err error) {
 	defer plm.portMutex.Unlock()
 	select {
 	case pkt = <-plm.plmCh:
+	case <-plm.disconnected():
+		err = ErrDisconnected
 	case <-time.After(timeout):
 		err = ErrReadTimeout
 	}
 	return
 }
 
+func (plm *PLM) dispatchX10(packet *Packet) {
+	msg := &x10Msg{}
+	if err := msg.UnmarshalBinary(packet.Payload); err != nil {
+		insteon.Log.Infof("Failed to unmarshal X10 message: %v", err)
+		return
+	}
+
+	if event, ok := plm.x10.receive(msg); ok {
+		select {
+		case plm.x10Ch <- event:
+		default:
+			insteon.Log.Infof("X10 event buffer full, dropping %v", event)
+		}
+	}
+}
+
+// SendX10 will send an X10 command to the given address. Commands that apply
+// to a single unit are preceded by the unit address, house wide commands
+// (such as X10AllUnitsOff) are only sent with the house code
+func (plm *PLM) SendX10(addr X10Address, cmd X10Command) (err error) {
+	if !cmd.HouseWide() {
+		err = plm.sendX10(newX10Unit(addr))
+	}
+
+	if err == nil {
+		err = plm.sendX10(newX10Cmd(addr.HouseCode, cmd))
+	}
+	return err
+}
+
+func (plm *PLM) sendX10(msg *x10Msg) error {
+	payload, _ := msg.MarshalBinary()
+	_, err := plm.send(&Packet{Command: CmdSendX10, Payload: payload})
+	return err
+}
+
+// ReceiveX10 waits for the next X10 command received by the PLM.  If no
+// command is received before the PLM timeout then ErrReadTimeout is returned
+func (plm *PLM) ReceiveX10() (event X10Event, err error) {
+	select {
+	case event = <-plm.x10Ch:
+	case <-time.After(plm.timeout):
+		err = ErrReadTimeout
+	}
+	return event, err
+}
+
 func (plm *PLM) Connect(addr insteon.Address, options ...insteon.ConnectionOption) (insteon.Connection, error) {
 	return plm.demux.New(addr, options...)
 }
@@ -210,31 +392,57 @@ func (plm *PLM) Open(addr insteon.Address, options ...insteon.ConnectionOption)
 		return nil, err
 	}
 
+	if plm.cache != nil {
+		return insteon.OpenCached(conn, plm.timeout, plm.cache)
+	}
 	return insteon.Open(conn, plm.timeout)
 }
 
-func (plm *PLM) Monitor() (insteon.Connection, error) {
-	return plm.demux.New(insteon.Wildcard)
+// Monitor returns a connection that receives every Insteon message
+// the PLM receives, regardless of the sender
+func (plm *PLM) Monitor(options ...insteon.ConnectionOption) (insteon.Connection, error) {
+	return plm.demux.New(insteon.Wildcard, options...)
 }
 
-// retry will deliver a packet to the Insteon network. If delivery fails (due
-// to a NAK from the PLM) then we will retry and decrement retries. This
-// continues until the packet is sent (as acknowledged by the PLM) or retries
-// reaches zero
+// retry will deliver a packet to the PLM. If delivery fails because the PLM
+// is busy then we will wait, retry and decrement retries. The wait doubles
+// after each attempt. This continues until the packet is sent (as
+// acknowledged by the PLM) or retries reaches zero, in which case
+// ErrRetryCountExceeded is returned.  The packet keeps its place in the
+// send queue while it waits, so no later packet for the same destination
+// is sent ahead of it.  Waiting stops with ErrDisconnected if the connection
+// to the PLM is lost or the PLM is closed
 func retry(plm *PLM, packet *Packet, retries int) (ack *Packet, err error) {
-	for err == ErrNak || retries > 0 {
-		ack, err = plm.send(packet)
-		if err == nil {
+	req := newSendRequest(packet)
+	if !plm.queue.acquire(req, plm.disconnected()) {
+		return nil, ErrDisconnected
+	}
+
+	backoff := plm.retryBackoff
+	for attempt := 1; ; attempt++ {
+		ack, err = plm.sendOnce(packet)
+		if err != errBusy {
+			plm.queue.release(req, plm.writeDelayFor(packet))
+			return ack, err
+		}
+
+		if retries <= 0 {
 			break
 		}
 		retries--
-	}
 
-	if err == ErrNak {
-		insteon.Log.Debugf("Retry count exceeded")
-		err = ErrRetryCountExceeded
+		atomic.AddUint64(&plm.retries, 1)
+		insteon.Log.Debugf("PLM busy sending %v, retrying in %v (attempt %d)", packet, backoff, attempt+1)
+		if !plm.queue.requeue(req, backoff, plm.disconnected()) {
+			return nil, ErrDisconnected
+		}
+		backoff *= 2
 	}
-	return ack, err
+
+	// the PLM never accepted the packet, so it isn't in flight
+	plm.queue.release(req, 0)
+	insteon.Log.Debugf("Retry count exceeded")
+	return ack, ErrRetryCountExceeded
 }
 
 func (plm *PLM) Info() (info *Info, err error) {
@@ -270,14 +478,83 @@ func (plm *PLM) SetConfig(config Config) error {
 	return err
 }
 
-func (plm *PLM) SetDeviceCategory(insteon.Category) error {
-	// TODO
-	return ErrNotImplemented
+// SetDeviceCategory sets the device category and firmware version that the
+// PLM reports to other devices (in its set-button and ID broadcasts)
+func (plm *PLM) SetDeviceCategory(devCat insteon.DevCat, firmware Version) error {
+	_, err := plm.send(&Packet{Command: CmdSetHostCategory, Payload: []byte{devCat[0], devCat[1], byte(firmware)}})
+	return err
 }
 
+// RFSleep puts the PLM's radio to sleep until the next command is
+// sent to the PLM
 func (plm *PLM) RFSleep() error {
-	// TODO
-	return ErrNotImplemented
+	_, err := plm.send(&Packet{Command: CmdRfSleep})
+	return err
+}
+
+// LEDOn turns on the PLM's LED.  The LED stays on until LEDOff is called. Automatic
+// LED control should be disabled (see Config) for the LED to stay on
+func (plm *PLM) LEDOn() error {
+	_, err := plm.send(&Packet{Command: CmdLedOn})
+	return err
+}
+
+// LEDOff turns off the PLM's LED
+func (plm *PLM) LEDOff() error {
+	_, err := plm.send(&Packet{Command: CmdLedOff})
+	return err
+}
+
+// SetAckMessageByte sets the command 2 value the PLM uses when it ACKs
+// direct messages sent to it from other devices.  This is only useful
+// in monitor mode
+func (plm *PLM) SetAckMessageByte(cmd2 byte) error {
+	_, err := plm.send(&Packet{Command: CmdSetAckMsg, Payload: []byte{cmd2}})
+	return err
+}
+
+// SetNakMessageByte sets the command 2 value the PLM uses when it NAKs
+// direct messages sent to it from other devices
+func (plm *PLM) SetNakMessageByte(cmd2 byte) error {
+	_, err := plm.send(&Packet{Command: CmdSetNakMsgByte, Payload: []byte{cmd2}})
+	return err
+}
+
+// SetAckMessageBytes sets the command 1 and command 2 values the PLM uses
+// when it ACKs direct messages sent to it from other devices.  This is only
+// useful in monitor mode
+func (plm *PLM) SetAckMessageBytes(cmd1, cmd2 byte) error {
+	_, err := plm.send(&Packet{Command: CmdSetAckMsgTwoBytes, Payload: []byte{cmd1, cmd2}})
+	return err
+}
+
+// LinkForLastSender returns the All-Link record that the PLM matched
+// to the sender of the most recently received message. ErrNak is returned
+// if the PLM has no matching record
+func (plm *PLM) LinkForLastSender() (link *insteon.LinkRecord, err error) {
+	plm.Lock()
+	defer plm.Unlock()
+	_, err = plm.send(&Packet{Command: CmdGetAllLinkForSender})
+	timeout := time.Now().Add(plm.timeout)
+	for err == nil {
+		var pkt *Packet
+		pkt, err = plm.receive(plm.timeout)
+		if err == nil && pkt.Command == CmdAllLinkRecordResp {
+			link = &insteon.LinkRecord{}
+			err = link.UnmarshalBinary(pkt.Payload)
+			break
+		} else if timeout.Before(time.Now()) {
+			err = ErrReadTimeout
+		}
+	}
+	return link, err
+}
+
+// Stats returns the counters for the connection to the PLM
+func (plm *PLM) Stats() Stats {
+	stats := plm.port.Stats()
+	stats.Retries = atomic.LoadUint64(&plm.retries)
+	return stats
 }
 
 func (plm *PLM) Address() insteon.Address {
@@ -294,5 +571,12 @@ func (plm *PLM) String() string {
 
 func (plm *PLM) Close() {
 	//close(plm.insteonTxCh)
+	if plm.watchdog.stopCh != nil {
+		close(plm.watchdog.stopCh)
+		plm.watchdog.stopCh = nil
+	}
 	plm.port.Close()
+
+	// fail any commands that are waiting to be sent
+	plm.connStateChanged(Disconnected)
 }
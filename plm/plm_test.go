@@ -3,29 +3,238 @@ package plm
 import (
 	"bufio"
 	"bytes"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/abates/insteon"
 )
 
 func TestPlmOption(t *testing.T) {
 	want := 1234 * time.Millisecond
 	buf := bytes.NewBuffer(nil)
-
 	without, err := New(&Port{in: bufio.NewReader(buf), out: buf}, 5*time.Second)
 	if err != nil {
 		t.Errorf("unexpected error from plm.New(): %v", err)
 	}
-	if without.writeDelay == want {
-		t.Errorf("writeDelay is %v, expected anything else", without.writeDelay)
+	// by default the write delay is computed from the message flags
+	msg := &Packet{Command: CmdSendInsteonMsg, Payload: []byte{1, 2, 3, byte(insteon.Flag(insteon.MsgTypeDirect, false, 3, 3)), 0x11, 0xff}}
+	if got, want := without.writeDelayFor(msg), insteon.PropagationDelay(3, false); got != want {
+		t.Errorf("default write delay is %v, want %v", got, want)
+	}
+
+	msg.Payload[3] = byte(insteon.Flag(insteon.MsgTypeDirect, true, 1, 1))
+	if got, want := without.writeDelayFor(msg), insteon.PropagationDelay(1, true); got != want {
+		t.Errorf("default write delay is %v, want %v", got, want)
 	}
 
+	buf = bytes.NewBuffer(nil)
 	with, err := New(&Port{in: bufio.NewReader(buf), out: buf}, 5*time.Second, WriteDelay(want))
 	if err != nil {
 		t.Errorf("unexpected error from plm.New(): %v", err)
 	}
 
-	if with.writeDelay != want {
-		t.Errorf("writeDelay is %v, want %v", without.writeDelay, want)
+	if got := with.writeDelayFor(msg); got != want {
+		t.Errorf("writeDelay is %v, want %v", got, want)
+	}
+
+}
+
+func TestPlmCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		cb      func(*PLM) error
+		ack     byte
+		want    []byte
+		wantErr error
+	}{
+		{"SetDeviceCategory", func(p *PLM) error { return p.SetDeviceCategory(insteon.DevCat{0x03, 0x15}, 0xff) }, 0x06, []byte{0x02, 0x66, 0x03, 0x15, 0xff}, nil},
+		{"RFSleep", func(p *PLM) error { return p.RFSleep() }, 0x06, []byte{0x02, 0x72}, nil},
+		{"LEDOn", func(p *PLM) error { return p.LEDOn() }, 0x06, []byte{0x02, 0x6d}, nil},
+		{"LEDOff", func(p *PLM) error { return p.LEDOff() }, 0x06, []byte{0x02, 0x6e}, nil},
+		{"SetAckMessageByte", func(p *PLM) error { return p.SetAckMessageByte(0x42) }, 0x06, []byte{0x02, 0x68, 0x42}, nil},
+		{"SetNakMessageByte", func(p *PLM) error { return p.SetNakMessageByte(0x42) }, 0x06, []byte{0x02, 0x70, 0x42}, nil},
+		{"SetAckMessageBytes", func(p *PLM) error { return p.SetAckMessageBytes(0x42, 0x43) }, 0x06, []byte{0x02, 0x71, 0x42, 0x43}, nil},
+		{"NAK", func(p *PLM) error { return p.LEDOn() }, 0x15, []byte{0x02, 0x6d}, ErrRetryCountExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+			plm := &PLM{timeout: time.Second, port: &Port{out: out}, plmCh: make(chan *Packet, 1)}
+			plm.plmCh <- &Packet{Command: Command(test.want[1]), Payload: test.want[2:], Ack: test.ack}
+
+			err := test.cb(plm)
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if !bytes.Equal(test.want, out.Bytes()) {
+				t.Errorf("want %x got %x", test.want, out.Bytes())
+			}
+		})
+	}
+}
+
+func TestPlmConfigRoundTrip(t *testing.T) {
+	want := Config(0xb0)
+	want.SetMonitorMode(true)
+
+	out := bytes.NewBuffer(nil)
+	plm := &PLM{timeout: time.Second, port: &Port{out: out}, plmCh: make(chan *Packet, 2)}
+	plm.plmCh <- &Packet{Command: CmdSetConfig, Payload: []byte{0xf0}, Ack: 0x06}
+	plm.plmCh <- &Packet{Command: CmdGetConfig, Payload: []byte{byte(want), 0x00, 0x00}, Ack: 0x06}
+
+	err := plm.SetConfig(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal([]byte{0x02, 0x6b, 0xf0}, out.Bytes()) {
+		t.Errorf("want %x got %x", []byte{0x02, 0x6b, 0xf0}, out.Bytes())
+	}
+
+	got, err := plm.Config()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if want != got {
+		t.Errorf("want config %v got %v", want, got)
+	}
+}
+
+func TestLinkForLastSender(t *testing.T) {
+	link := insteon.ControllerLink(1, insteon.Address{1, 2, 3})
+	payload, _ := link.MarshalBinary()
+
+	tests := []struct {
+		name    string
+		rx      []*Packet
+		want    *insteon.LinkRecord
+		wantErr error
+	}{
+		{"Found", []*Packet{{Command: CmdGetAllLinkForSender, Ack: 0x06}, {Command: CmdAllLinkRecordResp, Payload: payload}}, link, nil},
+		{"Not Found", []*Packet{{Command: CmdGetAllLinkForSender, Ack: 0x15}}, nil, ErrNak},
+		{"Timeout", []*Packet{{Command: CmdGetAllLinkForSender, Ack: 0x06}}, nil, ErrReadTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+			plm := &PLM{timeout: time.Millisecond, port: &Port{out: out}, plmCh: make(chan *Packet, len(test.rx))}
+			for _, pkt := range test.rx {
+				plm.plmCh <- pkt
+			}
+
+			got, err := plm.LinkForLastSender()
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if !reflect.DeepEqual(test.want, got) {
+				t.Errorf("want link %v got %v", test.want, got)
+			}
+
+			if !bytes.Equal([]byte{0x02, 0x6c}, out.Bytes()) {
+				t.Errorf("want %x got %x", []byte{0x02, 0x6c}, out.Bytes())
+			}
+		})
+	}
+}
+
+func TestSendDiscardsStaleEcho(t *testing.T) {
+	msg := &insteon.Message{Dst: insteon.Address{1, 2, 3}, Flags: insteon.StandardDirectMessage, Command: insteon.Command{0x00, 0x11, 0xff}}
+	stale := &Packet{Command: CmdSendInsteonMsg, Payload: []byte{0, 0, 0, 4, 5, 6, 0x0a, 0x13, 0x00}, Ack: 0x06}
+	echo := &Packet{Command: CmdSendInsteonMsg, Payload: []byte{0, 0, 0, 1, 2, 3, 0x0a, 0x11, 0xff}, Ack: 0x06}
+
+	plm := &PLM{timeout: time.Second, writeDelay: time.Millisecond, port: &Port{out: bytes.NewBuffer(nil)}, plmCh: make(chan *Packet, 2)}
+	plm.plmCh <- stale
+	plm.plmCh <- echo
+
+	got, err := plm.SendWithEcho(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if got != echo {
+		t.Errorf("want echo %v got %v", echo, got)
+	}
+
+	if flags, _ := got.EchoedFlags(); flags != insteon.StandardDirectMessage {
+		t.Errorf("want flags %v got %v", insteon.StandardDirectMessage, flags)
 	}
+}
+
+func TestSendRetry(t *testing.T) {
+	busy := &Packet{Command: CmdNak}
+	ledAck := &Packet{Command: CmdLedOn, Ack: 0x06}
+	msg := []byte{1, 2, 3, 0x0f, 0x11, 0xff}
+	msgNak := &Packet{Command: CmdSendInsteonMsg, Payload: append([]byte{0, 0, 0}, msg...), Ack: 0x15}
+	msgAck := &Packet{Command: CmdSendInsteonMsg, Payload: append([]byte{0, 0, 0}, msg...), Ack: 0x06}
+
+	config := []byte{0x40}
+	configNak := &Packet{Command: CmdSetConfig, Payload: config, Ack: 0x15}
+	configAck := &Packet{Command: CmdSetConfig, Payload: config, Ack: 0x06}
 
+	// lone NAKs are counted by the port, so only NAK echoes
+	// are counted as busy NAKs here
+	tests := []struct {
+		desc         string
+		tx           *Packet
+		retries      int
+		rx           []*Packet
+		wantErr      error
+		wantRetries  uint64
+		wantBusyNaks uint64
+	}{
+		{"busy then ack", &Packet{Command: CmdLedOn}, 1, []*Packet{busy, ledAck}, nil, 1, 0},
+		{"busy twice", &Packet{Command: CmdLedOn}, 2, []*Packet{busy, busy, ledAck}, nil, 2, 0},
+		{"retries exceeded", &Packet{Command: CmdLedOn}, 1, []*Packet{busy, busy}, ErrRetryCountExceeded, 1, 0},
+		{"message nak", &Packet{Command: CmdSendInsteonMsg, Payload: msg}, 1, []*Packet{msgNak, msgAck}, nil, 1, 1},
+		{"led nak", &Packet{Command: CmdLedOn}, 1, []*Packet{{Command: CmdLedOn, Ack: 0x15}, ledAck}, nil, 1, 1},
+		{"config nak", &Packet{Command: CmdSetConfig, Payload: config}, 2, []*Packet{configNak, configNak, configAck}, nil, 2, 2},
+		{"config nak exceeded", &Packet{Command: CmdSetConfig, Payload: config}, 1, []*Packet{configNak, configNak}, ErrRetryCountExceeded, 1, 2},
+		{"no more records", &Packet{Command: CmdGetNextAllLink}, 3, []*Packet{{Command: CmdGetNextAllLink, Ack: 0x15}}, ErrNak, 0, 0},
+		{"no matching record", &Packet{Command: CmdManageAllLinkRecord, Payload: config}, 3, []*Packet{{Command: CmdManageAllLinkRecord, Payload: config, Ack: 0x15}}, ErrNak, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+			plm := &PLM{timeout: time.Second, writeDelay: time.Millisecond, port: &Port{out: out}, plmCh: make(chan *Packet, len(test.rx))}
+			RetryPolicy(test.retries, time.Millisecond)(plm)
+			for _, pkt := range test.rx {
+				plm.plmCh <- pkt
+			}
+
+			_, err := plm.send(test.tx)
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			}
+
+			if got := plm.Stats().Retries; got != test.wantRetries {
+				t.Errorf("want %d retries got %d", test.wantRetries, got)
+			}
+
+			if got := plm.Stats().BusyNaks; got != test.wantBusyNaks {
+				t.Errorf("want %d busy NAKs got %d", test.wantBusyNaks, got)
+			}
+
+			buf, _ := test.tx.MarshalBinary()
+			if want := bytes.Repeat(buf, int(test.wantRetries)+1); !bytes.Equal(want, out.Bytes()) {
+				t.Errorf("want %x got %x", want, out.Bytes())
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	plm := &PLM{timeout: time.Second, port: &Port{out: bytes.NewBuffer(nil)}, plmCh: make(chan *Packet, 3)}
+	RetryPolicy(2, 10*time.Millisecond)(plm)
+	plm.plmCh <- &Packet{Command: CmdNak}
+	plm.plmCh <- &Packet{Command: CmdNak}
+	plm.plmCh <- &Packet{Command: CmdLedOn, Ack: 0x06}
+
+	start := time.Now()
+	if err := plm.LEDOn(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// 10ms before the first retry and 20ms before the second
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("want at least 30ms of backoff got %v", elapsed)
+	}
 }
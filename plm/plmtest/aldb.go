@@ -0,0 +1,91 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plmtest
+
+import "github.com/abates/insteon"
+
+// aldb is an All-Link database in the order the records are stored in
+// memory.  Deleted records stay in the database (marked available) until
+// they are overwritten, just like on a real device
+type aldb []*insteon.LinkRecord
+
+func newALDB(links []*insteon.LinkRecord) aldb {
+	db := aldb{}
+	for _, link := range links {
+		db.add(link)
+	}
+	return db
+}
+
+// find returns the index of the in use record matching the type, group
+// and address.  -1 is returned if no record matches
+func (db aldb) find(controller bool, group insteon.Group, address insteon.Address) int {
+	for i, link := range db {
+		if link.Flags.InUse() && link.Flags.Controller() == controller && link.Group == group && link.Address == address {
+			return i
+		}
+	}
+	return -1
+}
+
+// linked determines if the address appears in any in use record
+func (db aldb) linked(address insteon.Address) bool {
+	for _, link := range db {
+		if link.Flags.InUse() && link.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// add updates the matching record, or stores the link in the first
+// available record, or appends the link to the end of the database
+func (db *aldb) add(link *insteon.LinkRecord) {
+	l := *link
+	l.Flags |= 0x02
+	if i := db.find(l.Flags.Controller(), l.Group, l.Address); i >= 0 {
+		(*db)[i] = &l
+		return
+	}
+
+	for i, existing := range *db {
+		if existing.Flags.Available() {
+			(*db)[i] = &l
+			return
+		}
+	}
+	*db = append(*db, &l)
+}
+
+// remove marks the matching record available
+func (db aldb) remove(controller bool, group insteon.Group, address insteon.Address) bool {
+	if i := db.find(controller, group, address); i >= 0 {
+		db[i].Flags.SetAvailable()
+		return true
+	}
+	return false
+}
+
+// inUse returns copies of the records that are in use
+func (db aldb) inUse() []*insteon.LinkRecord {
+	links := []*insteon.LinkRecord{}
+	for _, link := range db {
+		if link.Flags.InUse() {
+			l := *link
+			links = append(links, &l)
+		}
+	}
+	return links
+}
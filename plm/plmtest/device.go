@@ -0,0 +1,591 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plmtest
+
+import (
+	"sync"
+
+	"github.com/abates/insteon"
+)
+
+// NAK reasons (command 2 of a direct NAK) reported by virtual devices
+const (
+	nakIllegalValue   = 0xfb
+	nakIncorrectSum   = 0xfd
+	nakUnknownCommand = 0xfd
+	nakNotLinked      = 0xff
+)
+
+// linkMode is the state of a node's set button
+type linkMode int
+
+const (
+	notLinking linkMode = iota
+	linking
+	linkingResponder
+	unlinking
+)
+
+// DeviceOption customizes a virtual device
+type DeviceOption func(d *Device)
+
+// Firmware sets the firmware version reported in response to an ID request
+func Firmware(version insteon.FirmwareVersion) DeviceOption {
+	return func(d *Device) {
+		d.firmware = version
+	}
+}
+
+// Hops sets the number of hops between the device and the modem
+func Hops(hops int) DeviceOption {
+	return func(d *Device) {
+		d.hops = hops
+	}
+}
+
+// Links pre-populates the device's All-Link database
+func Links(links ...*insteon.LinkRecord) DeviceOption {
+	return func(d *Device) {
+		d.db = newALDB(links)
+	}
+}
+
+// OperatingFlags sets the device's initial operating flags
+func OperatingFlags(flags byte) DeviceOption {
+	return func(d *Device) {
+		d.flags = flags
+	}
+}
+
+// Device is a virtual Insteon device.  Devices answer the common direct
+// commands (engine version, ID request, ping, lighting commands, operating
+// flags, extended get/set and ALDB reads and writes), respond to All-Link
+// group commands for the groups they are linked to and can be linked and
+// unlinked by remote commands or by pressing the set button.  I1 devices
+// reject the commands that require version 2 of the Insteon engine, and
+// I2CS devices NAK commands from senders that are not in their All-Link
+// database and extended commands with a bad checksum
+type Device struct {
+	network  *Network
+	address  insteon.Address
+	version  insteon.EngineVersion
+	devCat   insteon.DevCat
+	firmware insteon.FirmwareVersion
+	hops     int
+	inbox    chan func()
+
+	mu        sync.Mutex
+	db        aldb
+	dbDelta   byte
+	flags     byte
+	level     int
+	ramp      int
+	onLevel   int
+	houseCode int
+	unitCode  int
+	linkMode  linkMode
+	linkGroup insteon.Group
+}
+
+// NewDevice attaches a new virtual device to the network
+func (n *Network) NewDevice(address insteon.Address, version insteon.EngineVersion, devCat insteon.DevCat, options ...DeviceOption) *Device {
+	d := &Device{
+		network:  n,
+		address:  address,
+		version:  version,
+		devCat:   devCat,
+		firmware: 0x41,
+		inbox:    make(chan func(), 64),
+		db:       aldb{},
+		ramp:     0x1c,
+		onLevel:  0xff,
+	}
+
+	for _, option := range options {
+		option(d)
+	}
+
+	n.attach(d)
+	go d.run(n.stopCh)
+	return d
+}
+
+// Address returns the device's Insteon address
+func (d *Device) Address() insteon.Address { return d.address }
+
+func (d *Device) distance() int { return d.hops }
+
+func (d *Device) accepts(msg *insteon.Message) bool {
+	return msg.Broadcast() || msg.Dst == d.address
+}
+
+func (d *Device) deliver(msg *insteon.Message) {
+	select {
+	case d.inbox <- func() { d.process(msg) }:
+	default:
+		insteon.Log.Infof("plmtest device %v is busy, dropping %v", d.address, msg)
+	}
+}
+
+// run processes received messages and button presses in the order
+// they happened
+func (d *Device) run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case fn := <-d.inbox:
+			fn()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Links returns the in use records in the device's All-Link database
+func (d *Device) Links() []*insteon.LinkRecord {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.db.inUse()
+}
+
+// Level returns the device's current on level (0-255)
+func (d *Device) Level() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.level
+}
+
+// Flags returns the device's operating flags
+func (d *Device) Flags() byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flags
+}
+
+// Linking indicates if the device is in linking or unlinking mode
+func (d *Device) Linking() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.linkMode != notLinking
+}
+
+// PressSetButton puts the device into linking mode for the group, exactly
+// as if the set button had been held down, and broadcasts the set-button
+// pressed message.  Button presses are processed after any messages the
+// device has already received
+func (d *Device) PressSetButton(group insteon.Group) {
+	d.inbox <- func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.startLinking(linking, group)
+	}
+}
+
+// SendGroupCommand broadcasts the command to the group and then sends an
+// All-Link cleanup to every responder in the device's All-Link database,
+// just like pressing a button on the device
+func (d *Device) SendGroupCommand(group insteon.Group, cmd insteon.Command) {
+	d.inbox <- func() { d.sendGroupCommand(group, cmd) }
+}
+
+func (d *Device) sendGroupCommand(group insteon.Group, cmd insteon.Command) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.send(&insteon.Message{
+		Dst:     insteon.Address{0x00, 0x00, byte(group)},
+		Flags:   insteon.Flag(insteon.MsgTypeAllLinkBroadcast, false, 3, 3),
+		Command: insteon.Command{0x0c, cmd[1], cmd[2]},
+	})
+
+	for _, link := range d.db {
+		if link.Flags.InUse() && link.Flags.Controller() && link.Group == group {
+			d.send(&insteon.Message{
+				Dst:     link.Address,
+				Flags:   insteon.Flag(insteon.MsgTypeAllLinkCleanup, false, 3, 3),
+				Command: insteon.Command{0x04, cmd[1], byte(group)},
+			})
+		}
+	}
+}
+
+func (d *Device) send(msg *insteon.Message) {
+	d.network.send(d, msg)
+}
+
+func (d *Device) startLinking(mode linkMode, group insteon.Group) {
+	d.linkMode = mode
+	d.linkGroup = group
+	d.send(&insteon.Message{
+		Dst:     insteon.Address{d.devCat[0], d.devCat[1], byte(d.firmware)},
+		Flags:   insteon.Flag(insteon.MsgTypeBroadcast, false, 3, 3),
+		Command: insteon.CmdSetButtonPressedController,
+	})
+}
+
+func (d *Device) reply(msg *insteon.Message, msgType insteon.MessageType, cmd2 byte) {
+	maxTTL := uint8(msg.Flags.MaxTTL())
+	d.send(&insteon.Message{
+		Dst:     msg.Src,
+		Flags:   insteon.Flag(msgType, false, maxTTL, maxTTL),
+		Command: insteon.Command{0x00, msg.Command[1], cmd2},
+	})
+}
+
+func (d *Device) ack(msg *insteon.Message, cmd2 byte) {
+	d.reply(msg, insteon.MsgTypeDirectAck, cmd2)
+}
+
+func (d *Device) nak(msg *insteon.Message, reason byte) {
+	d.reply(msg, insteon.MsgTypeDirectNak, reason)
+}
+
+// sendExtended sends an extended direct message in response to msg
+func (d *Device) sendExtended(msg *insteon.Message, cmd insteon.Command, payload []byte) {
+	maxTTL := uint8(msg.Flags.MaxTTL())
+	d.send(&insteon.Message{
+		Dst:     msg.Src,
+		Flags:   insteon.Flag(insteon.MsgTypeDirect, true, maxTTL, maxTTL),
+		Command: cmd,
+		Payload: payload,
+	})
+}
+
+func (d *Device) process(msg *insteon.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	insteon.Log.Tracef("plmtest device %v RX %v", d.address, msg)
+
+	switch msg.Flags.Type() {
+	case insteon.MsgTypeAllLinkBroadcast:
+		d.groupCommand(msg.Src, insteon.Group(msg.Dst[2]), msg.Command[1])
+	case insteon.MsgTypeBroadcast:
+		d.setButtonPressed(msg)
+	case insteon.MsgTypeAllLinkCleanup:
+		if d.groupCommand(msg.Src, insteon.Group(msg.Command[2]), msg.Command[1]) {
+			d.reply(msg, insteon.MsgTypeAllLinkCleanupAck, msg.Command[2])
+		}
+	case insteon.MsgTypeDirect:
+		d.direct(msg)
+	}
+}
+
+// groupCommand applies a group command if the device is a responder
+// to the controller for the group
+func (d *Device) groupCommand(controller insteon.Address, group insteon.Group, cmd1 byte) bool {
+	i := d.db.find(false, group, controller)
+	if i < 0 {
+		return false
+	}
+
+	switch cmd1 {
+	case 0x11, 0x12:
+		d.level = int(d.db[i].Data[0])
+	case 0x13, 0x14:
+		d.level = 0
+	}
+	return true
+}
+
+// setButtonPressed completes a link when another device's set button is
+// pressed while this device is in linking mode.  The device that entered
+// linking mode first is the controller and tells the other device to add
+// the responder record
+func (d *Device) setButtonPressed(msg *insteon.Message) {
+	if msg.Command[1] != insteon.CmdSetButtonPressedResponder[1] && msg.Command[1] != insteon.CmdSetButtonPressedController[1] {
+		return
+	}
+
+	cmd := insteon.CmdAssignToAllLinkGroup
+	switch d.linkMode {
+	case linking:
+		link := insteon.ControllerLink(d.linkGroup, msg.Src)
+		copy(link.Data[:], msg.Dst[:])
+		d.db.add(link)
+	case unlinking:
+		d.db.remove(true, d.linkGroup, msg.Src)
+		cmd = insteon.CmdDeleteFromAllLinkGroup
+	default:
+		return
+	}
+
+	d.dbDelta++
+	d.send(&insteon.Message{
+		Dst:     msg.Src,
+		Flags:   insteon.Flag(insteon.MsgTypeDirect, false, 3, 3),
+		Command: cmd.SubCommand(int(d.linkGroup)),
+	})
+	d.linkMode = notLinking
+}
+
+// unlinkedCommand determines if an I2CS device will process the command
+// from a sender that is not in its All-Link database
+func unlinkedCommand(msg *insteon.Message) bool {
+	switch msg.Command[1] {
+	case 0x01, 0x02, 0x08, 0x10:
+		return true
+	case 0x09:
+		return msg.Flags.Extended()
+	}
+	return false
+}
+
+func validChecksum(msg *insteon.Message) bool {
+	sum := msg.Command[1] + msg.Command[2]
+	for _, b := range msg.Payload {
+		sum += b
+	}
+	return sum == 0
+}
+
+func (d *Device) direct(msg *insteon.Message) {
+	if d.version == insteon.VerI2Cs {
+		if msg.Flags.Extended() && !validChecksum(msg) {
+			d.nak(msg, nakIncorrectSum)
+			return
+		} else if !d.db.linked(msg.Src) && !unlinkedCommand(msg) {
+			d.nak(msg, nakNotLinked)
+			return
+		}
+	}
+
+	cmd2 := msg.Command[2]
+	switch msg.Command[1] {
+	case 0x01, 0x02:
+		d.linkCommand(msg)
+	case 0x03:
+		d.ack(msg, cmd2)
+		if cmd2 == 0x00 {
+			d.sendExtended(msg, insteon.CmdProductDataResp, []byte{0x00, 0x00, 0x00, 0x00, d.devCat[0], d.devCat[1], 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+		}
+	case 0x08:
+		d.linkMode = notLinking
+		d.ack(msg, cmd2)
+	case 0x09, 0x0a:
+		if d.version == insteon.VerI1 {
+			d.nak(msg, nakUnknownCommand)
+			return
+		}
+		d.ack(msg, cmd2)
+		if msg.Command[1] == 0x09 {
+			d.startLinking(linking, insteon.Group(cmd2))
+		} else {
+			d.startLinking(unlinking, insteon.Group(cmd2))
+		}
+	case 0x0d:
+		d.ack(msg, byte(d.version))
+	case 0x0f:
+		d.ack(msg, cmd2)
+	case 0x10:
+		d.ack(msg, cmd2)
+		d.send(&insteon.Message{
+			Dst:     insteon.Address{d.devCat[0], d.devCat[1], byte(d.firmware)},
+			Flags:   insteon.Flag(insteon.MsgTypeBroadcast, false, 3, 3),
+			Command: insteon.CmdSetButtonPressedResponder,
+		})
+	case 0x11, 0x12, 0x21, 0x27:
+		d.level = int(cmd2)
+		d.ack(msg, cmd2)
+	case 0x13, 0x14:
+		d.level = 0
+		d.ack(msg, cmd2)
+	case 0x15:
+		d.level = min(d.level+32, 255)
+		d.ack(msg, cmd2)
+	case 0x16:
+		d.level = max(d.level-32, 0)
+		d.ack(msg, cmd2)
+	case 0x17, 0x18:
+		d.ack(msg, cmd2)
+	case 0x19:
+		// the status response has the ALDB delta in command 1
+		maxTTL := uint8(msg.Flags.MaxTTL())
+		d.send(&insteon.Message{
+			Dst:     msg.Src,
+			Flags:   insteon.Flag(insteon.MsgTypeDirectAck, false, maxTTL, maxTTL),
+			Command: insteon.Command{0x00, d.dbDelta, byte(d.level)},
+		})
+	case 0x1f:
+		switch cmd2 {
+		case 0x00:
+			d.ack(msg, d.flags)
+		case 0x01:
+			d.ack(msg, d.dbDelta)
+		default:
+			d.ack(msg, 0x00)
+		}
+	case 0x20:
+		// even commands set bit cmd2/2 of the flags and odd
+		// commands clear it
+		if cmd2%2 == 0 {
+			d.flags |= 1 << (cmd2 / 2)
+		} else {
+			d.flags &^= 1 << (cmd2 / 2)
+		}
+		d.ack(msg, cmd2)
+	case 0x2e:
+		if msg.Flags.Extended() {
+			d.extendedGetSet(msg)
+		} else {
+			d.level = int(cmd2>>4) * 0x11
+			d.ack(msg, cmd2)
+		}
+	case 0x34:
+		d.level = int(cmd2>>4) * 0x11
+		d.ack(msg, cmd2)
+	case 0x2f:
+		if msg.Flags.Extended() {
+			d.readWriteALDB(msg)
+		} else {
+			d.level = 0
+			d.ack(msg, cmd2)
+		}
+	case 0x35:
+		d.level = 0
+		d.ack(msg, cmd2)
+	default:
+		d.nak(msg, nakUnknownCommand)
+	}
+}
+
+// linkCommand handles the Assign to All-Link Group and Delete from
+// All-Link Group commands sent by a controller that has completed a link
+func (d *Device) linkCommand(msg *insteon.Message) {
+	group := insteon.Group(msg.Command[2])
+	if d.linkMode == notLinking {
+		d.nak(msg, nakNotLinked)
+		return
+	}
+
+	if msg.Command[1] == insteon.CmdAssignToAllLinkGroup[1] {
+		link := insteon.ResponderLink(group, msg.Src)
+		link.Data = [3]byte{byte(d.onLevel), byte(d.ramp), byte(group)}
+		d.db.add(link)
+	} else {
+		d.db.remove(false, group, msg.Src)
+	}
+	d.dbDelta++
+	d.linkMode = notLinking
+	d.ack(msg, msg.Command[2])
+}
+
+func (d *Device) extendedGetSet(msg *insteon.Message) {
+	switch msg.Payload[1] {
+	case 0x00:
+		d.ack(msg, msg.Command[2])
+		payload := make([]byte, 14)
+		payload[0] = msg.Payload[0]
+		payload[1] = 0x01
+		payload[4] = byte(d.houseCode)
+		payload[5] = byte(d.unitCode)
+		payload[6] = byte(d.ramp)
+		payload[7] = byte(d.onLevel)
+		d.sendExtended(msg, insteon.CmdExtendedGetSet, payload)
+		return
+	case 0x04:
+		d.houseCode = int(msg.Payload[2])
+		d.unitCode = int(msg.Payload[3])
+	case 0x05:
+		d.ramp = int(msg.Payload[2])
+	case 0x06:
+		d.onLevel = int(msg.Payload[2])
+	default:
+		d.nak(msg, nakIllegalValue)
+		return
+	}
+	d.ack(msg, msg.Command[2])
+}
+
+// memAddress is the memory location of the record at index
+func memAddress(index int) insteon.MemAddress {
+	return insteon.BaseLinkDBAddress - insteon.MemAddress(index)*insteon.LinkRecordSize
+}
+
+// readWriteALDB handles the extended Read/Write ALDB command.  Records are
+// read starting at the requested memory address (or the beginning of the
+// database for address 0) and the end of the database is reported with a
+// record that has the last record flag set.  Writing a record with the last
+// record flag set truncates the database at that record
+func (d *Device) readWriteALDB(msg *insteon.Message) {
+	if d.version == insteon.VerI1 {
+		d.nak(msg, nakUnknownCommand)
+		return
+	}
+
+	mem := insteon.MemAddress(msg.Payload[2])<<8 | insteon.MemAddress(msg.Payload[3])
+	index := 0
+	if mem != 0 {
+		if mem > insteon.BaseLinkDBAddress || (insteon.BaseLinkDBAddress-mem)%insteon.LinkRecordSize != 0 {
+			d.nak(msg, nakIllegalValue)
+			return
+		}
+		index = int((insteon.BaseLinkDBAddress - mem) / insteon.LinkRecordSize)
+	}
+
+	if index > len(d.db) {
+		d.nak(msg, nakIllegalValue)
+		return
+	}
+
+	switch msg.Payload[1] {
+	case 0x00:
+		d.ack(msg, msg.Command[2])
+		count := int(msg.Payload[4])
+		for i := index; count == 0 || i < index+count; i++ {
+			link := &insteon.LinkRecord{}
+			if i < len(d.db) {
+				link = d.db[i]
+			}
+
+			record, _ := link.MarshalBinary()
+			payload := make([]byte, 14)
+			payload[1] = 0x01
+			payload[2] = byte(memAddress(i) >> 8)
+			payload[3] = byte(memAddress(i))
+			copy(payload[5:], record)
+			d.sendExtended(msg, insteon.CmdReadWriteALDB, payload)
+
+			if i >= len(d.db) {
+				break
+			}
+		}
+	case 0x02:
+		link := &insteon.LinkRecord{}
+		link.UnmarshalBinary(msg.Payload[5:13])
+		if link.Flags.LastRecord() {
+			d.db = d.db[0:index]
+		} else if index == len(d.db) {
+			d.db = append(d.db, link)
+		} else {
+			d.db[index] = link
+		}
+		d.dbDelta++
+		d.ack(msg, msg.Command[2])
+	default:
+		d.nak(msg, nakIllegalValue)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
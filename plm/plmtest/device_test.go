@@ -0,0 +1,273 @@
+package plmtest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/util"
+)
+
+var deviceAddress = insteon.Address{0x04, 0x05, 0x06}
+
+func TestDeviceOpen(t *testing.T) {
+	tests := []struct {
+		desc    string
+		version insteon.EngineVersion
+		devCat  insteon.DevCat
+		links   []*insteon.LinkRecord
+		want    string
+		wantErr error
+	}{
+		{"I1 Device", insteon.VerI1, insteon.DevCat{0x07, 0x00}, nil, "I1 Device (04.05.06)", nil},
+		{"I2 Device", insteon.VerI2, insteon.DevCat{0x07, 0x00}, nil, "I2 Device (04.05.06)", nil},
+		{"I2 Dimmer", insteon.VerI2, insteon.DevCat{0x01, 0x20}, nil, "Dimmer (04.05.06)", nil},
+		{"I2CS Switch", insteon.VerI2Cs, insteon.DevCat{0x02, 0x2a}, []*insteon.LinkRecord{insteon.ResponderLink(1, modemAddress)}, "Switch (04.05.06)", nil},
+		{"I2CS Not Linked", insteon.VerI2Cs, insteon.DevCat{0x02, 0x2a}, nil, "I2CS Device (04.05.06)", insteon.ErrNotLinked},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			network, _, p := newTestPLM(t)
+			defer network.Close()
+			defer p.Close()
+			network.NewDevice(deviceAddress, test.version, test.devCat, Links(test.links...))
+
+			device, err := p.Open(deviceAddress)
+			if err != test.wantErr {
+				t.Fatalf("want error %v got %v", test.wantErr, err)
+			}
+
+			if got := fmt.Sprintf("%v", device); got != test.want {
+				t.Errorf("want %q got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestDeviceDimmer(t *testing.T) {
+	network, _, p := newTestPLM(t)
+	defer network.Close()
+	defer p.Close()
+	sim := network.NewDevice(deviceAddress, insteon.VerI2, insteon.DevCat{0x01, 0x20})
+
+	device, err := p.Open(deviceAddress)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dimmer, ok := device.(insteon.Dimmer)
+	if !ok {
+		t.Fatalf("want insteon.Dimmer got %T", device)
+	}
+
+	if err := dimmer.OnLevel(0x80); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sim.Level() != 0x80 {
+		t.Errorf("want level 0x80 got %#x", sim.Level())
+	}
+
+	if level, err := dimmer.Status(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if level != 0x80 {
+		t.Errorf("want status 0x80 got %#x", level)
+	}
+
+	if err := dimmer.SetDefaultRamp(0x1f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if config, err := dimmer.DimmerConfig(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if config.Ramp != 0x1f || config.OnLevel != 0xff {
+		t.Errorf("want ramp 0x1f and on level 0xff got %+v", config)
+	}
+
+	if err := dimmer.SetProgramLock(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if flags, err := dimmer.OperatingFlags(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !flags.ProgramLock() {
+		t.Errorf("want program lock got flags %v", flags)
+	}
+
+	if err := dimmer.Off(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sim.Level() != 0 {
+		t.Errorf("want level 0 got %#x", sim.Level())
+	}
+}
+
+func TestDeviceLinkDB(t *testing.T) {
+	links := []*insteon.LinkRecord{
+		insteon.ResponderLink(1, modemAddress),
+		insteon.ControllerLink(1, modemAddress),
+	}
+
+	tests := []struct {
+		desc    string
+		version insteon.EngineVersion
+		wantErr error
+	}{
+		{"I1", insteon.VerI1, insteon.ErrNotImplemented},
+		{"I2", insteon.VerI2, nil},
+		{"I2CS", insteon.VerI2Cs, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			network, _, p := newTestPLM(t)
+			defer network.Close()
+			defer p.Close()
+			sim := network.NewDevice(deviceAddress, test.version, insteon.DevCat{0x07, 0x00}, Links(links...))
+
+			device, err := p.Open(deviceAddress)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			linkable, ok := device.(insteon.Linkable)
+			if !ok {
+				if test.wantErr != insteon.ErrNotImplemented {
+					t.Errorf("want insteon.Linkable got %T", device)
+				}
+				return
+			}
+
+			got, err := linkable.Links()
+			if err != test.wantErr {
+				t.Fatalf("want error %v got %v", test.wantErr, err)
+			} else if !reflect.DeepEqual(links, got) {
+				t.Errorf("want links %v got %v", links, got)
+			}
+
+			add := insteon.ResponderLink(2, insteon.Address{7, 8, 9})
+			if err := linkable.UpdateLinks(add); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := append(links, add)
+			if got := sim.Links(); !reflect.DeepEqual(want, got) {
+				t.Errorf("want links %v got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestDeviceLink(t *testing.T) {
+	tests := []struct {
+		desc    string
+		version insteon.EngineVersion
+	}{
+		{"I2", insteon.VerI2},
+		{"I2CS", insteon.VerI2Cs},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			network, modem, p := newTestPLM(t)
+			defer network.Close()
+			defer p.Close()
+			sim := network.NewDevice(deviceAddress, test.version, insteon.DevCat{0x02, 0x2a}, Firmware(0x45))
+
+			device, err := p.Open(deviceAddress)
+			if err != nil && err != insteon.ErrNotLinked {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			link, err := util.ForceLink(1, p, device.(insteon.Linkable))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := insteon.ControllerLink(1, deviceAddress)
+			want.Data = [3]byte{0x02, 0x2a, 0x45}
+			if got := modem.Links(); !reflect.DeepEqual([]*insteon.LinkRecord{want}, got) {
+				t.Errorf("want modem links %v got %v", want, got)
+			}
+
+			if !link.Equal(want) {
+				t.Errorf("want reported link %v got %v", want, link)
+			}
+
+			if got := sim.Links(); len(got) != 1 || !got[0].Equal(insteon.ResponderLink(1, modemAddress)) {
+				t.Errorf("want device link %v got %v", insteon.ResponderLink(1, modemAddress), got)
+			}
+
+			// once linked, an I2CS device accepts commands from the modem
+			failed, err := p.SendGroupCommand(1, insteon.CmdLightOn)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if len(failed) > 0 {
+				t.Errorf("want no failed responders got %v", failed)
+			}
+
+			if sim.Level() != 0xff {
+				t.Errorf("want level 0xff got %#x", sim.Level())
+			}
+
+			if err := util.Unlink(1, p, device.(insteon.Linkable)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// unlinking is complete when the device leaves linking mode
+			deadline := time.Now().Add(time.Second)
+			for sim.Linking() && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+
+			if got := modem.Links(); len(got) != 0 {
+				t.Errorf("want no modem links got %v", got)
+			}
+
+			if got := sim.Links(); len(got) != 0 {
+				t.Errorf("want no device links got %v", got)
+			}
+		})
+	}
+}
+
+func TestDeviceSetButton(t *testing.T) {
+	network := NewNetwork()
+	defer network.Close()
+
+	controller := network.NewDevice(insteon.Address{1, 1, 1}, insteon.VerI2, insteon.DevCat{0x02, 0x2a})
+	responder := network.NewDevice(insteon.Address{2, 2, 2}, insteon.VerI2, insteon.DevCat{0x01, 0x20})
+
+	controller.PressSetButton(3)
+	deadline := time.Now().Add(time.Second)
+	for !controller.Linking() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	responder.PressSetButton(3)
+
+	for len(responder.Links()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := controller.Links(); len(got) != 1 || !got[0].Equal(insteon.ControllerLink(3, responder.Address())) {
+		t.Errorf("want controller link got %v", got)
+	}
+
+	if got := responder.Links(); len(got) != 1 || !got[0].Equal(insteon.ResponderLink(3, controller.Address())) {
+		t.Errorf("want responder link got %v", got)
+	}
+
+	controller.SendGroupCommand(3, insteon.CmdLightOn)
+	deadline = time.Now().Add(time.Second)
+	for responder.Level() != 0xff && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if responder.Level() != 0xff {
+		t.Errorf("want level 0xff got %#x", responder.Level())
+	}
+}
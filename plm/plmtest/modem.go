@@ -0,0 +1,568 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plmtest
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+const (
+	ack = 0x06
+	nak = 0x15
+)
+
+// txLens is the payload length of each command the host can send to the
+// modem. Send INSTEON Msg is followed by 14 more bytes when the message
+// is extended
+var txLens = map[plm.Command]int{
+	plm.CmdGetInfo:             0,
+	plm.CmdSendAllLink:         3,
+	plm.CmdSendInsteonMsg:      6,
+	plm.CmdSendX10:             2,
+	plm.CmdStartAllLink:        2,
+	plm.CmdCancelAllLink:       0,
+	plm.CmdSetHostCategory:     3,
+	plm.CmdReset:               0,
+	plm.CmdSetAckMsg:           1,
+	plm.CmdGetFirstAllLink:     0,
+	plm.CmdGetNextAllLink:      0,
+	plm.CmdSetConfig:           1,
+	plm.CmdGetAllLinkForSender: 0,
+	plm.CmdLedOn:               0,
+	plm.CmdLedOff:              0,
+	plm.CmdManageAllLinkRecord: 9,
+	plm.CmdSetNakMsgByte:       1,
+	plm.CmdSetAckMsgTwoBytes:   2,
+	plm.CmdRfSleep:             0,
+	plm.CmdGetConfig:           0,
+}
+
+// ModemOption customizes a Modem
+type ModemOption func(m *Modem)
+
+// ModemCategory sets the device category and firmware version reported
+// by Get Info
+func ModemCategory(devCat insteon.DevCat, firmware plm.Version) ModemOption {
+	return func(m *Modem) {
+		m.devCat = devCat
+		m.firmware = firmware
+	}
+}
+
+// ModemLinks pre-populates the modem's All-Link database
+func ModemLinks(links ...*insteon.LinkRecord) ModemOption {
+	return func(m *Modem) {
+		m.db = newALDB(links)
+	}
+}
+
+// CleanupTimeout sets how long the modem waits for each responder to
+// acknowledge the All-Link cleanup that follows a group command
+func CleanupTimeout(timeout time.Duration) ModemOption {
+	return func(m *Modem) {
+		m.cleanupTimeout = timeout
+	}
+}
+
+// Modem emulates an Insteon PowerLinc Modem.  The host side of the modem
+// is a byte stream (Modem is an io.ReadWriteCloser) that speaks the same
+// serial protocol as a real PLM, so it can be passed to plm.NewPort.  The
+// modem keeps an All-Link database, supports linking and unlinking and sends
+// the Insteon messages it is given on to the network
+type Modem struct {
+	network        *Network
+	address        insteon.Address
+	devCat         insteon.DevCat
+	firmware       plm.Version
+	cleanupTimeout time.Duration
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	rx     []byte
+	tx     []byte
+	closed bool
+
+	db         aldb
+	cursor     int
+	config     plm.Config
+	linkMode   linkMode
+	linkGroup  insteon.Group
+	lastSender insteon.Address
+
+	// seen is the device category and firmware of every device that
+	// has been heard broadcasting
+	seen map[insteon.Address][3]byte
+
+	// internal is command 1 of the messages that the modem sent on its
+	// own. The acknowledgements are consumed rather than reported
+	internal map[insteon.Address]byte
+
+	// cleanup is the responder currently being sent an All-Link cleanup
+	cleanup    insteon.Address
+	cleanupAck chan struct{}
+}
+
+// NewModem attaches a new modem to the network
+func (n *Network) NewModem(address insteon.Address, options ...ModemOption) *Modem {
+	m := &Modem{
+		network:        n,
+		address:        address,
+		devCat:         insteon.DevCat{0x03, 0x15},
+		firmware:       0x9b,
+		cleanupTimeout: 50 * time.Millisecond,
+		db:             aldb{},
+		seen:           make(map[insteon.Address][3]byte),
+		internal:       make(map[insteon.Address]byte),
+	}
+	m.cond = sync.NewCond(&m.mu)
+
+	for _, option := range options {
+		option(m)
+	}
+
+	n.attach(m)
+	return m
+}
+
+// Address returns the modem's Insteon address
+func (m *Modem) Address() insteon.Address { return m.address }
+
+func (m *Modem) distance() int { return 0 }
+
+func (m *Modem) accepts(msg *insteon.Message) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return msg.Broadcast() || msg.Dst == m.address || m.config.MonitorMode()
+}
+
+// Links returns the in use records in the modem's All-Link database
+func (m *Modem) Links() []*insteon.LinkRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.db.inUse()
+}
+
+// Config returns the modem's configuration
+func (m *Modem) Config() plm.Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config
+}
+
+// UserReset simulates a factory reset with the set button.  The All-Link
+// database and configuration are erased and the host is notified
+func (m *Modem) UserReset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.db = aldb{}
+	m.config = 0
+	m.linkMode = notLinking
+	m.write(0x02, byte(plm.CmdUserResetDetected))
+}
+
+// Read returns bytes that the modem has sent to the host. Read blocks until
+// data is available and returns io.EOF once the modem is closed
+func (m *Modem) Read(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.tx) == 0 && !m.closed {
+		m.cond.Wait()
+	}
+
+	if len(m.tx) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, m.tx)
+	m.tx = m.tx[n:]
+	return n, nil
+}
+
+// Write sends bytes from the host to the modem.  Complete commands are
+// executed immediately, partial commands are kept until the rest arrives
+func (m *Modem) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	m.rx = append(m.rx, p...)
+	for len(m.rx) > 0 {
+		if m.rx[0] != 0x02 {
+			m.rx = m.rx[1:]
+			continue
+		}
+
+		if len(m.rx) < 2 {
+			break
+		}
+
+		cmd := plm.Command(m.rx[1])
+		length, found := txLens[cmd]
+		if !found {
+			// the modem NAKs anything it doesn't understand
+			insteon.Log.Debugf("plmtest modem received unknown command %02x", byte(cmd))
+			m.rx = m.rx[2:]
+			m.write(nak)
+			continue
+		}
+
+		if cmd == plm.CmdSendInsteonMsg && len(m.rx) > 5 && insteon.Flags(m.rx[5]).Extended() {
+			length += 14
+		}
+
+		if len(m.rx) < length+2 {
+			break
+		}
+
+		payload := make([]byte, length)
+		copy(payload, m.rx[2:])
+		m.rx = m.rx[length+2:]
+		m.command(cmd, payload)
+	}
+	return len(p), nil
+}
+
+// Close closes the host side of the modem
+func (m *Modem) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.cond.Broadcast()
+	return nil
+}
+
+// write queues bytes to be read by the host
+func (m *Modem) write(buf ...byte) {
+	if m.closed {
+		return
+	}
+	m.tx = append(m.tx, buf...)
+	m.cond.Broadcast()
+}
+
+// echo responds to a command by repeating it along with the ACK or NAK
+func (m *Modem) echo(cmd plm.Command, payload []byte, ackNak byte) {
+	m.write(append(append([]byte{0x02, byte(cmd)}, payload...), ackNak)...)
+}
+
+func (m *Modem) command(cmd plm.Command, payload []byte) {
+	insteon.Log.Tracef("plmtest modem RX %v % x", cmd, payload)
+	switch cmd {
+	case plm.CmdGetInfo:
+		m.echo(cmd, []byte{m.address[0], m.address[1], m.address[2], m.devCat[0], m.devCat[1], byte(m.firmware)}, ack)
+	case plm.CmdGetConfig:
+		m.echo(cmd, []byte{byte(m.config), 0x00, 0x00}, ack)
+	case plm.CmdSetConfig:
+		m.config = plm.Config(payload[0])
+		m.echo(cmd, payload, ack)
+	case plm.CmdSetHostCategory:
+		m.devCat = insteon.DevCat{payload[0], payload[1]}
+		m.firmware = plm.Version(payload[2])
+		m.echo(cmd, payload, ack)
+	case plm.CmdReset:
+		m.db = aldb{}
+		m.config = 0
+		m.echo(cmd, payload, ack)
+	case plm.CmdGetFirstAllLink:
+		m.cursor = 0
+		m.nextRecord(cmd, func(*insteon.LinkRecord) bool { return true })
+	case plm.CmdGetNextAllLink:
+		m.nextRecord(cmd, func(*insteon.LinkRecord) bool { return true })
+	case plm.CmdGetAllLinkForSender:
+		m.cursor = 0
+		m.nextRecord(cmd, func(link *insteon.LinkRecord) bool { return link.Address == m.lastSender })
+	case plm.CmdManageAllLinkRecord:
+		m.manageRecord(payload)
+	case plm.CmdStartAllLink:
+		switch payload[0] {
+		case 0x00:
+			m.startLinking(linkingResponder, insteon.Group(payload[1]))
+		case 0xff:
+			m.startLinking(unlinking, insteon.Group(payload[1]))
+		default:
+			m.startLinking(linking, insteon.Group(payload[1]))
+		}
+		m.echo(cmd, payload, ack)
+	case plm.CmdCancelAllLink:
+		m.linkMode = notLinking
+		m.echo(cmd, payload, ack)
+	case plm.CmdSendAllLink:
+		m.echo(cmd, payload, ack)
+		go m.sendGroupCommand(insteon.Group(payload[0]), payload[1], payload[2])
+	case plm.CmdSendInsteonMsg:
+		m.echo(cmd, payload, ack)
+		msg := &insteon.Message{}
+		err := msg.UnmarshalBinary(append(m.address[:], payload...))
+		if err == nil {
+			m.network.send(m, msg)
+		}
+	default:
+		m.echo(cmd, payload, ack)
+	}
+}
+
+// nextRecord reports the next in use record that matches, starting
+// at the cursor.  The command is NAKed when there are no more records
+func (m *Modem) nextRecord(cmd plm.Command, match func(*insteon.LinkRecord) bool) {
+	for ; m.cursor < len(m.db); m.cursor++ {
+		link := m.db[m.cursor]
+		if link.Flags.InUse() && match(link) {
+			m.cursor++
+			m.echo(cmd, nil, ack)
+			record, _ := link.MarshalBinary()
+			m.write(append([]byte{0x02, byte(plm.CmdAllLinkRecordResp)}, record...)...)
+			return
+		}
+	}
+	m.echo(cmd, nil, nak)
+}
+
+// manageRecord handles Manage All-Link Record. Records are matched by
+// group and address, and for the modify or add commands by record type
+func (m *Modem) manageRecord(payload []byte) {
+	link := &insteon.LinkRecord{}
+	link.UnmarshalBinary(payload[1:])
+
+	first := -1
+	for i, existing := range m.db {
+		if existing.Flags.InUse() && existing.Group == link.Group && existing.Address == link.Address {
+			first = i
+			break
+		}
+	}
+
+	result := byte(ack)
+	switch payload[0] {
+	case 0x00, 0x01:
+		if first < 0 {
+			result = nak
+		}
+	case 0x20:
+		if first < 0 {
+			result = nak
+		} else {
+			*m.db[first] = *link
+		}
+	case 0x40:
+		link.Flags = insteon.UnavailableController | link.Flags&0x3f
+		m.db.add(link)
+	case 0x41:
+		link.Flags = insteon.UnavailableResponder | link.Flags&0x3f
+		m.db.add(link)
+	case 0x80:
+		if first < 0 {
+			result = nak
+		} else {
+			m.db[first].Flags.SetAvailable()
+		}
+	default:
+		result = nak
+	}
+	m.echo(plm.CmdManageAllLinkRecord, payload, result)
+}
+
+func (m *Modem) startLinking(mode linkMode, group insteon.Group) {
+	m.linkMode = mode
+	m.linkGroup = group
+	m.network.send(m, &insteon.Message{
+		Dst:     insteon.Address{m.devCat[0], m.devCat[1], byte(m.firmware)},
+		Flags:   insteon.Flag(insteon.MsgTypeBroadcast, false, 3, 3),
+		Command: insteon.CmdSetButtonPressedController,
+	})
+}
+
+// linkComplete reports a new or deleted link to the host
+func (m *Modem) linkComplete(code byte, group insteon.Group, address insteon.Address) {
+	info := m.seen[address]
+	m.write(0x02, byte(plm.CmdAllLinkComplete), code, byte(group), address[0], address[1], address[2], info[0], info[1], info[2])
+	m.linkMode = notLinking
+}
+
+// sendInternal sends a message for the modem's own purposes, the
+// response is not reported to the host
+func (m *Modem) sendInternal(msg *insteon.Message) {
+	m.internal[msg.Dst] = msg.Command[1]
+	m.network.send(m, msg)
+}
+
+// sendGroupCommand broadcasts the group command and then sends a cleanup
+// to each responder in the group.  Responders that don't acknowledge the
+// cleanup are reported as failures
+func (m *Modem) sendGroupCommand(group insteon.Group, cmd1, cmd2 byte) {
+	m.network.send(m, &insteon.Message{
+		Dst:     insteon.Address{0x00, 0x00, byte(group)},
+		Flags:   insteon.Flag(insteon.MsgTypeAllLinkBroadcast, false, 3, 3),
+		Command: insteon.Command{0x0c, cmd1, cmd2},
+	})
+
+	m.mu.Lock()
+	responders := []insteon.Address{}
+	for _, link := range m.db {
+		if link.Flags.InUse() && link.Flags.Controller() && link.Group == group {
+			responders = append(responders, link.Address)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, responder := range responders {
+		acked := make(chan struct{}, 1)
+		m.mu.Lock()
+		m.cleanup = responder
+		m.cleanupAck = acked
+		m.mu.Unlock()
+
+		m.network.send(m, &insteon.Message{
+			Dst:     responder,
+			Flags:   insteon.Flag(insteon.MsgTypeAllLinkCleanup, false, 3, 3),
+			Command: insteon.Command{0x04, cmd1, byte(group)},
+		})
+
+		select {
+		case <-acked:
+		case <-time.After(m.cleanupTimeout):
+			m.mu.Lock()
+			m.write(0x02, byte(plm.CmdAllLinkCleanupFailure), 0x01, byte(group), responder[0], responder[1], responder[2])
+			m.mu.Unlock()
+		}
+	}
+
+	m.mu.Lock()
+	m.cleanupAck = nil
+	m.write(0x02, byte(plm.CmdAllLinkCleanupStatus), ack)
+	m.mu.Unlock()
+}
+
+func (m *Modem) deliver(msg *insteon.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+
+	if m.consume(msg) {
+		return
+	}
+
+	if msg.Dst == m.address || msg.Flags.Type() == insteon.MsgTypeAllLinkBroadcast {
+		m.lastSender = msg.Src
+	}
+
+	buf, _ := msg.MarshalBinary()
+	cmd := plm.CmdStdMsgReceived
+	if msg.Flags.Extended() {
+		cmd = plm.CmdExtMsgReceived
+	}
+	m.write(append([]byte{0x02, byte(cmd)}, buf...)...)
+}
+
+// consume handles the messages that are part of the modem's own linking
+// and cleanup exchanges.  consume returns true for messages that a real
+// modem would not report to the host
+func (m *Modem) consume(msg *insteon.Message) bool {
+	switch msg.Flags.Type() {
+	case insteon.MsgTypeBroadcast:
+		if msg.Command[1] == insteon.CmdSetButtonPressedResponder[1] || msg.Command[1] == insteon.CmdSetButtonPressedController[1] {
+			m.seen[msg.Src] = [3]byte{msg.Dst[0], msg.Dst[1], msg.Dst[2]}
+			m.setButtonPressed(msg)
+		}
+	case insteon.MsgTypeDirect:
+		if msg.Dst != m.address {
+			break
+		}
+
+		if (msg.Command[1] == insteon.CmdAssignToAllLinkGroup[1] || msg.Command[1] == insteon.CmdDeleteFromAllLinkGroup[1]) && m.linkMode != notLinking {
+			m.linkCommand(msg)
+			return true
+		}
+	case insteon.MsgTypeAllLinkCleanup:
+		if msg.Dst == m.address && m.db.find(false, insteon.Group(msg.Command[2]), msg.Src) >= 0 {
+			m.network.send(m, &insteon.Message{
+				Dst:     msg.Src,
+				Flags:   insteon.Flag(insteon.MsgTypeAllLinkCleanupAck, false, 3, 3),
+				Command: insteon.Command{0x00, msg.Command[1], msg.Command[2]},
+			})
+		}
+	case insteon.MsgTypeAllLinkCleanupAck, insteon.MsgTypeAllLinkCleanupNak:
+		if m.cleanupAck != nil && msg.Src == m.cleanup {
+			if msg.Flags.Type() == insteon.MsgTypeAllLinkCleanupAck {
+				m.cleanupAck <- struct{}{}
+			}
+			m.cleanupAck = nil
+			return true
+		}
+	case insteon.MsgTypeDirectAck, insteon.MsgTypeDirectNak:
+		if cmd1, found := m.internal[msg.Src]; found && cmd1 == msg.Command[1] {
+			delete(m.internal, msg.Src)
+			return true
+		}
+	}
+	return false
+}
+
+// setButtonPressed completes a link when a device's set button is pressed
+// while the modem is the controller in linking mode
+func (m *Modem) setButtonPressed(msg *insteon.Message) {
+	switch m.linkMode {
+	case linking:
+		link := insteon.ControllerLink(m.linkGroup, msg.Src)
+		copy(link.Data[:], msg.Dst[:])
+		m.db.add(link)
+		m.sendInternal(&insteon.Message{
+			Dst:     msg.Src,
+			Flags:   insteon.Flag(insteon.MsgTypeDirect, false, 3, 3),
+			Command: insteon.CmdAssignToAllLinkGroup.SubCommand(int(m.linkGroup)),
+		})
+		m.linkComplete(0x01, m.linkGroup, msg.Src)
+	case unlinking:
+		m.db.remove(true, m.linkGroup, msg.Src)
+		m.sendInternal(&insteon.Message{
+			Dst:     msg.Src,
+			Flags:   insteon.Flag(insteon.MsgTypeDirect, false, 3, 3),
+			Command: insteon.CmdDeleteFromAllLinkGroup.SubCommand(int(m.linkGroup)),
+		})
+		m.linkComplete(0xff, m.linkGroup, msg.Src)
+	}
+}
+
+// linkCommand adds or removes the responder record when a controller
+// that was linked with the modem sends Assign to (or Delete from) All-Link
+// Group
+func (m *Modem) linkCommand(msg *insteon.Message) {
+	group := insteon.Group(msg.Command[2])
+	code := byte(0x00)
+	if msg.Command[1] == insteon.CmdAssignToAllLinkGroup[1] {
+		link := insteon.ResponderLink(group, msg.Src)
+		info := m.seen[msg.Src]
+		copy(link.Data[:], info[:])
+		m.db.add(link)
+	} else {
+		m.db.remove(false, group, msg.Src)
+		code = 0xff
+	}
+
+	maxTTL := uint8(msg.Flags.MaxTTL())
+	m.network.send(m, &insteon.Message{
+		Dst:     msg.Src,
+		Flags:   insteon.Flag(insteon.MsgTypeDirectAck, false, maxTTL, maxTTL),
+		Command: insteon.Command{0x00, msg.Command[1], msg.Command[2]},
+	})
+	m.linkComplete(code, group, msg.Src)
+}
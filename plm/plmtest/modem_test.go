@@ -0,0 +1,174 @@
+package plmtest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+var modemAddress = insteon.Address{0x01, 0x02, 0x03}
+
+func newTestPLM(t *testing.T, options ...NetworkOption) (*Network, *Modem, *plm.PLM) {
+	t.Helper()
+	network := NewNetwork(options...)
+	modem := network.NewModem(modemAddress)
+	p, err := plm.New(plm.NewPort(modem, time.Second), time.Second, plm.WriteDelay(0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return network, modem, p
+}
+
+func readModem(t *testing.T, modem *Modem, n int) []byte {
+	t.Helper()
+	buf := make([]byte, 0, n)
+	for len(buf) < n {
+		b := make([]byte, n-len(buf))
+		i, err := modem.Read(b)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		buf = append(buf, b[:i]...)
+	}
+	return buf
+}
+
+func TestModemWrite(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input [][]byte
+		want  []byte
+	}{
+		{"Get Info", [][]byte{{0x02, 0x60}}, []byte{0x02, 0x60, 0x01, 0x02, 0x03, 0x03, 0x15, 0x9b, 0x06}},
+		{"Split Command", [][]byte{{0x02}, {0x60}}, []byte{0x02, 0x60, 0x01, 0x02, 0x03, 0x03, 0x15, 0x9b, 0x06}},
+		{"Leading Garbage", [][]byte{{0xaa, 0x02, 0x6d}}, []byte{0x02, 0x6d, 0x06}},
+		{"Unknown Command", [][]byte{{0x02, 0x42}}, []byte{0x15}},
+		{"Empty Database", [][]byte{{0x02, 0x69}}, []byte{0x02, 0x69, 0x15}},
+		{"Get Config", [][]byte{{0x02, 0x6b, 0x40}, {0x02, 0x73}}, []byte{0x02, 0x6b, 0x40, 0x06, 0x02, 0x73, 0x40, 0x00, 0x00, 0x06}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			network := NewNetwork()
+			defer network.Close()
+			modem := network.NewModem(modemAddress)
+			for _, input := range test.input {
+				if _, err := modem.Write(input); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if got := readModem(t, modem, len(test.want)); !bytes.Equal(test.want, got) {
+				t.Errorf("want % x got % x", test.want, got)
+			}
+		})
+	}
+}
+
+func TestModemClose(t *testing.T) {
+	network := NewNetwork()
+	defer network.Close()
+	modem := network.NewModem(modemAddress)
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := modem.Read(make([]byte, 1))
+		readErr <- err
+	}()
+
+	modem.Close()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Errorf("want error got nil")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for read to return")
+	}
+
+	if _, err := modem.Write([]byte{0x02, 0x60}); err == nil {
+		t.Errorf("want error got nil")
+	}
+}
+
+func TestModemPLM(t *testing.T) {
+	network, modem, p := newTestPLM(t)
+	defer network.Close()
+	defer p.Close()
+
+	info, err := p.Info()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Address != modemAddress {
+		t.Errorf("want address %v got %v", modemAddress, info.Address)
+	}
+
+	config := plm.Config(0)
+	config.SetMonitorMode(true)
+	if err := p.SetConfig(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, err := p.Config(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if got != config {
+		t.Errorf("want config %v got %v", config, got)
+	}
+
+	links := []*insteon.LinkRecord{
+		insteon.ControllerLink(1, insteon.Address{4, 5, 6}),
+		insteon.ResponderLink(1, insteon.Address{4, 5, 6}),
+		insteon.ControllerLink(2, insteon.Address{7, 8, 9}),
+	}
+
+	if err := p.WriteLinks(links...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := modem.Links(); !reflect.DeepEqual(links, got) {
+		t.Errorf("want links %v got %v", links, got)
+	}
+
+	if got, err := p.Links(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(links, got) {
+		t.Errorf("want links %v got %v", links, got)
+	}
+
+	// deleting the controller record must leave the responder record
+	// for the same group and address in place
+	remove := *links[0]
+	remove.Flags.SetAvailable()
+	if err := p.UpdateLinks(&remove); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := modem.Links(); !reflect.DeepEqual(links[1:], got) {
+		t.Errorf("want links %v got %v", links[1:], got)
+	}
+}
+
+func TestModemUserReset(t *testing.T) {
+	network, modem, p := newTestPLM(t)
+	defer network.Close()
+	defer p.Close()
+
+	events := make(chan plm.Event, 1)
+	p.Subscribe(events)
+	modem.UserReset()
+
+	select {
+	case event := <-events:
+		if _, ok := event.(*plm.UserReset); !ok {
+			t.Errorf("want *plm.UserReset got %T", event)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for user reset")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plmtest provides an in-memory Insteon network for testing code
+// that uses the plm package without any hardware.  A Modem emulates a PLM
+// at the serial byte level and can be passed directly to plm.NewPort.
+// Virtual I1, I2 and I2CS devices attached to the same Network answer the
+// Insteon commands sent through the modem, keep real All-Link databases
+// and link with the modem (or each other) the same way physical devices do.
+//
+//	network := plmtest.NewNetwork()
+//	defer network.Close()
+//
+//	modem := network.NewModem(insteon.Address{0x01, 0x02, 0x03})
+//	network.NewDevice(insteon.Address{0x04, 0x05, 0x06}, insteon.VerI2, insteon.DevCat{0x01, 0x20})
+//
+//	p, _ := plm.New(plm.NewPort(modem, time.Second), time.Second, plm.WriteDelay(0))
+//	device, _ := p.Open(insteon.Address{0x04, 0x05, 0x06})
+package plmtest
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/abates/insteon"
+)
+
+// node is anything attached to the network that can receive messages
+type node interface {
+	Address() insteon.Address
+
+	// distance is the number of hops needed for a message to get
+	// from the node to the modem
+	distance() int
+
+	// accepts determines if the node listens to the message
+	accepts(msg *insteon.Message) bool
+
+	// deliver hands the message to the node. deliver must not block
+	deliver(msg *insteon.Message)
+}
+
+// NetworkOption customizes a Network
+type NetworkOption func(n *Network)
+
+// MessageLoss sets the probability (0 to 1) that any single transmission
+// is lost. Losses are independent, so a command can be delivered while its
+// acknowledgement is lost
+func MessageLoss(probability float64) NetworkOption {
+	return func(n *Network) {
+		n.loss = probability
+	}
+}
+
+// Seed sets the seed of the random source that decides which messages
+// are lost, making lossy tests repeatable
+func Seed(seed int64) NetworkOption {
+	return func(n *Network) {
+		n.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// Network connects a modem and any number of virtual devices. The network
+// is modeled as a star with the modem at the center: each node is some
+// number of hops away from the modem, and a message between two nodes needs
+// the sum of their hops.  Messages that don't have enough hops left are never
+// delivered, messages that are delivered have their hops left decremented
+type Network struct {
+	mu     sync.Mutex
+	nodes  []node
+	loss   float64
+	rand   *rand.Rand
+	closed bool
+	stopCh chan struct{}
+}
+
+// NewNetwork returns an empty network
+func NewNetwork(options ...NetworkOption) *Network {
+	n := &Network{
+		rand:   rand.New(rand.NewSource(1)),
+		stopCh: make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(n)
+	}
+	return n
+}
+
+// SetMessageLoss changes the probability (0 to 1) that a transmission is lost
+func (n *Network) SetMessageLoss(probability float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.loss = probability
+}
+
+func (n *Network) attach(nd node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes = append(n.nodes, nd)
+}
+
+// lost decides if a single transmission is lost
+func (n *Network) lost() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.loss > 0 && n.rand.Float64() < n.loss
+}
+
+// send transmits the message from src to every node that accepts it
+func (n *Network) send(src node, msg *insteon.Message) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	nodes := make([]node, len(n.nodes))
+	copy(nodes, n.nodes)
+	n.mu.Unlock()
+
+	msg.Src = src.Address()
+	insteon.Log.Tracef("plmtest TX %v", msg)
+	for _, dst := range nodes {
+		if dst == src || !dst.accepts(msg) {
+			continue
+		}
+
+		hops := src.distance() + dst.distance()
+		if msg.Flags.TTL() < hops {
+			insteon.Log.Tracef("plmtest %v is %d hops from %v, message has %d hops left", dst.Address(), hops, src.Address(), msg.Flags.TTL())
+			continue
+		}
+
+		if n.lost() {
+			insteon.Log.Tracef("plmtest lost message to %v", dst.Address())
+			continue
+		}
+
+		rx := &insteon.Message{
+			Src:     msg.Src,
+			Dst:     msg.Dst,
+			Flags:   insteon.Flag(msg.Flags.Type(), msg.Flags.Extended(), uint8(msg.Flags.TTL()-hops), uint8(msg.Flags.MaxTTL())),
+			Command: msg.Command,
+		}
+
+		if msg.Flags.Extended() {
+			rx.Payload = make([]byte, 14)
+			copy(rx.Payload, msg.Payload)
+		}
+		dst.deliver(rx)
+	}
+}
+
+// Close stops all of the virtual devices and closes the modems
+func (n *Network) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.stopCh)
+	nodes := n.nodes
+	n.mu.Unlock()
+
+	for _, nd := range nodes {
+		if modem, ok := nd.(*Modem); ok {
+			modem.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package plmtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+func TestNetworkHops(t *testing.T) {
+	tests := []struct {
+		desc    string
+		hops    int
+		ttl     uint8
+		wantErr error
+	}{
+		{"Near", 0, 0, nil},
+		{"Reachable", 2, 2, nil},
+		{"Out of Range", 2, 1, insteon.ErrReadTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			network, _, p := newTestPLM(t)
+			defer network.Close()
+			defer p.Close()
+			network.NewDevice(deviceAddress, insteon.VerI2, insteon.DevCat{0x07, 0x00}, Hops(test.hops))
+
+			_, err := p.Open(deviceAddress, insteon.ConnectionTTL(test.ttl), insteon.ConnectionTimeout(100*time.Millisecond))
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNetworkDeliveredFlags(t *testing.T) {
+	network := NewNetwork()
+	defer network.Close()
+	modem := network.NewModem(modemAddress)
+	network.NewDevice(deviceAddress, insteon.VerI2, insteon.DevCat{0x07, 0x00}, Hops(1))
+
+	// ping the device with 3 hops, the ack loses one hop on the way back
+	modem.Write([]byte{0x02, 0x62, 0x04, 0x05, 0x06, 0x0f, 0x0f, 0x00})
+	want := []byte{0x02, 0x62, 0x04, 0x05, 0x06, 0x0f, 0x0f, 0x00, 0x06}
+	if got := readModem(t, modem, len(want)); !reflect.DeepEqual(want, got) {
+		t.Errorf("want % x got % x", want, got)
+	}
+
+	want = []byte{0x02, 0x50, 0x04, 0x05, 0x06, 0x01, 0x02, 0x03, 0x2b, 0x0f, 0x00}
+	if got := readModem(t, modem, len(want)); !reflect.DeepEqual(want, got) {
+		t.Errorf("want % x got % x", want, got)
+	}
+}
+
+func TestNetworkMessageLoss(t *testing.T) {
+	network, _, p := newTestPLM(t, MessageLoss(1))
+	defer network.Close()
+	defer p.Close()
+	link := insteon.ResponderLink(1, modemAddress)
+	link.Data = [3]byte{0xff, 0x1c, 0x01}
+	sim := network.NewDevice(deviceAddress, insteon.VerI2, insteon.DevCat{0x01, 0x20}, Links(link))
+	if err := p.UpdateLinks(insteon.ControllerLink(1, deviceAddress)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := p.Open(deviceAddress, insteon.ConnectionTimeout(100*time.Millisecond))
+	if err != insteon.ErrReadTimeout {
+		t.Errorf("want error %v got %v", insteon.ErrReadTimeout, err)
+	}
+
+	failed, err := p.SendGroupCommand(1, insteon.CmdLightOn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual([]insteon.Address{deviceAddress}, failed) {
+		t.Errorf("want failed responders %v got %v", []insteon.Address{deviceAddress}, failed)
+	}
+
+	network.SetMessageLoss(0)
+	failed, err = p.SendGroupCommand(1, insteon.CmdLightOn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(failed) > 0 {
+		t.Errorf("want no failed responders got %v", failed)
+	}
+
+	if sim.Level() != 0xff {
+		t.Errorf("want level 0xff got %#x", sim.Level())
+	}
+}
@@ -16,16 +16,56 @@ package plm
 
 import (
 	"bufio"
+	"errors"
 	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abates/insteon"
 )
 
+var (
+	errFrameTimeout = errors.New("timeout reading frame")
+	errFrameInvalid = errors.New("invalid frame")
+)
+
+// Stats are counters that describe the quality of the connection
+// to the PLM
+type Stats struct {
+	// BytesDiscarded is the number of received bytes that were not
+	// part of a valid frame
+	BytesDiscarded uint64
+
+	// FramesResynced is the number of times a partial or inconsistent
+	// frame was dropped and the port resynchronized to the next start byte
+	FramesResynced uint64
+
+	// BusyNaks is the number of times the PLM responded with a NAK
+	// indicating it was too busy to accept a command, either as a lone
+	// NAK byte or as the NAK echo of a command that is retried
+	BusyNaks uint64
+
+	// Retries is the number of times a command was resent
+	// because the PLM was busy
+	Retries uint64
+}
+
+type rxChunk struct {
+	buf []byte
+	err error
+}
+
 type Port struct {
 	in      *bufio.Reader
 	out     io.Writer
 	timeout time.Duration
+
+	startOnce sync.Once
+	rxCh      chan rxChunk
+	pending   []byte
+	rxErr     error
+	stats     Stats
 }
 
 func NewPort(readWriter io.ReadWriter, timeout time.Duration) *Port {
@@ -37,55 +77,190 @@ func NewPort(readWriter io.ReadWriter, timeout time.Duration) *Port {
 	return port
 }
 
-func (port *Port) Write(buf []byte) {
+func (port *Port) Write(buf []byte) error {
 	insteon.Log.Tracef("TX %s", hexDump("%02x", buf, " "))
 	_, err := port.out.Write(buf)
 	if err != nil {
 		insteon.Log.Infof("Failed to write: %v", err)
 	}
+	return err
 }
 
-func (port *Port) Read() (buf []byte, err error) {
+// Stats returns a snapshot of the port's counters
+func (port *Port) Stats() Stats {
+	return Stats{
+		BytesDiscarded: atomic.LoadUint64(&port.stats.BytesDiscarded),
+		FramesResynced: atomic.LoadUint64(&port.stats.FramesResynced),
+		BusyNaks:       atomic.LoadUint64(&port.stats.BusyNaks),
+	}
+}
+
+// pump copies everything from the underlying reader to the rx channel so
+// that reads can be abandoned when a frame is not completed in time
+func (port *Port) pump() {
+	for {
+		buf := make([]byte, 64)
+		n, err := port.in.Read(buf)
+		if n > 0 {
+			port.rxCh <- rxChunk{buf: buf[:n]}
+		}
+
+		if err != nil {
+			port.rxCh <- rxChunk{err: err}
+			return
+		}
+	}
+}
+
+// readByte returns the next received byte.  If deadline is not zero, and
+// it passes before a byte is received, then errFrameTimeout is returned
+func (port *Port) readByte(deadline time.Time) (byte, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() && len(port.pending) == 0 {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(port.pending) == 0 {
+		if port.rxErr != nil {
+			return 0, port.rxErr
+		}
+
+		select {
+		case chunk := <-port.rxCh:
+			port.pending = chunk.buf
+			port.rxErr = chunk.err
+		case <-timeout:
+			return 0, errFrameTimeout
+		}
+	}
+
+	b := port.pending[0]
+	port.pending = port.pending[1:]
+	return b, nil
+}
+
+// unread puts bytes back in front of the pending data so they
+// are scanned again
+func (port *Port) unread(buf []byte) {
+	port.pending = append(append([]byte{}, buf...), port.pending...)
+}
+
+func (port *Port) readN(buf []byte, n int, deadline time.Time) ([]byte, error) {
+	for i := 0; i < n; i++ {
+		b, err := port.readByte(deadline)
+		if err != nil {
+			return buf, err
+		}
+		buf = append(buf, b)
+	}
+	return buf, nil
+}
+
+// validFrame checks the parts of a frame that can be checked for
+// consistency with the frame length
+func validFrame(buf []byte) bool {
+	cmd := Command(buf[1])
+	switch {
+	case cmd == CmdStdMsgReceived:
+		return !insteon.Flags(buf[8]).Extended()
+	case cmd == CmdExtMsgReceived:
+		return insteon.Flags(buf[8]).Extended()
+	case cmd >= 0x60:
+		ack := buf[len(buf)-1]
+		return ack == 0x06 || ack == 0x15
+	}
+	return true
+}
+
+// resync drops the start byte of a frame that could not be read and
+// rescans the remainder of the frame for the next start byte
+func (port *Port) resync(buf []byte) {
+	insteon.Log.Debugf("Resynchronizing after bad frame %s", hexDump("%02x", buf, " "))
+	atomic.AddUint64(&port.stats.FramesResynced, 1)
+	atomic.AddUint64(&port.stats.BytesDiscarded, 1)
+	port.unread(buf[1:])
+}
+
+func (port *Port) readFrame() (buf []byte, err error) {
 	// synchronize
-	for err == nil {
+	for {
 		var b byte
-		b, err = port.in.ReadByte()
+		b, err = port.readByte(time.Time{})
 		if err != nil {
+			return nil, err
+		}
+
+		if b == 0x02 {
 			break
 		}
 
-		// first byte of PLM packets is always 0x02
-		if b != 0x02 {
-			insteon.Log.Tracef("Expected Start of Text (0x02) got 0x%02x", b)
-			continue
-		} else {
-			b, err = port.in.ReadByte()
-			if packetLen, found := commandLens[Command(b)]; found {
-				buf = make([]byte, packetLen+2)
-				buf[0] = 0x02
-				buf[1] = b
-				insteon.Log.Tracef("Attempting to read %d more bytes", packetLen)
-				_, err = io.ReadAtLeast(port.in, buf[2:], packetLen)
-				insteon.Log.Tracef("Completed read (err %v): %s", err, hexDump("%02x", buf, " "))
-				break
-			} else {
-				err = port.in.UnreadByte()
-			}
+		// a NAK outside of a frame means the PLM was too busy
+		// to accept the last command
+		if b == byte(CmdNak) {
+			atomic.AddUint64(&port.stats.BusyNaks, 1)
+			return []byte{0x02, byte(CmdNak)}, nil
 		}
+
+		// first byte of PLM packets is always 0x02
+		insteon.Log.Tracef("Expected Start of Text (0x02) got 0x%02x", b)
+		atomic.AddUint64(&port.stats.BytesDiscarded, 1)
 	}
 
+	deadline := time.Time{}
+	if port.timeout > 0 {
+		deadline = time.Now().Add(port.timeout)
+	}
+
+	buf = []byte{0x02}
+	buf, err = port.readN(buf, 1, deadline)
 	if err == nil {
-		// read some more if it's an extended message
-		if buf[1] == 0x62 && insteon.Flags(buf[5]).Extended() {
-			buf = append(buf, make([]byte, 14)...)
-			_, err = io.ReadAtLeast(port.in, buf[9:], 14)
+		packetLen, found := commandLens[Command(buf[1])]
+		if !found || Command(buf[1]) == CmdNak {
+			err = errFrameInvalid
+		} else {
+			insteon.Log.Tracef("Attempting to read %d more bytes", packetLen)
+			buf, err = port.readN(buf, packetLen, deadline)
+			insteon.Log.Tracef("Completed read (err %v): %s", err, hexDump("%02x", buf, " "))
 		}
+	}
+
+	// read some more if it's an extended message
+	if err == nil && buf[1] == byte(CmdSendInsteonMsg) && insteon.Flags(buf[5]).Extended() {
+		buf, err = port.readN(buf, 14, deadline)
+	}
+
+	if err == nil && !validFrame(buf) {
+		err = errFrameInvalid
+	}
+
+	if err == errFrameTimeout || err == errFrameInvalid {
+		port.resync(buf)
+	}
+	return buf, err
+}
+
+// Read returns the next complete frame received from the PLM. Bytes that
+// are not part of a frame are discarded.  Once a frame has started, it must
+// be completed within the port's timeout, otherwise it is dropped and the port
+// resynchronizes to the next start byte
+func (port *Port) Read() (buf []byte, err error) {
+	port.startOnce.Do(func() {
+		port.rxCh = make(chan rxChunk, 16)
+		go port.pump()
+	})
 
-		if err == nil {
-			insteon.Log.Tracef("RX %s", hexDump("%02x", buf, " "))
+	for {
+		buf, err = port.readFrame()
+		if err != errFrameTimeout && err != errFrameInvalid {
+			break
 		}
 	}
 
+	if err == nil {
+		insteon.Log.Tracef("RX %s", hexDump("%02x", buf, " "))
+	}
 	return buf, err
 }
 
@@ -0,0 +1,79 @@
+package plm
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPortRead(t *testing.T) {
+	tests := []struct {
+		desc      string
+		input     []byte
+		want      [][]byte
+		wantStats Stats
+	}{
+		{"frame", []byte{0x02, 0x6d, 0x06}, [][]byte{{0x02, 0x6d, 0x06}}, Stats{}},
+		{"noise", []byte{0x00, 0xff, 0x02, 0x6d, 0x06}, [][]byte{{0x02, 0x6d, 0x06}}, Stats{BytesDiscarded: 2}},
+		{"busy nak", []byte{0x15, 0x02, 0x6d, 0x06}, [][]byte{{0x02, 0x15}, {0x02, 0x6d, 0x06}}, Stats{BusyNaks: 1}},
+		{"start byte and nak", []byte{0x02, 0x15}, [][]byte{{0x02, 0x15}}, Stats{BytesDiscarded: 1, FramesResynced: 1, BusyNaks: 1}},
+		{"unknown command", []byte{0x02, 0x42, 0x02, 0x6d, 0x06}, [][]byte{{0x02, 0x6d, 0x06}}, Stats{BytesDiscarded: 2, FramesResynced: 1}},
+		{"bad ack", []byte{0x02, 0x6d, 0x02, 0x6d, 0x06}, [][]byte{{0x02, 0x6d, 0x06}}, Stats{BytesDiscarded: 2, FramesResynced: 1}},
+		{"std msg with ext flag", []byte{0x02, 0x50, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x1f, 0x02, 0x6d, 0x06}, [][]byte{{0x02, 0x6d, 0x06}}, Stats{BytesDiscarded: 9, FramesResynced: 2}},
+		{
+			"extended echo",
+			[]byte{0x02, 0x62, 0x01, 0x02, 0x03, 0x1f, 0x2f, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xd1, 0x06},
+			[][]byte{{0x02, 0x62, 0x01, 0x02, 0x03, 0x1f, 0x2f, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xd1, 0x06}},
+			Stats{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			port := &Port{in: bufio.NewReader(bytes.NewReader(test.input)), timeout: time.Second}
+			for _, want := range test.want {
+				got, err := port.Read()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				} else if !bytes.Equal(want, got) {
+					t.Errorf("want %x got %x", want, got)
+				}
+			}
+
+			if _, err := port.Read(); err != io.EOF {
+				t.Errorf("want %v got %v", io.EOF, err)
+			}
+
+			if got := port.Stats(); got != test.wantStats {
+				t.Errorf("want stats %+v got %+v", test.wantStats, got)
+			}
+		})
+	}
+}
+
+func TestPortReadTruncated(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	port := &Port{in: bufio.NewReader(r), timeout: 10 * time.Millisecond}
+	go func() {
+		w.Write([]byte{0x02, 0x60, 0x01, 0x02})
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte{0x02, 0x6d, 0x06})
+	}()
+
+	got, err := port.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !bytes.Equal([]byte{0x02, 0x6d, 0x06}, got) {
+		t.Errorf("want 02 6d 06 got %x", got)
+	}
+
+	// the trailing start byte of the truncated frame times out too
+	want := Stats{BytesDiscarded: 4, FramesResynced: 2}
+	if got := port.Stats(); got != want {
+		t.Errorf("want stats %+v got %+v", want, got)
+	}
+}
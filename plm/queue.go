@@ -0,0 +1,279 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// Priority determines the order in which queued commands are sent
+// to the PLM.  Lower values are sent first
+type Priority int
+
+// Priority classes for commands sent to the PLM
+const (
+	// PriorityInteractive is used for commands that control devices,
+	// someone is usually waiting for the lights to change
+	PriorityInteractive Priority = iota
+
+	// PriorityStatus is used for status requests and queries
+	PriorityStatus
+
+	// PriorityBulk is used for long running operations such as reading
+	// and writing All-Link databases and device configuration
+	PriorityBulk
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "Interactive"
+	case PriorityStatus:
+		return "Status"
+	case PriorityBulk:
+		return "Bulk"
+	}
+	return "Unknown"
+}
+
+// MaxInFlight sets the number of messages that can be in flight to a
+// single device at once. A message is in flight from the time it is sent
+// until the time it takes to propagate across the network (see
+// insteon.PropagationDelay).  The default is one
+func MaxInFlight(n int) Option {
+	return func(p *PLM) error {
+		p.queue.maxInFlight = n
+		return nil
+	}
+}
+
+// classify determines the priority of a packet based on the IM command
+// and, for Insteon messages, the command being sent to the device
+func classify(packet *Packet) Priority {
+	switch packet.Command {
+	case CmdSendInsteonMsg:
+		if len(packet.Payload) > 4 {
+			switch packet.Payload[4] {
+			case 0x03, 0x10, 0x19, 0x1f:
+				// product data, id request, status request and get
+				// operating flags
+				return PriorityStatus
+			case 0x20, 0x28, 0x29, 0x2b, 0x2e, 0x2f:
+				// set operating flags, peek/poke, extended set and
+				// read/write ALDB
+				return PriorityBulk
+			}
+		}
+	case CmdGetInfo, CmdGetConfig, CmdGetAllLinkForSender:
+		return PriorityStatus
+	case CmdGetFirstAllLink, CmdGetNextAllLink, CmdManageAllLinkRecord, CmdSetConfig, CmdSetHostCategory:
+		return PriorityBulk
+	}
+	return PriorityInteractive
+}
+
+// destination returns the address an Insteon message is sent to. IM
+// commands are all queued to the zero address
+func destination(packet *Packet) (dst insteon.Address) {
+	if packet.Command == CmdSendInsteonMsg && len(packet.Payload) >= 3 {
+		copy(dst[:], packet.Payload[0:3])
+	}
+	return dst
+}
+
+type sendRequest struct {
+	priority  Priority
+	dst       insteon.Address
+	ready     chan struct{}
+	notBefore time.Time
+}
+
+func newSendRequest(packet *Packet) *sendRequest {
+	return &sendRequest{
+		priority: classify(packet),
+		dst:      destination(packet),
+		ready:    make(chan struct{}),
+	}
+}
+
+// sendQueue schedules access to the PLM.  Requests are granted one at a time
+// in priority order. Requests for the same destination are always granted in
+// the order they were queued, and a destination with too many messages in
+// flight is skipped until one of them has had time to propagate
+type sendQueue struct {
+	mu          sync.Mutex
+	pending     []*sendRequest
+	busy        bool
+	inFlight    map[insteon.Address][]time.Time
+	maxInFlight int
+	timer       *time.Timer
+}
+
+// acquire blocks until the request is at the front of the queue. If cancel
+// is closed first then the request is removed from the queue and acquire
+// returns false
+func (sq *sendQueue) acquire(req *sendRequest, cancel <-chan struct{}) bool {
+	sq.mu.Lock()
+	sq.pending = append(sq.pending, req)
+	sq.dispatch()
+	sq.mu.Unlock()
+	return sq.wait(req, cancel)
+}
+
+// requeue gives up the port and puts the request back at the front of the
+// queue, where it waits for delay before it is granted again.  No later
+// request for the same destination is granted before it, so retrying a
+// command never changes the order of the messages sent to a device
+func (sq *sendQueue) requeue(req *sendRequest, delay time.Duration, cancel <-chan struct{}) bool {
+	sq.mu.Lock()
+	sq.busy = false
+	req.ready = make(chan struct{})
+	req.notBefore = time.Now().Add(delay)
+	sq.pending = append([]*sendRequest{req}, sq.pending...)
+	sq.dispatch()
+	sq.mu.Unlock()
+	return sq.wait(req, cancel)
+}
+
+func (sq *sendQueue) wait(req *sendRequest, cancel <-chan struct{}) bool {
+	select {
+	case <-req.ready:
+		return true
+	case <-cancel:
+	}
+
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	select {
+	case <-req.ready:
+		// granted at the same time it was cancelled, hand
+		// the port to the next request
+		sq.busy = false
+	default:
+		for i, pending := range sq.pending {
+			if pending == req {
+				sq.pending = append(sq.pending[:i], sq.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	sq.dispatch()
+	return false
+}
+
+// release marks the request complete. The request's destination is
+// considered busy until the window has passed
+func (sq *sendQueue) release(req *sendRequest, window time.Duration) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	sq.busy = false
+	if window > 0 {
+		if sq.inFlight == nil {
+			sq.inFlight = make(map[insteon.Address][]time.Time)
+		}
+		sq.inFlight[req.dst] = append(sq.inFlight[req.dst], time.Now().Add(window))
+	}
+	sq.dispatch()
+}
+
+// expire removes messages that have finished propagating and returns
+// the number of messages still in flight to dst along with the time the
+// earliest will finish
+func (sq *sendQueue) expire(dst insteon.Address, now time.Time) (int, time.Time) {
+	inFlight := sq.inFlight[dst][:0]
+	var next time.Time
+	for _, expiry := range sq.inFlight[dst] {
+		if expiry.After(now) {
+			inFlight = append(inFlight, expiry)
+			if next.IsZero() || expiry.Before(next) {
+				next = expiry
+			}
+		}
+	}
+
+	if len(inFlight) == 0 {
+		delete(sq.inFlight, dst)
+	} else {
+		sq.inFlight[dst] = inFlight
+	}
+	return len(inFlight), next
+}
+
+// dispatch grants the port to the next eligible request. The caller
+// must hold the lock
+func (sq *sendQueue) dispatch() {
+	if sq.busy || len(sq.pending) == 0 {
+		return
+	}
+
+	maxInFlight := sq.maxInFlight
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+
+	now := time.Now()
+	next := -1
+	var wake time.Time
+	seen := make(map[insteon.Address]bool)
+	for i, req := range sq.pending {
+		// only the oldest request for each destination is eligible
+		if seen[req.dst] {
+			continue
+		}
+		seen[req.dst] = true
+
+		if req.notBefore.After(now) {
+			// waiting to be retried
+			if wake.IsZero() || req.notBefore.Before(wake) {
+				wake = req.notBefore
+			}
+			continue
+		}
+
+		if n, expiry := sq.expire(req.dst, now); n >= maxInFlight {
+			if wake.IsZero() || expiry.Before(wake) {
+				wake = expiry
+			}
+			continue
+		}
+
+		if next == -1 || req.priority < sq.pending[next].priority {
+			next = i
+		}
+	}
+
+	if next == -1 {
+		// everything is waiting for messages to propagate
+		// or for a retry
+		if sq.timer != nil {
+			sq.timer.Stop()
+		}
+		sq.timer = time.AfterFunc(wake.Sub(now), func() {
+			sq.mu.Lock()
+			defer sq.mu.Unlock()
+			sq.dispatch()
+		})
+		return
+	}
+
+	req := sq.pending[next]
+	sq.pending = append(sq.pending[:next], sq.pending[next+1:]...)
+	sq.busy = true
+	insteon.Log.Tracef("Dispatching %v request for %v (%d queued)", req.priority, req.dst, len(sq.pending))
+	close(req.ready)
+}
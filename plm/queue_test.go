@@ -0,0 +1,218 @@
+package plm
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+func TestClassify(t *testing.T) {
+	msg := func(cmd1 byte) *Packet {
+		return &Packet{Command: CmdSendInsteonMsg, Payload: []byte{1, 2, 3, 0x0f, cmd1, 0x00}}
+	}
+
+	tests := []struct {
+		desc  string
+		input *Packet
+		want  Priority
+	}{
+		{"Light On", msg(0x11), PriorityInteractive},
+		{"Status Request", msg(0x19), PriorityStatus},
+		{"Read ALDB", msg(0x2f), PriorityBulk},
+		{"Get Info", &Packet{Command: CmdGetInfo}, PriorityStatus},
+		{"Get Next Link", &Packet{Command: CmdGetNextAllLink}, PriorityBulk},
+		{"Group Command", &Packet{Command: CmdSendAllLink, Payload: []byte{1, 0x11, 0}}, PriorityInteractive},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := classify(test.input); got != test.want {
+				t.Errorf("want %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSendQueue(t *testing.T) {
+	addr1 := insteon.Address{1, 2, 3}
+	addr2 := insteon.Address{4, 5, 6}
+
+	tests := []struct {
+		desc  string
+		input []*sendRequest
+		want  []int
+	}{
+		{"priority", []*sendRequest{{priority: PriorityBulk, dst: addr1}, {priority: PriorityStatus, dst: addr2}, {priority: PriorityInteractive}}, []int{2, 1, 0}},
+		{"fifo", []*sendRequest{{priority: PriorityStatus, dst: addr1}, {priority: PriorityStatus, dst: addr2}}, []int{0, 1}},
+		{"destination order", []*sendRequest{{priority: PriorityBulk, dst: addr1}, {priority: PriorityInteractive, dst: addr1}, {priority: PriorityStatus, dst: addr2}}, []int{2, 0, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			sq := &sendQueue{}
+
+			// hold the queue while the requests are queued
+			hold := &sendRequest{ready: make(chan struct{})}
+			sq.acquire(hold, nil)
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			var got []int
+			for i, req := range test.input {
+				req.ready = make(chan struct{})
+				wg.Add(1)
+				go func(i int, req *sendRequest) {
+					defer wg.Done()
+					sq.acquire(req, nil)
+					mu.Lock()
+					got = append(got, i)
+					mu.Unlock()
+					sq.release(req, 0)
+				}(i, req)
+
+				// wait for the request to be queued so the order is predictable
+				for queued := 0; queued <= i; {
+					sq.mu.Lock()
+					queued = len(sq.pending)
+					sq.mu.Unlock()
+				}
+			}
+
+			sq.release(hold, 0)
+			wg.Wait()
+
+			if len(got) != len(test.want) {
+				t.Fatalf("want %v got %v", test.want, got)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("want %v got %v", test.want, got)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSendQueueInFlight(t *testing.T) {
+	addr1 := insteon.Address{1, 2, 3}
+	addr2 := insteon.Address{4, 5, 6}
+	window := 50 * time.Millisecond
+
+	sq := &sendQueue{}
+	first := newSendRequest(&Packet{Command: CmdSendInsteonMsg, Payload: []byte{1, 2, 3, 0x0f, 0x11, 0xff}})
+	sq.acquire(first, nil)
+	sq.release(first, window)
+
+	start := time.Now()
+	same := &sendRequest{dst: addr1, ready: make(chan struct{})}
+	other := &sendRequest{dst: addr2, ready: make(chan struct{})}
+	done := make(chan *sendRequest, 2)
+	for _, req := range []*sendRequest{same, other} {
+		go func(req *sendRequest) {
+			sq.acquire(req, nil)
+			done <- req
+			sq.release(req, 0)
+		}(req)
+	}
+
+	if got := <-done; got != other {
+		t.Errorf("expected the request for %v to be sent first", addr2)
+	}
+
+	if got := <-done; got != same {
+		t.Errorf("expected the request for %v to be sent second", addr1)
+	} else if elapsed := time.Since(start); elapsed < window {
+		t.Errorf("request for %v was sent after %v, want at least %v", addr1, elapsed, window)
+	}
+
+	// a higher limit allows more messages to the same destination
+	sq.maxInFlight = 2
+	for _, window := range []time.Duration{time.Hour, 0} {
+		req := &sendRequest{dst: addr2, ready: make(chan struct{})}
+		select {
+		case <-acquired(sq, req):
+			sq.release(req, window)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the second message to %v", addr2)
+		}
+	}
+}
+
+func acquired(sq *sendQueue, req *sendRequest) <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		sq.acquire(req, nil)
+		close(ch)
+	}()
+	return ch
+}
+
+func TestSendQueueRequeue(t *testing.T) {
+	addr1 := insteon.Address{1, 2, 3}
+	addr2 := insteon.Address{4, 5, 6}
+	delay := 50 * time.Millisecond
+
+	sq := &sendQueue{}
+	retried := &sendRequest{dst: addr1, ready: make(chan struct{})}
+	sq.acquire(retried, nil)
+
+	done := make(chan *sendRequest, 3)
+	go func() {
+		if sq.requeue(retried, delay, nil) {
+			done <- retried
+			sq.release(retried, 0)
+		}
+	}()
+
+	// wait for the retry to be queued
+	for queued := 0; queued == 0; {
+		sq.mu.Lock()
+		queued = len(sq.pending)
+		sq.mu.Unlock()
+	}
+
+	start := time.Now()
+	later := &sendRequest{dst: addr1, priority: PriorityInteractive, ready: make(chan struct{})}
+	other := &sendRequest{dst: addr2, priority: PriorityBulk, ready: make(chan struct{})}
+	for _, req := range []*sendRequest{later, other} {
+		go func(req *sendRequest) {
+			sq.acquire(req, nil)
+			done <- req
+			sq.release(req, 0)
+		}(req)
+	}
+
+	want := []*sendRequest{other, retried, later}
+	for i, w := range want {
+		select {
+		case got := <-done:
+			if got != w {
+				t.Errorf("request %d: want %v got %v", i, w.dst, got.dst)
+			}
+
+			if got == retried && time.Since(start) < delay/2 {
+				t.Errorf("retried request was granted before its delay")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for request %d", i)
+		}
+	}
+
+	// cancelling a retry removes it from the queue
+	cancel := make(chan struct{})
+	close(cancel)
+	retried = &sendRequest{dst: addr1, ready: make(chan struct{})}
+	sq.acquire(retried, nil)
+	if sq.requeue(retried, time.Hour, cancel) {
+		t.Errorf("want cancelled requeue to fail")
+	}
+
+	select {
+	case <-acquired(sq, &sendRequest{dst: addr1, ready: make(chan struct{})}):
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for the queue after the retry was cancelled")
+	}
+}
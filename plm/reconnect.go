@@ -0,0 +1,364 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// ErrDisconnected is returned when the connection to the PLM is lost. Any
+// requests waiting on the PLM when the connection drops fail with
+// ErrDisconnected
+var ErrDisconnected = errors.New("PLM is disconnected")
+
+// DialFunc opens a new connection to the PLM, such as opening a serial
+// port or a network connection
+type DialFunc func() (io.ReadWriteCloser, error)
+
+// ConnState is the state of a Reconnector's connection
+type ConnState int
+
+// Connection states reported by a Reconnector
+const (
+	Disconnected ConnState = iota
+	Connecting
+	Connected
+)
+
+func (cs ConnState) String() string {
+	switch cs {
+	case Disconnected:
+		return "Disconnected"
+	case Connecting:
+		return "Connecting"
+	case Connected:
+		return "Connected"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(cs))
+}
+
+// stateNotifier is implemented by transports that can report connection
+// state changes to the PLM
+type stateNotifier interface {
+	NotifyState(cb func(ConnState))
+}
+
+// ReconnectOption customizes a Reconnector
+type ReconnectOption func(r *Reconnector)
+
+// ReconnectBackoff sets the minimum and maximum delay between connection
+// attempts.  The delay starts at min and doubles after each failed attempt
+// until it reaches max
+func ReconnectBackoff(min, max time.Duration) ReconnectOption {
+	return func(r *Reconnector) {
+		r.minBackoff = min
+		r.maxBackoff = max
+	}
+}
+
+// ReconnectTimeout sets how long the Reconnector waits for the PLM to respond
+// to the Get Info command after a connection is opened
+func ReconnectTimeout(timeout time.Duration) ReconnectOption {
+	return func(r *Reconnector) {
+		r.timeout = timeout
+	}
+}
+
+// Reconnector is a transport that keeps a connection to the PLM open. When
+// a read or write fails the connection is closed and re-opened using the dial
+// function.  New connections are only used once the PLM has responded to a
+// Get Info command.  Reads block while the connection is down and writes
+// fail with ErrDisconnected
+type Reconnector struct {
+	dial       DialFunc
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	timeout    time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	conn    io.ReadWriteCloser
+	state   ConnState
+	closed  bool
+	closeCh chan struct{}
+	notify  []func(ConnState)
+}
+
+// NewReconnector returns a Reconnector that immediately starts connecting
+// to the PLM using the dial function
+func NewReconnector(dial DialFunc, options ...ReconnectOption) *Reconnector {
+	r := &Reconnector{
+		dial:       dial,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+		timeout:    3 * time.Second,
+		closeCh:    make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mu)
+
+	for _, option := range options {
+		option(r)
+	}
+
+	go r.connectLoop()
+	return r
+}
+
+// Reconnect returns a Reconnector that uses timeout for the PLM handshake
+// and then waits up to twice the timeout for the first connection. If the
+// PLM can't be reached in that time the Reconnector is closed and the error
+// is returned
+func Reconnect(dial DialFunc, timeout time.Duration, options ...ReconnectOption) (*Reconnector, error) {
+	options = append([]ReconnectOption{ReconnectTimeout(timeout)}, options...)
+	r := NewReconnector(dial, options...)
+	err := r.WaitConnected(2 * timeout)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NotifyState registers a callback that is called every time the connection
+// state changes. Callbacks must not block
+func (r *Reconnector) NotifyState(cb func(ConnState)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notify = append(r.notify, cb)
+}
+
+// State returns the current connection state
+func (r *Reconnector) State() ConnState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// WaitConnected waits up to timeout for the connection to be established.
+// ErrDisconnected is returned if the connection is still down
+func (r *Reconnector) WaitConnected(timeout time.Duration) error {
+	connected := make(chan error, 1)
+	go func() {
+		_, err := r.current()
+		connected <- err
+	}()
+
+	select {
+	case err := <-connected:
+		if err == io.EOF {
+			err = ErrDisconnected
+		}
+		return err
+	case <-time.After(timeout):
+		return ErrDisconnected
+	}
+}
+
+func (r *Reconnector) setState(state ConnState) {
+	r.mu.Lock()
+	if r.closed && state != Disconnected {
+		r.mu.Unlock()
+		return
+	}
+	r.state = state
+	r.cond.Broadcast()
+	notify := make([]func(ConnState), len(r.notify))
+	copy(notify, r.notify)
+	r.mu.Unlock()
+
+	insteon.Log.Debugf("PLM connection %v", state)
+	for _, cb := range notify {
+		cb(state)
+	}
+}
+
+func (r *Reconnector) connectLoop() {
+	backoff := r.minBackoff
+	for {
+		r.mu.Lock()
+		for r.state == Connected && !r.closed {
+			r.cond.Wait()
+		}
+		closed := r.closed
+		r.mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		r.setState(Connecting)
+		conn, err := r.dial()
+		if err == nil {
+			err = r.handshake(conn)
+			if err != nil {
+				conn.Close()
+			}
+		}
+
+		if err == nil {
+			backoff = r.minBackoff
+			r.mu.Lock()
+			r.conn = conn
+			r.mu.Unlock()
+			r.setState(Connected)
+			continue
+		}
+
+		insteon.Log.Infof("Failed to connect to PLM (retrying in %v): %v", backoff, err)
+		r.setState(Disconnected)
+		select {
+		case <-time.After(backoff):
+		case <-r.closeCh:
+		}
+
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// handshake sends a Get Info command and waits for the PLM to respond. The
+// connection is read one byte at a time so that nothing beyond the response
+// is consumed
+func (r *Reconnector) handshake(conn io.ReadWriteCloser) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte{0x02, byte(CmdGetInfo)})
+		buf := make([]byte, commandLens[CmdGetInfo]+2)
+		for i := 0; err == nil && i < len(buf); {
+			_, err = io.ReadFull(conn, buf[i:i+1])
+			switch {
+			case i == 0 && buf[0] != 0x02:
+			case i == 1 && buf[1] == 0x02:
+			case i == 1 && Command(buf[1]) != CmdGetInfo:
+				i = 0
+			default:
+				i++
+			}
+		}
+
+		if err == nil && buf[len(buf)-1] != 0x06 {
+			err = ErrNak
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(r.timeout):
+		// closing the connection unblocks the handshake go routine
+		conn.Close()
+		return ErrReadTimeout
+	}
+}
+
+// current waits for the connection to be established and returns it. If
+// the Reconnector has been closed then io.EOF is returned
+func (r *Reconnector) current() (io.ReadWriteCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.state != Connected && !r.closed {
+		r.cond.Wait()
+	}
+
+	if r.closed {
+		return nil, io.EOF
+	}
+	return r.conn, nil
+}
+
+func (r *Reconnector) disconnect(conn io.ReadWriteCloser, err error) {
+	r.mu.Lock()
+	if r.conn != conn || r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.conn.Close()
+	r.conn = nil
+	r.mu.Unlock()
+
+	insteon.Log.Infof("Lost connection to PLM: %v", err)
+	r.setState(Disconnected)
+}
+
+// Read reads from the PLM connection.  If the connection is down, Read blocks
+// until it has been re-established.  Read only returns an error (io.EOF)
+// once the Reconnector is closed
+func (r *Reconnector) Read(p []byte) (int, error) {
+	for {
+		conn, err := r.current()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := conn.Read(p)
+		if err != nil {
+			r.disconnect(conn, err)
+		}
+
+		if n > 0 || err == nil {
+			return n, nil
+		}
+	}
+}
+
+// Write writes to the PLM connection. ErrDisconnected is returned if the
+// connection is down or the write fails
+func (r *Reconnector) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	conn := r.conn
+	if r.state != Connected || r.closed {
+		conn = nil
+	}
+	r.mu.Unlock()
+
+	if conn == nil {
+		return 0, ErrDisconnected
+	}
+
+	n, err := conn.Write(p)
+	if err != nil {
+		r.disconnect(conn, err)
+		err = ErrDisconnected
+	}
+	return n, err
+}
+
+// Close closes the current connection and stops reconnecting
+func (r *Reconnector) Close() (err error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.closeCh)
+	if r.conn != nil {
+		err = r.conn.Close()
+		r.conn = nil
+	}
+	r.mu.Unlock()
+
+	r.setState(Disconnected)
+	return err
+}
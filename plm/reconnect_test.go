@@ -0,0 +1,261 @@
+package plm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeModem answers Get Info requests on one end of a pipe and
+// forwards any other data to the rx channel
+type fakeModem struct {
+	conn net.Conn
+	ack  byte
+	rx   chan []byte
+}
+
+func newFakeModem(ack byte) (*fakeModem, net.Conn) {
+	client, server := net.Pipe()
+	fm := &fakeModem{conn: server, ack: ack, rx: make(chan []byte, 16)}
+	go fm.serve()
+	return fm, client
+}
+
+func (fm *fakeModem) serve() {
+	buf := make([]byte, 64)
+	for {
+		n, err := fm.conn.Read(buf)
+		if err != nil {
+			close(fm.rx)
+			return
+		}
+
+		if bytes.Equal(buf[:n], []byte{0x02, byte(CmdGetInfo)}) {
+			fm.conn.Write([]byte{0x02, byte(CmdGetInfo), 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, fm.ack})
+		} else {
+			rx := make([]byte, n)
+			copy(rx, buf[:n])
+			fm.rx <- rx
+		}
+	}
+}
+
+type testDialer struct {
+	ack    byte
+	err    error
+	modems chan *fakeModem
+}
+
+func (td *testDialer) dial() (io.ReadWriteCloser, error) {
+	if td.err != nil {
+		return nil, td.err
+	}
+	fm, client := newFakeModem(td.ack)
+	td.modems <- fm
+	return client, nil
+}
+
+func newTestReconnector(ack byte, err error) (*Reconnector, *testDialer) {
+	td := &testDialer{ack: ack, err: err, modems: make(chan *fakeModem, 16)}
+	return NewReconnector(td.dial, ReconnectBackoff(time.Millisecond, 10*time.Millisecond), ReconnectTimeout(100*time.Millisecond)), td
+}
+
+func TestConnStateString(t *testing.T) {
+	tests := []struct {
+		input ConnState
+		want  string
+	}{
+		{Disconnected, "Disconnected"},
+		{Connecting, "Connecting"},
+		{Connected, "Connected"},
+		{ConnState(42), "ConnState(42)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			if got := test.input.String(); got != test.want {
+				t.Errorf("want %q got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestReconnectorConnect(t *testing.T) {
+	tests := []struct {
+		desc    string
+		ack     byte
+		dialErr error
+		wantErr error
+	}{
+		{"ACK", 0x06, nil, nil},
+		{"NAK", 0x15, nil, ErrDisconnected},
+		{"Dial Error", 0x06, errors.New("no such port"), ErrDisconnected},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			r, _ := newTestReconnector(test.ack, test.dialErr)
+			defer r.Close()
+
+			err := r.WaitConnected(50 * time.Millisecond)
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if err == nil && r.State() != Connected {
+				t.Errorf("want state %v got %v", Connected, r.State())
+			}
+		})
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	tests := []struct {
+		desc    string
+		ack     byte
+		dialErr error
+		wantErr error
+	}{
+		{"ACK", 0x06, nil, nil},
+		{"NAK", 0x15, nil, ErrDisconnected},
+		{"Dial Error", 0x06, errors.New("no such port"), ErrDisconnected},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			td := &testDialer{ack: test.ack, err: test.dialErr, modems: make(chan *fakeModem, 16)}
+			r, err := Reconnect(td.dial, 25*time.Millisecond, ReconnectBackoff(time.Millisecond, 10*time.Millisecond))
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			}
+
+			if err == nil {
+				if r.State() != Connected {
+					t.Errorf("want state %v got %v", Connected, r.State())
+				}
+				r.Close()
+			} else if r != nil {
+				t.Errorf("want nil Reconnector got %v", r)
+			}
+		})
+	}
+}
+
+func TestReconnectorReconnect(t *testing.T) {
+	r, td := newTestReconnector(0x06, nil)
+	defer r.Close()
+
+	states := make(chan ConnState, 16)
+	r.NotifyState(func(state ConnState) { states <- state })
+
+	if err := r.WaitConnected(time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fm := <-td.modems
+
+	// a read from the new connection should get the data written after the
+	// connection was lost
+	readCh := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 2)
+		n, _ := io.ReadFull(r, buf)
+		readCh <- buf[:n]
+	}()
+
+	fm.conn.Close()
+
+	// skip the state changes from the initial connection
+	for state := ConnState(-1); state != Disconnected; {
+		select {
+		case state = <-states:
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for state %v", Disconnected)
+		}
+	}
+
+	want := []ConnState{Connecting, Connected}
+	for _, w := range want {
+		select {
+		case got := <-states:
+			if got != w {
+				t.Fatalf("want state %v got %v", w, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for state %v", w)
+		}
+	}
+
+	fm = <-td.modems
+	fm.conn.Write([]byte{0x02, 0x15})
+	select {
+	case got := <-readCh:
+		if !bytes.Equal(got, []byte{0x02, 0x15}) {
+			t.Errorf("want 02 15 got %x", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for read")
+	}
+
+	if _, err := r.Write([]byte{0x02, 0x6d}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if got := <-fm.rx; !bytes.Equal(got, []byte{0x02, 0x6d}) {
+		t.Errorf("want 02 6d got %x", got)
+	}
+}
+
+func TestReconnectorClose(t *testing.T) {
+	r, _ := newTestReconnector(0x06, nil)
+	if err := r.WaitConnected(time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		readErr <- err
+	}()
+
+	r.Close()
+	select {
+	case err := <-readErr:
+		if err != io.EOF {
+			t.Errorf("want error %v got %v", io.EOF, err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for read to return")
+	}
+
+	if _, err := r.Write([]byte{0x02}); err != ErrDisconnected {
+		t.Errorf("want error %v got %v", ErrDisconnected, err)
+	}
+}
+
+func TestPlmDisconnect(t *testing.T) {
+	r, td := newTestReconnector(0x06, nil)
+	defer r.Close()
+	if err := r.WaitConnected(time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fm := <-td.modems
+
+	plm, _ := New(NewPort(r, time.Second), 5*time.Second)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := plm.send(&Packet{Command: CmdLedOn})
+		errCh <- err
+	}()
+
+	// wait for the command to be written and then drop the connection
+	<-fm.rx
+	fm.conn.Close()
+
+	select {
+	case err := <-errCh:
+		if err != ErrDisconnected {
+			t.Errorf("want error %v got %v", ErrDisconnected, err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("in-flight request did not fail when the connection was lost")
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+var (
+	// ErrRFC2217Refused is returned when a serial server refuses the
+	// RFC 2217 com port control option
+	ErrRFC2217Refused = errors.New("server refused RFC 2217 com port control")
+
+	// ErrRFC2217Settings is returned when a serial server doesn't apply
+	// the serial port settings required by the PLM
+	ErrRFC2217Settings = errors.New("server did not apply serial port settings")
+
+	// RFC2217Timeout is the maximum time to wait for a serial server
+	// to confirm the serial port settings
+	RFC2217Timeout = 5 * time.Second
+)
+
+// telnet commands and options used by RFC 2217
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetBinary  = 0
+	telnetSGA     = 3
+	telnetComPort = 44
+)
+
+// com port control commands, the server responds to each command with the
+// command plus comPortServerOffset
+const (
+	comPortSetBaudRate  = 1
+	comPortSetDataSize  = 2
+	comPortSetParity    = 3
+	comPortSetStopSize  = 4
+	comPortSetControl   = 5
+	comPortServerOffset = 100
+)
+
+type telnetState int
+
+const (
+	telnetData telnetState = iota
+	telnetCommand
+	telnetOption
+	telnetSubnegotiation
+	telnetSubnegotiationIAC
+)
+
+// comPortSettings are the serial port settings required by the PLM: 19200
+// baud, 8 data bits, no parity, 1 stop bit and no flow control
+func comPortSettings() map[byte][]byte {
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, 19200)
+	return map[byte][]byte{
+		comPortSetBaudRate: baud,
+		comPortSetDataSize: {8},
+		comPortSetParity:   {1},
+		comPortSetStopSize: {1},
+		comPortSetControl:  {1},
+	}
+}
+
+// rfc2217Conn is a connection to an RFC 2217 serial server. Telnet commands
+// are removed from the data read from the server and 0xff bytes written to
+// the server are escaped, so the connection is a clean byte stream to the PLM
+type rfc2217Conn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	state   telnetState
+	command byte
+	sb      []byte
+	pending []byte
+	err     error
+
+	// options that have been agreed to with the server
+	will map[byte]bool
+	do   map[byte]bool
+
+	// responses from the server to the com port control commands
+	settings map[byte][]byte
+}
+
+// DialRFC2217 returns a DialFunc that connects to a PLM attached to a network
+// serial server that supports RFC 2217. The serial port is configured for
+// the PLM before the connection is returned
+func DialRFC2217(address string) DialFunc {
+	return func() (io.ReadWriteCloser, error) {
+		conn, err := net.DialTimeout("tcp", address, RFC2217Timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		rc := newRFC2217Conn(conn)
+		err = rc.negotiate(RFC2217Timeout)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return rc, nil
+	}
+}
+
+// NewRFC2217Port connects to a PLM through an RFC 2217 serial server and returns
+// a Port that reconnects whenever the connection is lost. An error is returned
+// if the first connection can't be established within twice the timeout
+func NewRFC2217Port(address string, timeout time.Duration, options ...ReconnectOption) (*Port, error) {
+	conn, err := Reconnect(DialRFC2217(address), timeout, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	return NewPort(conn, timeout), nil
+}
+
+func newRFC2217Conn(conn net.Conn) *rfc2217Conn {
+	return &rfc2217Conn{
+		conn:     conn,
+		will:     map[byte]bool{telnetBinary: true, telnetSGA: true, telnetComPort: true},
+		do:       map[byte]bool{telnetBinary: true, telnetSGA: true},
+		settings: make(map[byte][]byte),
+	}
+}
+
+func (rc *rfc2217Conn) send(buf ...byte) error {
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+	_, err := rc.conn.Write(buf)
+	return err
+}
+
+// negotiate enables binary mode and com port control and then waits
+// for the server to confirm each of the serial port settings
+func (rc *rfc2217Conn) negotiate(timeout time.Duration) error {
+	buf := []byte{
+		telnetIAC, telnetWILL, telnetComPort,
+		telnetIAC, telnetWILL, telnetBinary,
+		telnetIAC, telnetDO, telnetBinary,
+		telnetIAC, telnetWILL, telnetSGA,
+		telnetIAC, telnetDO, telnetSGA,
+	}
+
+	want := comPortSettings()
+	for _, cmd := range []byte{comPortSetBaudRate, comPortSetDataSize, comPortSetParity, comPortSetStopSize, comPortSetControl} {
+		buf = append(buf, telnetIAC, telnetSB, telnetComPort, cmd)
+		buf = append(buf, bytes.Replace(want[cmd], []byte{telnetIAC}, []byte{telnetIAC, telnetIAC}, -1)...)
+		buf = append(buf, telnetIAC, telnetSE)
+	}
+
+	err := rc.send(buf...)
+	if err != nil {
+		return err
+	}
+
+	rc.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer rc.conn.SetReadDeadline(time.Time{})
+
+	readBuf := make([]byte, 256)
+	for len(rc.settings) < len(want) {
+		n, err := rc.conn.Read(readBuf)
+		rc.parse(readBuf[:n])
+		if rc.err != nil {
+			return rc.err
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for cmd, value := range want {
+		if !bytes.Equal(value, rc.settings[cmd]) {
+			insteon.Log.Infof("RFC 2217 server set option %d to % x wanted % x", cmd, rc.settings[cmd], value)
+			return ErrRFC2217Settings
+		}
+	}
+	return nil
+}
+
+// parse removes telnet commands from buf, appending the data that is left
+// to the pending buffer and responding to option negotiation
+func (rc *rfc2217Conn) parse(buf []byte) {
+	for _, b := range buf {
+		switch rc.state {
+		case telnetData:
+			if b == telnetIAC {
+				rc.state = telnetCommand
+			} else {
+				rc.pending = append(rc.pending, b)
+			}
+		case telnetCommand:
+			rc.state = telnetData
+			switch b {
+			case telnetIAC:
+				rc.pending = append(rc.pending, b)
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				rc.command = b
+				rc.state = telnetOption
+			case telnetSB:
+				rc.sb = rc.sb[:0]
+				rc.state = telnetSubnegotiation
+			}
+		case telnetOption:
+			rc.option(rc.command, b)
+			rc.state = telnetData
+		case telnetSubnegotiation:
+			if b == telnetIAC {
+				rc.state = telnetSubnegotiationIAC
+			} else {
+				rc.sb = append(rc.sb, b)
+			}
+		case telnetSubnegotiationIAC:
+			if b == telnetSE {
+				rc.subnegotiation(rc.sb)
+				rc.state = telnetData
+			} else {
+				rc.sb = append(rc.sb, b)
+				rc.state = telnetSubnegotiation
+			}
+		}
+	}
+}
+
+// option responds to the server's option negotiation. Only binary mode,
+// suppress go ahead and com port control are supported, everything else
+// is refused
+func (rc *rfc2217Conn) option(command, option byte) {
+	supported := option == telnetBinary || option == telnetSGA || option == telnetComPort
+	switch command {
+	case telnetDO:
+		if !supported {
+			rc.send(telnetIAC, telnetWONT, option)
+		} else if !rc.will[option] {
+			rc.will[option] = true
+			rc.send(telnetIAC, telnetWILL, option)
+		}
+	case telnetDONT:
+		if option == telnetComPort {
+			rc.err = ErrRFC2217Refused
+		}
+
+		if rc.will[option] {
+			rc.will[option] = false
+			rc.send(telnetIAC, telnetWONT, option)
+		}
+	case telnetWILL:
+		if !supported {
+			rc.send(telnetIAC, telnetDONT, option)
+		} else if !rc.do[option] {
+			rc.do[option] = true
+			rc.send(telnetIAC, telnetDO, option)
+		}
+	case telnetWONT:
+		if rc.do[option] {
+			rc.do[option] = false
+			rc.send(telnetIAC, telnetDONT, option)
+		}
+	}
+}
+
+// subnegotiation records the server's responses to the com port control
+// commands. Notifications, such as line and modem state, are ignored
+func (rc *rfc2217Conn) subnegotiation(sb []byte) {
+	if len(sb) < 2 || sb[0] != telnetComPort {
+		return
+	}
+
+	if cmd := int(sb[1]) - comPortServerOffset; comPortSetBaudRate <= cmd && cmd <= comPortSetControl {
+		rc.settings[byte(cmd)] = append([]byte(nil), sb[2:]...)
+	}
+}
+
+// Read returns the data received from the PLM with any telnet
+// commands removed
+func (rc *rfc2217Conn) Read(p []byte) (n int, err error) {
+	buf := make([]byte, len(p))
+	for len(rc.pending) == 0 {
+		n, err = rc.conn.Read(buf)
+		rc.parse(buf[:n])
+		if err != nil && len(rc.pending) == 0 {
+			return 0, err
+		}
+	}
+
+	n = copy(p, rc.pending)
+	rc.pending = rc.pending[n:]
+	return n, nil
+}
+
+// Write escapes any 0xff bytes in p and sends it to the PLM
+func (rc *rfc2217Conn) Write(p []byte) (int, error) {
+	err := rc.send(bytes.Replace(p, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC}, -1)...)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rc *rfc2217Conn) Close() error {
+	return rc.conn.Close()
+}
@@ -0,0 +1,196 @@
+package plm
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// fakeSerialServer is an RFC 2217 serial server with a PLM attached that
+// answers Get Info
+type fakeSerialServer struct {
+	listener net.Listener
+	refuse   bool
+
+	mu       sync.Mutex
+	settings map[byte][]byte
+}
+
+func newFakeSerialServer(t *testing.T, refuse bool) *fakeSerialServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := &fakeSerialServer{listener: listener, refuse: refuse, settings: make(map[byte][]byte)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (fs *fakeSerialServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var data []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+
+		if b != telnetIAC {
+			data = append(data, b)
+			if bytes.Equal(data, []byte{0x02, 0x60}) {
+				// the address 0xff is escaped and a modem state notification
+				// is sent in the middle of the response
+				conn.Write([]byte{0x02, 0x60, telnetIAC, telnetIAC, 0x02, telnetIAC, telnetSB, telnetComPort, 107, 0x30, telnetIAC, telnetSE, 0x03, 0x03, 0x15, 0x9b, 0x06})
+				data = nil
+			}
+			continue
+		}
+
+		b, _ = reader.ReadByte()
+		switch b {
+		case telnetWILL:
+			option, _ := reader.ReadByte()
+			if option == telnetComPort && fs.refuse {
+				conn.Write([]byte{telnetIAC, telnetDONT, option})
+			} else {
+				conn.Write([]byte{telnetIAC, telnetDO, option})
+			}
+		case telnetDO, telnetDONT, telnetWONT:
+			reader.ReadByte()
+		case telnetSB:
+			var sb []byte
+			for {
+				b, _ = reader.ReadByte()
+				if b == telnetIAC {
+					if b, _ = reader.ReadByte(); b == telnetSE {
+						break
+					}
+				}
+				sb = append(sb, b)
+			}
+
+			fs.mu.Lock()
+			fs.settings[sb[1]] = sb[2:]
+			fs.mu.Unlock()
+			reply := append([]byte{telnetIAC, telnetSB, telnetComPort, sb[1] + comPortServerOffset}, sb[2:]...)
+			conn.Write(append(reply, telnetIAC, telnetSE))
+		}
+	}
+}
+
+func TestRFC2217Parse(t *testing.T) {
+	tests := []struct {
+		desc         string
+		input        []byte
+		want         []byte
+		wantSettings map[byte][]byte
+	}{
+		{"data", []byte{0x02, 0x6d, 0x06}, []byte{0x02, 0x6d, 0x06}, map[byte][]byte{}},
+		{"escaped", []byte{0x02, 0xff, 0xff, 0x06}, []byte{0x02, 0xff, 0x06}, map[byte][]byte{}},
+		{"option", []byte{0x02, telnetIAC, telnetWILL, telnetBinary, 0x06}, []byte{0x02, 0x06}, map[byte][]byte{}},
+		{"notification", []byte{0x02, telnetIAC, telnetSB, telnetComPort, 106, 0x60, telnetIAC, telnetSE, 0x06}, []byte{0x02, 0x06}, map[byte][]byte{}},
+		{"baud rate", []byte{telnetIAC, telnetSB, telnetComPort, 101, 0x00, 0x00, 0x4b, 0x00, telnetIAC, telnetSE}, nil, map[byte][]byte{comPortSetBaudRate: {0x00, 0x00, 0x4b, 0x00}}},
+		{"escaped setting", []byte{telnetIAC, telnetSB, telnetComPort, 102, telnetIAC, telnetIAC, telnetIAC, telnetSE}, nil, map[byte][]byte{comPortSetDataSize: {0xff}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rc := newRFC2217Conn(nil)
+			// feed the input one byte at a time to make sure commands
+			// that are split across reads are handled
+			for _, b := range test.input {
+				rc.parse([]byte{b})
+			}
+
+			if !bytes.Equal(test.want, rc.pending) {
+				t.Errorf("want % x got % x", test.want, rc.pending)
+			}
+
+			if !reflect.DeepEqual(test.wantSettings, rc.settings) {
+				t.Errorf("want settings %v got %v", test.wantSettings, rc.settings)
+			}
+		})
+	}
+}
+
+func TestRFC2217Write(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	rc := newRFC2217Conn(client)
+	defer rc.Close()
+
+	go rc.Write([]byte{0x02, 0x62, 0xff, 0x02})
+	want := []byte{0x02, 0x62, 0xff, 0xff, 0x02}
+	got := make([]byte, len(want))
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < len(got); {
+		n, err := server.Read(got[i:])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		i += n
+	}
+
+	if !bytes.Equal(want, got) {
+		t.Errorf("want % x got % x", want, got)
+	}
+}
+
+func TestRFC2217Port(t *testing.T) {
+	server := newFakeSerialServer(t, false)
+	defer server.listener.Close()
+
+	port, err := NewRFC2217Port(server.listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	plm, err := New(port, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer plm.Close()
+
+	info, err := plm.Info()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if info.Address != (insteon.Address{0xff, 0x02, 0x03}) {
+		t.Errorf("want address ff.02.03 got %v", info.Address)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if want := comPortSettings(); !reflect.DeepEqual(want, server.settings) {
+		t.Errorf("want settings %v got %v", want, server.settings)
+	}
+}
+
+func TestRFC2217Refused(t *testing.T) {
+	server := newFakeSerialServer(t, true)
+	defer server.listener.Close()
+
+	conn, err := DialRFC2217(server.listener.Addr().String())()
+	if err != ErrRFC2217Refused {
+		t.Errorf("want error %v got %v", ErrRFC2217Refused, err)
+	}
+
+	if conn != nil {
+		conn.Close()
+	}
+}
@@ -13,11 +13,11 @@ func TestDeviceString(t *testing.T) {
 		device fmt.Stringer
 		want   string
 	}{
-		{"Config (auto linking)", Config(0x80), "L..."},
-		{"Config (monitor mode)", Config(0x40), ".M.."},
-		{"Config (auto led)", Config(0x20), "..A."},
-		{"Config (deadman)", Config(0x10), "...D"},
-		{"Config (all)", Config(0xF0), "LMAD"},
+		{"Config (auto linking)", Config(0x30), "L..."},
+		{"Config (monitor mode)", Config(0xf0), ".M.."},
+		{"Config (auto led)", Config(0x90), "..A."},
+		{"Config (deadman)", Config(0xa0), "...D"},
+		{"Config (all)", Config(0x40), "LMAD"},
 		{"Version", Version(42), "42"},
 		{"Info", &Info{insteon.Address{1, 2, 3}, insteon.DevCat{4, 5}, Version(6)}, "01.02.03 category 04.05 version 6"},
 		{"manageRecordRequest", &manageRecordRequest{0x25, insteon.ControllerLink(1, insteon.Address{4, 5, 6})}, "25 UC 1 04.05.06 0x00 0x00 0x00"},
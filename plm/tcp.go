@@ -0,0 +1,63 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	// HubPort is the TCP port that Insteon Hubs listen on for raw
+	// PLM serial traffic. It is used when an address has no port
+	HubPort = "9761"
+
+	// TCPKeepAlive is the keepalive period used for TCP connections
+	// to a PLM.  Keepalives detect hubs that have silently gone away
+	// so the connection can be re-established
+	TCPKeepAlive = 30 * time.Second
+)
+
+// hubAddress adds the default hub port to an address if it doesn't
+// already have one
+func hubAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, HubPort)
+	}
+	return address
+}
+
+// DialTCP returns a DialFunc that connects to a PLM that is reachable
+// over a raw TCP socket, such as an Insteon Hub or a ser2net bridge
+func DialTCP(address string) DialFunc {
+	address = hubAddress(address)
+	return func() (io.ReadWriteCloser, error) {
+		dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: TCPKeepAlive}
+		return dialer.Dial("tcp", address)
+	}
+}
+
+// NewTCPPort connects to a PLM over TCP and returns a Port that reconnects
+// whenever the connection is lost. An error is returned if the first connection
+// can't be established within twice the timeout
+func NewTCPPort(address string, timeout time.Duration, options ...ReconnectOption) (*Port, error) {
+	conn, err := Reconnect(DialTCP(address), timeout, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", hubAddress(address), err)
+	}
+	return NewPort(conn, timeout), nil
+}
@@ -0,0 +1,120 @@
+package plm
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHubAddress(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"hub.local", "hub.local:9761"},
+		{"10.0.0.2:8000", "10.0.0.2:8000"},
+		{"::1", "[::1]:9761"},
+		{"[::1]:9761", "[::1]:9761"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			if got := hubAddress(test.input); got != test.want {
+				t.Errorf("want %q got %q", test.want, got)
+			}
+		})
+	}
+}
+
+// testHub accepts connections and answers Get Info on each of them
+type testHub struct {
+	listener net.Listener
+	modems   chan *fakeModem
+}
+
+func newTestHub(t *testing.T) *testHub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	hub := &testHub{listener: listener, modems: make(chan *fakeModem, 4)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			fm := &fakeModem{conn: conn, ack: 0x06, rx: make(chan []byte, 16)}
+			go fm.serve()
+			hub.modems <- fm
+		}
+	}()
+	return hub
+}
+
+func TestTCPPort(t *testing.T) {
+	hub := newTestHub(t)
+	defer hub.listener.Close()
+
+	port, err := NewTCPPort(hub.listener.Addr().String(), 100*time.Millisecond, ReconnectBackoff(time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+
+	fm := <-hub.modems
+	if err := port.Write([]byte{0x02, 0x6d}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if got := <-fm.rx; !bytes.Equal(got, []byte{0x02, 0x6d}) {
+		t.Errorf("want 02 6d got %x", got)
+	}
+
+	// the hub drops the connection, the pending read should detect
+	// it and the port should reconnect
+	type result struct {
+		buf []byte
+		err error
+	}
+	readCh := make(chan result, 1)
+	go func() {
+		buf, err := port.Read()
+		readCh <- result{buf, err}
+	}()
+
+	fm.conn.Close()
+	select {
+	case fm = <-hub.modems:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the port to reconnect")
+	}
+
+	// anything the hub sends before the handshake completes is discarded
+	if err := port.out.(*Reconnector).WaitConnected(time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fm.conn.Write([]byte{0x02, 0x6d, 0x06})
+	select {
+	case got := <-readCh:
+		if got.err != nil {
+			t.Errorf("unexpected error: %v", got.err)
+		} else if !bytes.Equal(got.buf, []byte{0x02, 0x6d, 0x06}) {
+			t.Errorf("want 02 6d 06 got %x", got.buf)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for read")
+	}
+}
+
+func TestTCPPortRefused(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.Close()
+
+	_, err := NewTCPPort(address, 10*time.Millisecond)
+	if err == nil {
+		t.Errorf("expected an error connecting to a closed port")
+	}
+}
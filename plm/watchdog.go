@@ -0,0 +1,210 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// WatchdogReason indicates the condition that the watchdog detected
+type WatchdogReason int
+
+// Conditions reported by the watchdog
+const (
+	// ModemHung indicates the PLM stopped responding to Get Info
+	ModemHung WatchdogReason = iota
+
+	// ModemRecovered indicates the PLM is responding again after
+	// it was reported as hung
+	ModemRecovered
+
+	// ModemReset indicates the PLM reported a user reset (the set
+	// button was held during power up) and has erased its settings
+	ModemReset
+
+	// ModemAddressChanged indicates that a different PLM is
+	// responding than the one that was previously seen
+	ModemAddressChanged
+)
+
+func (wr WatchdogReason) String() string {
+	switch wr {
+	case ModemHung:
+		return "Modem Hung"
+	case ModemRecovered:
+		return "Modem Recovered"
+	case ModemReset:
+		return "Modem Reset"
+	case ModemAddressChanged:
+		return "Modem Address Changed"
+	}
+	return fmt.Sprintf("WatchdogReason(%d)", int(wr))
+}
+
+// WatchdogEvent is delivered to watchdog callbacks when a problem with
+// the PLM is detected
+type WatchdogEvent struct {
+	Reason WatchdogReason
+
+	// Info is the most recent response to Get Info, this is nil
+	// when the modem is hung
+	Info *Info
+
+	// Err is the error that caused the modem to be reported as
+	// hung or the error that occurred while restoring the configuration
+	Err error
+}
+
+func (we WatchdogEvent) String() string {
+	str := we.Reason.String()
+	if we.Info != nil {
+		str = fmt.Sprintf("%s (%v)", str, we.Info)
+	}
+
+	if we.Err != nil {
+		str = fmt.Sprintf("%s: %v", str, we.Err)
+	}
+	return str
+}
+
+type watchdog struct {
+	interval  time.Duration
+	config    *Config
+	callbacks []func(WatchdogEvent)
+	stopCh    chan struct{}
+
+	address insteon.Address
+	known   bool
+	hung    bool
+}
+
+// Watchdog enables the PLM health check.  The PLM is sent a Get Info
+// command every interval. Callbacks registered with OnWatchdog are called
+// when the PLM stops responding, comes back, is reset or is replaced
+func Watchdog(interval time.Duration) Option {
+	return func(p *PLM) error {
+		p.watchdog.interval = interval
+		return nil
+	}
+}
+
+// WatchdogConfig sets the configuration that the watchdog applies to the
+// PLM every time it recovers, is reset or is replaced
+func WatchdogConfig(config Config) Option {
+	return func(p *PLM) error {
+		p.watchdog.config = &config
+		return nil
+	}
+}
+
+// OnWatchdog registers a callback that is called for every watchdog
+// event.  Callbacks are called from the watchdog go routine and should
+// return quickly. Once a callback is registered, events are only logged
+// at the debug level since the callback is expected to report them
+func OnWatchdog(cb func(WatchdogEvent)) Option {
+	return func(p *PLM) error {
+		p.watchdog.callbacks = append(p.watchdog.callbacks, cb)
+		return nil
+	}
+}
+
+func (plm *PLM) watch(stopCh <-chan struct{}) {
+	events := make(chan Event, 4)
+	plm.Subscribe(events)
+	defer plm.Unsubscribe(events)
+
+	ticker := time.NewTicker(plm.watchdog.interval)
+	defer ticker.Stop()
+
+	plm.checkHealth()
+	for {
+		select {
+		case event := <-events:
+			if _, ok := event.(*UserReset); ok {
+				plm.modemReset()
+			}
+		case <-ticker.C:
+			plm.checkHealth()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// fireWatchdog reports the event to the callbacks. The event is only
+// logged at the info level when there are no callbacks to report it
+func (plm *PLM) fireWatchdog(event WatchdogEvent) {
+	if len(plm.watchdog.callbacks) == 0 {
+		insteon.Log.Infof("PLM watchdog: %v", event)
+	} else {
+		insteon.Log.Debugf("PLM watchdog: %v", event)
+	}
+
+	for _, cb := range plm.watchdog.callbacks {
+		cb(event)
+	}
+}
+
+// checkHealth sends a Get Info to the PLM and determines if the PLM
+// has stopped responding, started responding again or has been replaced
+func (plm *PLM) checkHealth() {
+	wd := &plm.watchdog
+	info, err := plm.Info()
+	if err != nil {
+		if !wd.hung {
+			wd.hung = true
+			plm.fireWatchdog(WatchdogEvent{Reason: ModemHung, Err: err})
+		}
+		return
+	}
+
+	if wd.known && info.Address != wd.address {
+		wd.hung = false
+		plm.restore(ModemAddressChanged, info)
+	} else if wd.hung {
+		wd.hung = false
+		plm.restore(ModemRecovered, info)
+	}
+	wd.address = info.Address
+	wd.known = true
+}
+
+func (plm *PLM) modemReset() {
+	info, err := plm.Info()
+	if err != nil {
+		plm.fireWatchdog(WatchdogEvent{Reason: ModemReset, Err: err})
+		return
+	}
+	plm.watchdog.address = info.Address
+	plm.watchdog.known = true
+	plm.restore(ModemReset, info)
+}
+
+// restore discards the cached link database and re-applies the desired
+// configuration before notifying the callbacks
+func (plm *PLM) restore(reason WatchdogReason, info *Info) {
+	plm.Lock()
+	plm.linkdb.age = time.Time{}
+	var err error
+	if plm.watchdog.config != nil {
+		err = plm.SetConfig(*plm.watchdog.config)
+	}
+	plm.Unlock()
+
+	plm.fireWatchdog(WatchdogEvent{Reason: reason, Info: info, Err: err})
+}
@@ -0,0 +1,99 @@
+package plm
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	info1 := &Packet{Command: CmdGetInfo, Payload: []byte{1, 2, 3, 4, 5, 6}, Ack: 0x06}
+	info2 := &Packet{Command: CmdGetInfo, Payload: []byte{7, 8, 9, 4, 5, 6}, Ack: 0x06}
+	setConfig := &Packet{Command: CmdSetConfig, Payload: []byte{0x40}, Ack: 0x06}
+
+	tests := []struct {
+		desc      string
+		check     func(*PLM)
+		acks      []*Packet
+		want      []WatchdogReason
+		wantWrite []byte
+	}{
+		{"healthy", (*PLM).checkHealth, []*Packet{info1}, nil, []byte{0x02, 0x60}},
+		{"hung", (*PLM).checkHealth, nil, []WatchdogReason{ModemHung}, []byte{0x02, 0x60}},
+		{"still hung", (*PLM).checkHealth, nil, nil, []byte{0x02, 0x60}},
+		{"recovered", (*PLM).checkHealth, []*Packet{info1, setConfig}, []WatchdogReason{ModemRecovered}, []byte{0x02, 0x60, 0x02, 0x6b, 0x40}},
+		{"address changed", (*PLM).checkHealth, []*Packet{info2, setConfig}, []WatchdogReason{ModemAddressChanged}, []byte{0x02, 0x60, 0x02, 0x6b, 0x40}},
+		{"same address", (*PLM).checkHealth, []*Packet{info2}, nil, []byte{0x02, 0x60}},
+		{"reset", (*PLM).modemReset, []*Packet{info2, setConfig}, []WatchdogReason{ModemReset}, []byte{0x02, 0x60, 0x02, 0x6b, 0x40}},
+	}
+
+	var got []WatchdogReason
+	out := bytes.NewBuffer(nil)
+	plm := &PLM{timeout: 10 * time.Millisecond, port: &Port{out: out}, plmCh: make(chan *Packet, 2)}
+	for _, option := range []Option{WatchdogConfig(Config(0x40)), OnWatchdog(func(event WatchdogEvent) { got = append(got, event.Reason) })} {
+		option(plm)
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got = nil
+			out.Reset()
+			for _, ack := range test.acks {
+				plm.plmCh <- ack
+			}
+
+			test.check(plm)
+			if len(got) != len(test.want) {
+				t.Fatalf("want events %v got %v", test.want, got)
+			}
+
+			for i, want := range test.want {
+				if got[i] != want {
+					t.Errorf("want event %v got %v", want, got[i])
+				}
+			}
+
+			if !bytes.Equal(test.wantWrite, out.Bytes()) {
+				t.Errorf("want %x got %x", test.wantWrite, out.Bytes())
+			}
+		})
+	}
+}
+
+func TestWatchdogUserReset(t *testing.T) {
+	events := make(chan WatchdogEvent, 1)
+	modem := &PLM{timeout: 10 * time.Millisecond, port: &Port{out: bytes.NewBuffer(nil)}, plmCh: make(chan *Packet, 2)}
+	for _, option := range []Option{Watchdog(time.Hour), OnWatchdog(func(event WatchdogEvent) { events <- event })} {
+		option(modem)
+	}
+
+	// initial check
+	modem.plmCh <- &Packet{Command: CmdGetInfo, Payload: []byte{1, 2, 3, 4, 5, 6}, Ack: 0x06}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go modem.watch(stopCh)
+
+	// wait for the watchdog to subscribe
+	for timeout := time.Now().Add(time.Second); ; {
+		modem.events.mu.Lock()
+		n := len(modem.events.subscribers)
+		modem.events.mu.Unlock()
+		if n > 0 {
+			break
+		} else if timeout.Before(time.Now()) {
+			t.Fatalf("watchdog never subscribed to events")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	modem.plmCh <- &Packet{Command: CmdGetInfo, Payload: []byte{1, 2, 3, 4, 5, 6}, Ack: 0x06}
+	modem.events.publish(&UserReset{})
+	select {
+	case event := <-events:
+		if event.Reason != ModemReset {
+			t.Errorf("want %v got %v", ModemReset, event.Reason)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for watchdog event")
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+var (
+	// ErrX10Format is returned when parsing an X10 address that is not
+	// in the form of a house code followed by a unit code (e.g. A1)
+	ErrX10Format = errors.New("X10 address format is <house code><unit code> (A-P and 1-16)")
+
+	// X10Delay is the amount of time to wait after sending an X10 message
+	// before sending the next message.  X10 messages take considerably
+	// longer than Insteon messages to transmit on the powerline
+	X10Delay = 500 * time.Millisecond
+)
+
+// x10Codes maps house codes A-P and unit codes 1-16 to the X10 encoded nibble
+var x10Codes = [16]byte{0x6, 0xe, 0x2, 0xa, 0x1, 0x9, 0x5, 0xd, 0x7, 0xf, 0x3, 0xb, 0x0, 0x8, 0x4, 0xc}
+
+func x10Encode(i byte) byte { return x10Codes[i&0x0f] }
+
+func x10Decode(b byte) byte {
+	for i, code := range x10Codes {
+		if code == b&0x0f {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+// HouseCode is an X10 house code. House codes are A through P
+type HouseCode byte
+
+func (hc HouseCode) String() string { return string('A' + rune(hc)) }
+
+// UnitCode is an X10 unit code. Unit codes are 1 through 16
+type UnitCode byte
+
+func (uc UnitCode) String() string { return strconv.Itoa(int(uc) + 1) }
+
+// X10Address is the house code and unit code of an X10 device
+type X10Address struct {
+	HouseCode HouseCode
+	UnitCode  UnitCode
+}
+
+// String returns the address in the conventional form of house code
+// followed by unit code (e.g. A1)
+func (addr X10Address) String() string {
+	return fmt.Sprintf("%s%s", addr.HouseCode, addr.UnitCode)
+}
+
+// Set satisfies the flag.Value interface
+func (addr *X10Address) Set(str string) error {
+	str = strings.ToUpper(str)
+	if len(str) < 2 || str[0] < 'A' || 'P' < str[0] {
+		return ErrX10Format
+	}
+
+	unit, err := strconv.Atoi(str[1:])
+	if err != nil || unit < 1 || 16 < unit {
+		return ErrX10Format
+	}
+	addr.HouseCode = HouseCode(str[0] - 'A')
+	addr.UnitCode = UnitCode(unit - 1)
+	return nil
+}
+
+// X10Command is an X10 function code
+type X10Command byte
+
+// X10 function codes
+const (
+	X10AllUnitsOff  X10Command = 0x0
+	X10AllLightsOn  X10Command = 0x1
+	X10On           X10Command = 0x2
+	X10Off          X10Command = 0x3
+	X10Dim          X10Command = 0x4
+	X10Bright       X10Command = 0x5
+	X10AllLightsOff X10Command = 0x6
+)
+
+var x10CmdStrings = map[X10Command]string{
+	X10AllUnitsOff:  "alloff",
+	X10AllLightsOn:  "allon",
+	X10On:           "on",
+	X10Off:          "off",
+	X10Dim:          "dim",
+	X10Bright:       "bright",
+	X10AllLightsOff: "alllightsoff",
+}
+
+func (cmd X10Command) String() string {
+	if str, found := x10CmdStrings[cmd]; found {
+		return str
+	}
+	return fmt.Sprintf("X10Command(0x%x)", byte(cmd))
+}
+
+// Set satisfies the flag.Value interface
+func (cmd *X10Command) Set(str string) error {
+	for c, s := range x10CmdStrings {
+		if s == str {
+			*cmd = c
+			return nil
+		}
+	}
+	return fmt.Errorf("valid X10 commands are {on|off|dim|bright|alloff|allon|alllightsoff}")
+}
+
+// HouseWide indicates that the command applies to all devices with the
+// same house code and no unit code is needed
+func (cmd X10Command) HouseWide() bool {
+	return cmd == X10AllUnitsOff || cmd == X10AllLightsOn || cmd == X10AllLightsOff
+}
+
+// x10 flag byte values indicating the raw byte is a unit code
+// or a function code
+const (
+	x10UnitCode = 0x00
+	x10CmdCode  = 0x80
+)
+
+// x10Msg is the payload of an X10 send or X10 received packet
+type x10Msg struct {
+	raw  byte
+	flag byte
+}
+
+func (msg *x10Msg) MarshalBinary() ([]byte, error) {
+	return []byte{msg.raw, msg.flag}, nil
+}
+
+func (msg *x10Msg) UnmarshalBinary(buf []byte) error {
+	if len(buf) < 2 {
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 2, Got: len(buf)}
+	}
+	msg.raw = buf[0]
+	msg.flag = buf[1]
+	return nil
+}
+
+func (msg *x10Msg) houseCode() HouseCode { return HouseCode(x10Decode(msg.raw >> 4)) }
+func (msg *x10Msg) unitCode() UnitCode   { return UnitCode(x10Decode(msg.raw)) }
+func (msg *x10Msg) command() X10Command  { return X10Command(msg.raw & 0x0f) }
+func (msg *x10Msg) isCommand() bool      { return msg.flag == x10CmdCode }
+
+func newX10Unit(addr X10Address) *x10Msg {
+	return &x10Msg{raw: x10Encode(byte(addr.HouseCode))<<4 | x10Encode(byte(addr.UnitCode)), flag: x10UnitCode}
+}
+
+func newX10Cmd(house HouseCode, cmd X10Command) *x10Msg {
+	return &x10Msg{raw: x10Encode(byte(house))<<4 | byte(cmd)&0x0f, flag: x10CmdCode}
+}
+
+// X10Event is an X10 command that was received by the PLM
+type X10Event struct {
+	// Address is the most recent unit address received for the house code. For
+	// house wide commands only the HouseCode is relevant
+	Address X10Address
+
+	// Command is the X10 function that was received
+	Command X10Command
+}
+
+func (event X10Event) String() string {
+	if event.Command.HouseWide() {
+		return fmt.Sprintf("%s %s", event.Address.HouseCode, event.Command)
+	}
+	return fmt.Sprintf("%s %s", event.Address, event.Command)
+}
+
+// x10Receiver decodes the stream of received X10 packets into events. X10
+// commands are delivered as two separate messages: the unit address followed
+// by the function code. The receiver tracks the last unit address for each
+// house code so the function can be matched to it
+type x10Receiver struct {
+	units [16]UnitCode
+}
+
+func (xr *x10Receiver) receive(msg *x10Msg) (event X10Event, ok bool) {
+	house := msg.houseCode()
+	if msg.isCommand() {
+		event.Address = X10Address{HouseCode: house, UnitCode: xr.units[house]}
+		event.Command = msg.command()
+		ok = true
+	} else {
+		xr.units[house] = msg.unitCode()
+	}
+	return event, ok
+}
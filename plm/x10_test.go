@@ -0,0 +1,170 @@
+package plm
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestX10AddressSet(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    X10Address
+		wantStr string
+		wantErr error
+	}{
+		{"A1", X10Address{0, 0}, "A1", nil},
+		{"p16", X10Address{15, 15}, "P16", nil},
+		{"C10", X10Address{2, 9}, "C10", nil},
+		{"Q1", X10Address{}, "", ErrX10Format},
+		{"A17", X10Address{}, "", ErrX10Format},
+		{"A0", X10Address{}, "", ErrX10Format},
+		{"A", X10Address{}, "", ErrX10Format},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			var got X10Address
+			err := got.Set(test.input)
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if err == nil {
+				if got != test.want {
+					t.Errorf("want address %v got %v", test.want, got)
+				}
+				if got.String() != test.wantStr {
+					t.Errorf("want string %q got %q", test.wantStr, got.String())
+				}
+			}
+		})
+	}
+}
+
+func TestX10CommandSet(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    X10Command
+		wantErr bool
+	}{
+		{"on", X10On, false},
+		{"off", X10Off, false},
+		{"dim", X10Dim, false},
+		{"bright", X10Bright, false},
+		{"alloff", X10AllUnitsOff, false},
+		{"allon", X10AllLightsOn, false},
+		{"foo", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			var got X10Command
+			err := got.Set(test.input)
+			if (err != nil) != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if got != test.want {
+				t.Errorf("want command %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestX10Encoding(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input *x10Msg
+		want  []byte
+	}{
+		{"A1", newX10Unit(X10Address{0, 0}), []byte{0x66, 0x00}},
+		{"M13", newX10Unit(X10Address{12, 12}), []byte{0x00, 0x00}},
+		{"B2", newX10Unit(X10Address{1, 1}), []byte{0xee, 0x00}},
+		{"A On", newX10Cmd(0, X10On), []byte{0x62, 0x80}},
+		{"P Off", newX10Cmd(15, X10Off), []byte{0xc3, 0x80}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, _ := test.input.MarshalBinary()
+			if !bytes.Equal(test.want, got) {
+				t.Errorf("want %x got %x", test.want, got)
+			}
+		})
+	}
+}
+
+func TestX10Receiver(t *testing.T) {
+	xr := &x10Receiver{}
+	tests := []struct {
+		desc   string
+		input  []byte
+		want   X10Event
+		wantOk bool
+	}{
+		{"C3 address", []byte{0x22, 0x00}, X10Event{}, false},
+		{"C On", []byte{0x22, 0x80}, X10Event{X10Address{2, 2}, X10On}, true},
+		{"A1 address", []byte{0x66, 0x00}, X10Event{}, false},
+		{"C Dim", []byte{0x24, 0x80}, X10Event{X10Address{2, 2}, X10Dim}, true},
+		{"A Off", []byte{0x63, 0x80}, X10Event{X10Address{0, 0}, X10Off}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			msg := &x10Msg{}
+			msg.UnmarshalBinary(test.input)
+			got, gotOk := xr.receive(msg)
+			if test.wantOk != gotOk {
+				t.Errorf("want ok %v got %v", test.wantOk, gotOk)
+			} else if got != test.want {
+				t.Errorf("want event %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSendX10(t *testing.T) {
+	tests := []struct {
+		desc string
+		addr X10Address
+		cmd  X10Command
+		want []byte
+		acks int
+	}{
+		{"A1 On", X10Address{0, 0}, X10On, []byte{0x02, 0x63, 0x66, 0x00, 0x02, 0x63, 0x62, 0x80}, 2},
+		{"B All Units Off", X10Address{1, 0}, X10AllUnitsOff, []byte{0x02, 0x63, 0xe0, 0x80}, 1},
+	}
+
+	delay := X10Delay
+	X10Delay = 0
+	defer func() { X10Delay = delay }()
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+			plm := &PLM{timeout: time.Second, port: &Port{out: out}, plmCh: make(chan *Packet, test.acks)}
+			// the PLM echoes each 4 byte X10 packet
+			for i := 0; i < test.acks; i++ {
+				plm.plmCh <- &Packet{Command: CmdSendX10, Payload: test.want[4*i+2 : 4*i+4], Ack: 0x06}
+			}
+
+			err := plm.SendX10(test.addr, test.cmd)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !bytes.Equal(test.want, out.Bytes()) {
+				t.Errorf("want %x got %x", test.want, out.Bytes())
+			}
+		})
+	}
+}
+
+func TestReceiveX10(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{0x02, 0x52, 0x22, 0x00, 0x02, 0x52, 0x22, 0x80})
+	plm, _ := New(&Port{in: bufio.NewReader(buf), out: bytes.NewBuffer(nil)}, time.Second)
+
+	want := X10Event{X10Address{2, 2}, X10On}
+	got, err := plm.ReceiveX10()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if want != got {
+		t.Errorf("want event %v got %v", want, got)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"fmt"
+	"time"
+)
+
+func init() {
+	Devices.Register(0x00, remoteFactory)
+}
+
+// remoteButtons maps the generalized controller sub-categories to the
+// number of buttons (groups) the device has
+var remoteButtons = map[SubCategory]int{
+	0x04: 5, // ControLinc [2430]
+	0x05: 6, // RemoteLinc [2440]
+	0x10: 4, // Mini Remote - 4 Scene [2444A2xx4]
+	0x11: 1, // Mini Remote - Switch [2444A3xx]
+	0x12: 8, // Mini Remote - 8 Scene [2444A2xx8]
+	0x14: 4, // Mini Remote - 4 Scene [2342-432]
+	0x15: 1, // Mini Remote - Switch [2342-442]
+	0x16: 8, // Mini Remote - 8 Scene [2342-422]
+}
+
+// remoteFactory only returns a Remote for known controller sub-categories,
+// all other generalized controllers are returned as the base device
+func remoteFactory(info DeviceInfo, device Device, timeout time.Duration) (Device, error) {
+	if buttons, found := remoteButtons[info.DevCat.SubCategory()]; found {
+		return NewRemote(device, timeout, buttons), nil
+	}
+	return device, nil
+}
+
+// ButtonAction is the action that a controller button press
+// represents
+type ButtonAction int
+
+// The different actions a controller can broadcast for a button
+const (
+	ButtonOn       ButtonAction = iota // button was pressed (on)
+	ButtonOff                          // button was pressed (off)
+	ButtonBrighten                     // button is being held to brighten
+	ButtonDim                          // button is being held to dim
+	ButtonStop                         // button was released after being held
+)
+
+func (ba ButtonAction) String() string {
+	switch ba {
+	case ButtonOn:
+		return "On"
+	case ButtonOff:
+		return "Off"
+	case ButtonBrighten:
+		return "Brighten"
+	case ButtonDim:
+		return "Dim"
+	case ButtonStop:
+		return "Stop"
+	}
+	return fmt.Sprintf("ButtonAction(%d)", int(ba))
+}
+
+// ButtonEvent is a single button press decoded from a controller's
+// All-Link broadcast
+type ButtonEvent struct {
+	// Group is the All-Link group assigned to the button
+	Group Group
+
+	// Action is the action that the button press represents
+	Action ButtonAction
+
+	// Fast indicates the button was double tapped (Fast On/Fast Off)
+	Fast bool
+}
+
+func (be ButtonEvent) String() string {
+	if be.Fast {
+		return sprintf("Group(%d) Fast %s", be.Group, be.Action)
+	}
+	return sprintf("Group(%d) %s", be.Group, be.Action)
+}
+
+// DecodeButtonEvent converts an All-Link broadcast message into the
+// corresponding ButtonEvent.  If the message is not an All-Link broadcast
+// or the command is not a lighting command, then false is returned
+func DecodeButtonEvent(msg *Message) (event ButtonEvent, ok bool) {
+	if msg.Flags.Type() != MsgTypeAllLinkBroadcast {
+		return event, false
+	}
+
+	event.Group = Group(msg.Dst[2])
+	ok = true
+	switch msg.Command[1] {
+	case CmdLightOn[1]:
+		event.Action = ButtonOn
+	case CmdLightOnFast[1]:
+		event.Action = ButtonOn
+		event.Fast = true
+	case CmdLightOff[1]:
+		event.Action = ButtonOff
+	case CmdLightOffFast[1]:
+		event.Action = ButtonOff
+		event.Fast = true
+	case CmdLightBrighten[1]:
+		event.Action = ButtonBrighten
+	case CmdLightDim[1]:
+		event.Action = ButtonDim
+	case CmdLightStartManual[1]:
+		// command 2 indicates the direction of the manual change,
+		// 0x01 is up (brighten) and 0x00 is down (dim)
+		event.Action = ButtonDim
+		if msg.Command[2] == 0x01 {
+			event.Action = ButtonBrighten
+		}
+	case CmdLightStopManual[1]:
+		event.Action = ButtonStop
+	default:
+		ok = false
+	}
+	return event, ok
+}
+
+// ButtonMode determines what a controller button sends when it is pressed
+type ButtonMode byte
+
+// Controller button modes
+const (
+	// ButtonToggle will cause the button to alternate between sending
+	// on and off commands each time it is pressed
+	ButtonToggle ButtonMode = 0x00
+
+	// ButtonOnOff will pair buttons so that one button always sends
+	// on and the other always sends off
+	ButtonOnOff ButtonMode = 0x01
+)
+
+func (bm ButtonMode) String() string {
+	switch bm {
+	case ButtonToggle:
+		return "toggle"
+	case ButtonOnOff:
+		return "onoff"
+	}
+	return fmt.Sprintf("ButtonMode(%d)", byte(bm))
+}
+
+// Set satisfies the flag.Value interface
+func (bm *ButtonMode) Set(str string) (err error) {
+	switch str {
+	case "toggle":
+		*bm = ButtonToggle
+	case "onoff":
+		*bm = ButtonOnOff
+	default:
+		err = fmt.Errorf("valid button modes are {toggle|onoff}")
+	}
+	return err
+}
+
+// Remote is a controller-only device, such as a mini remote or a
+// scene controller.  Each button on the remote is assigned to an
+// All-Link group, button presses are sent as All-Link broadcasts
+// to the group
+type Remote interface {
+	Device
+
+	// Buttons returns the number of buttons (groups) that the remote has
+	Buttons() int
+
+	// SetButtonMode configures whether a button toggles between on and off or
+	// whether it is paired with another button for on/off.  Battery powered
+	// remotes must be awake (set button tapped) in order to receive this
+	// command
+	SetButtonMode(button int, mode ButtonMode) error
+
+	// ReceiveEvent waits for the next button press from the remote. Messages
+	// that are not button presses are discarded
+	ReceiveEvent() (ButtonEvent, error)
+}
+
+// LinkableRemote is a remote that contains a remotely manageable All-Link
+// database (Insteon engine version 2 and higher)
+type LinkableRemote interface {
+	Remote
+	Linkable
+}
+
+type remote struct {
+	Device
+	timeout time.Duration
+	buttons int
+}
+
+type linkableRemote struct {
+	LinkableDevice
+	*remote
+}
+
+// NewRemote will return a remote that has the given number of buttons
+func NewRemote(device Device, timeout time.Duration, buttons int) Remote {
+	r := &remote{Device: device, timeout: timeout, buttons: buttons}
+	if linkable, ok := device.(LinkableDevice); ok {
+		return &linkableRemote{LinkableDevice: linkable, remote: r}
+	}
+	return r
+}
+
+func (r *remote) Buttons() int { return r.buttons }
+
+func (r *remote) SetButtonMode(button int, mode ButtonMode) error {
+	if button < 1 || button > r.buttons {
+		return fmt.Errorf("button %d is out of range (1-%d)", button, r.buttons)
+	}
+	return extractError(r.SendCommand(CmdExtendedGetSet, []byte{byte(button), 0x08, byte(mode)}))
+}
+
+func (r *remote) ReceiveEvent() (event ButtonEvent, err error) {
+	err = Receive(r.Device, r.timeout, func(msg *Message) error {
+		var ok bool
+		if event, ok = DecodeButtonEvent(msg); ok {
+			return ErrReceiveComplete
+		}
+		return nil
+	})
+	return event, err
+}
+
+func (r *remote) String() string {
+	return fmt.Sprintf("Remote (%s)", r.Address())
+}
@@ -0,0 +1,120 @@
+package insteon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRemoteFactory(t *testing.T) {
+	tests := []struct {
+		desc        string
+		input       Device
+		devCat      DevCat
+		want        reflect.Type
+		wantButtons int
+	}{
+		{"Remote", &i1Device{}, DevCat{0x00, 0x05}, reflect.TypeOf(&remote{}), 6},
+		{"Linkable Remote", &i2CsDevice{}, DevCat{0x00, 0x10}, reflect.TypeOf(&linkableRemote{}), 4},
+		{"8 Scene Remote", &i2CsDevice{}, DevCat{0x00, 0x16}, reflect.TypeOf(&linkableRemote{}), 8},
+		{"Unknown", &i2CsDevice{}, DevCat{0x00, 0xfe}, reflect.TypeOf(&i2CsDevice{}), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			device, _ := remoteFactory(DeviceInfo{DevCat: test.devCat}, test.input, 0)
+			got := reflect.TypeOf(device)
+			if test.want != got {
+				t.Errorf("want type %v got %v", test.want, got)
+			}
+
+			if remote, ok := device.(Remote); ok {
+				if buttons := remote.Buttons(); buttons != test.wantButtons {
+					t.Errorf("want %d buttons got %d", test.wantButtons, buttons)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeButtonEvent(t *testing.T) {
+	allLink := func(group byte, cmd Command) *Message {
+		return &Message{Src: testSrcAddr, Dst: Address{0, 0, group}, Flags: StandardAllLinkBroadcast, Command: cmd}
+	}
+
+	tests := []struct {
+		desc   string
+		input  *Message
+		want   ButtonEvent
+		wantOk bool
+	}{
+		{"On", allLink(1, CmdLightOn.SubCommand(0)), ButtonEvent{Group: 1, Action: ButtonOn}, true},
+		{"Fast On", allLink(2, CmdLightOnFast), ButtonEvent{Group: 2, Action: ButtonOn, Fast: true}, true},
+		{"Off", allLink(3, CmdLightOff), ButtonEvent{Group: 3, Action: ButtonOff}, true},
+		{"Fast Off", allLink(4, CmdLightOffFast), ButtonEvent{Group: 4, Action: ButtonOff, Fast: true}, true},
+		{"Brighten", allLink(5, CmdLightStartManual.SubCommand(1)), ButtonEvent{Group: 5, Action: ButtonBrighten}, true},
+		{"Dim", allLink(6, CmdLightStartManual.SubCommand(0)), ButtonEvent{Group: 6, Action: ButtonDim}, true},
+		{"Stop", allLink(7, CmdLightStopManual), ButtonEvent{Group: 7, Action: ButtonStop}, true},
+		{"Unknown Command", allLink(8, CmdHeartbeat), ButtonEvent{Group: 8}, false},
+		{"Direct", &Message{Flags: StandardDirectMessage, Command: CmdLightOn}, ButtonEvent{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, gotOk := DecodeButtonEvent(test.input)
+			if test.wantOk != gotOk {
+				t.Errorf("want ok %v got %v", test.wantOk, gotOk)
+			} else if gotOk && test.want != got {
+				t.Errorf("want event %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestButtonModeSet(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    ButtonMode
+		wantErr bool
+	}{
+		{"toggle", ButtonToggle, false},
+		{"onoff", ButtonOnOff, false},
+		{"foo", ButtonToggle, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			var got ButtonMode
+			err := got.Set(test.input)
+			if (err != nil) != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if got != test.want {
+				t.Errorf("want mode %v got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRemoteCommands(t *testing.T) {
+	tests := []*commandTest{
+		{"SetButtonMode(toggle)", func(d Device) error { return d.(Remote).SetButtonMode(1, ButtonToggle) }, CmdExtendedGetSet, nil, []byte{1, 0x08, 0x00}},
+		{"SetButtonMode(onoff)", func(d Device) error { return d.(Remote).SetButtonMode(4, ButtonOnOff) }, CmdExtendedGetSet, nil, []byte{4, 0x08, 0x01}},
+	}
+
+	testDeviceCommands(t, func(conn *testConnection) Device { return NewRemote(conn, time.Nanosecond, 4) }, tests)
+}
+
+func TestRemoteReceiveEvent(t *testing.T) {
+	conn := &testConnection{recvCh: make(chan *Message, 2)}
+	r := NewRemote(conn, time.Second, 4)
+	conn.recvCh <- TestAck
+	conn.recvCh <- &Message{Src: testSrcAddr, Dst: Address{0, 0, 3}, Flags: StandardAllLinkBroadcast, Command: CmdLightOff}
+
+	want := ButtonEvent{Group: 3, Action: ButtonOff}
+	got, err := r.ReceiveEvent()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if want != got {
+		t.Errorf("want event %v got %v", want, got)
+	}
+}
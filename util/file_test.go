@@ -0,0 +1,39 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insteon")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.json")
+	for _, want := range []string{"first", "second"} {
+		if err := WriteFileAtomic(filename, []byte(want), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if string(got) != want {
+			t.Errorf("want %q got %q", want, got)
+		}
+	}
+
+	// the temporary files must be cleaned up
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("want 1 file got %d", len(files))
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "test.json"), nil, 0644); err == nil {
+		t.Errorf("want error got nil")
+	}
+}
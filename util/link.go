@@ -29,6 +29,10 @@ var (
 
 	// ErrLinkNotFound is returned by the Find function when no matching record was found
 	ErrLinkNotFound = errors.New("Link was not found in the database")
+
+	// ErrLinkNotConfirmed is returned by ForceLink when neither device reported
+	// that the link was created before insteon.LinkTimeout
+	ErrLinkNotConfirmed = errors.New("Link was not confirmed by the device")
 )
 
 // FindDuplicateLinks will perform a linear search of the
@@ -102,8 +106,12 @@ func CrossLink(group insteon.Group, l1, l2 insteon.AddressableLinkable) error {
 
 // ForceLink will create links in the controller and responder All-Link
 // databases without first checking if the links exist. The links are
-// created by simulating set button presses (using EnterLinkingMode)
-func ForceLink(group insteon.Group, controller, responder insteon.Linkable) (err error) {
+// created by simulating set button presses (using EnterLinkingMode).  If
+// either device is a LinkCompleter (such as a PLM), then ForceLink waits up
+// to insteon.LinkTimeout for the device to confirm the link and returns the record
+// that the device created.  Otherwise the returned link is nil and success
+// means both devices acknowledged entering linking mode
+func ForceLink(group insteon.Group, controller, responder insteon.Linkable) (link *insteon.LinkRecord, err error) {
 	// The sequence to create a link between two devices follows:
 	// 1) Controller enters linking mode (same as holding down the set button for 10 seconds)
 	// 2) Controller sends a "Set-Button Pressed Controller" broadcast message
@@ -111,8 +119,8 @@ func ForceLink(group insteon.Group, controller, responder insteon.Linkable) (err
 	// 4) Responder sends a "Set-Button Pressed Responder" broadcast message
 	//
 	// At this point the two devices will exchange direct messages that won't necessarily
-	// be seen by the initiator (such as a PLM), so as soon as the responder broadcast
-	// is received, we assume the linking is complete
+	// be seen by the initiator (such as a PLM). If the PLM is one of the two devices
+	// it will report the completed link
 	insteon.Log.Debugf("Putting controller %s into linking mode", controller)
 
 	// controller enters all-linking mode
@@ -129,10 +137,52 @@ func ForceLink(group insteon.Group, controller, responder insteon.Linkable) (err
 		insteon.Log.Debugf("Assigning responder to group")
 		err = responder.EnterLinkingMode(group)
 		defer responder.ExitLinkingMode()
+
+		if err == nil {
+			link, err = waitLinkComplete(controller, responder)
+		}
 	}
 	return
 }
 
+// waitLinkComplete waits for the first LinkCompleter in the list to
+// report the link
+func waitLinkComplete(linkables ...insteon.Linkable) (*insteon.LinkRecord, error) {
+	for _, linkable := range linkables {
+		if completer, ok := linkable.(insteon.LinkCompleter); ok {
+			link, err := completer.WaitLinkComplete(insteon.LinkTimeout)
+			if err == insteon.ErrReadTimeout {
+				err = ErrLinkNotConfirmed
+			}
+			return link, err
+		}
+	}
+	return nil, nil
+}
+
+// LinkResponders will add responder records for the controller's group
+// directly to each responder's All-Link database. This is useful for
+// controllers, such as battery powered remotes, that cannot easily be put
+// into linking mode.  Responders that already have a matching record are
+// left untouched.  The responder records are set for full on-level and
+// the default ramp rate
+func LinkResponders(group insteon.Group, controller insteon.Address, responders ...insteon.Linkable) error {
+	for _, responder := range responders {
+		_, err := FindLinkRecord(responder, false, controller, group)
+		if err == ErrLinkNotFound {
+			link := insteon.ResponderLink(group, controller)
+			link.Data = [3]byte{0xff, 0x1c, 0x01}
+			insteon.Log.Debugf("Adding responder link %v to %v", link, responder)
+			err = responder.UpdateLinks(link)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UnlinkAll will unlink all groups between a controller and
 // a responder device
 func UnlinkAll(controller, responder insteon.AddressableLinkable) (err error) {
@@ -203,7 +253,7 @@ func Link(group insteon.Group, controller, responder insteon.AddressableLinkable
 		}
 
 		if err == nil || err == ErrLinkNotFound {
-			err = ForceLink(group, controller, responder)
+			_, err = ForceLink(group, controller, responder)
 		}
 	} else if err == nil {
 		_, err = FindLinkRecord(responder, false, controller.Address(), group)
@@ -211,7 +261,7 @@ func Link(group insteon.Group, controller, responder insteon.AddressableLinkable
 			// found a controller link, but not a responder link
 			insteon.Log.Debugf("Responder link already exists, deleting it")
 			err = RemoveLinks(controller, controllerLink)
-			err = ForceLink(group, controller, responder)
+			_, err = ForceLink(group, controller, responder)
 		}
 	}
 	return err
@@ -3,12 +3,14 @@ package util
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/abates/insteon"
 )
 
 type testLinkable struct {
-	links []*insteon.LinkRecord
+	links   []*insteon.LinkRecord
+	updated []*insteon.LinkRecord
 }
 
 func (tl *testLinkable) Links() ([]*insteon.LinkRecord, error) {
@@ -16,10 +18,23 @@ func (tl *testLinkable) Links() ([]*insteon.LinkRecord, error) {
 }
 func (tl *testLinkable) WriteLink(int, *insteon.LinkRecord) error { return nil }
 func (tl *testLinkable) WriteLinks(...*insteon.LinkRecord) error  { return nil }
-func (tl *testLinkable) UpdateLinks(...*insteon.LinkRecord) error { return nil }
-func (tl *testLinkable) EnterLinkingMode(insteon.Group) error     { return nil }
-func (tl *testLinkable) EnterUnlinkingMode(insteon.Group) error   { return nil }
-func (tl *testLinkable) ExitLinkingMode() error                   { return nil }
+func (tl *testLinkable) UpdateLinks(links ...*insteon.LinkRecord) error {
+	tl.updated = append(tl.updated, links...)
+	return nil
+}
+func (tl *testLinkable) EnterLinkingMode(insteon.Group) error   { return nil }
+func (tl *testLinkable) EnterUnlinkingMode(insteon.Group) error { return nil }
+func (tl *testLinkable) ExitLinkingMode() error                 { return nil }
+
+type testLinkCompleter struct {
+	testLinkable
+	link *insteon.LinkRecord
+	err  error
+}
+
+func (tlc *testLinkCompleter) WaitLinkComplete(time.Duration) (*insteon.LinkRecord, error) {
+	return tlc.link, tlc.err
+}
 
 func TestFindDuplicateLinks(t *testing.T) {
 	links := []*insteon.LinkRecord{
@@ -79,3 +94,51 @@ func TestFindLinkRecord(t *testing.T) {
 		})
 	}
 }
+
+func TestLinkResponders(t *testing.T) {
+	controller := insteon.Address{1, 2, 3}
+	existing := &testLinkable{links: []*insteon.LinkRecord{insteon.ResponderLink(1, controller)}}
+	missing := &testLinkable{}
+
+	err := LinkResponders(1, controller, existing, missing)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(existing.updated) != 0 {
+		t.Errorf("want no updates got %v", existing.updated)
+	}
+
+	want := insteon.ResponderLink(1, controller)
+	want.Data = [3]byte{0xff, 0x1c, 0x01}
+	if !reflect.DeepEqual([]*insteon.LinkRecord{want}, missing.updated) {
+		t.Errorf("want updated links %v got %v", []*insteon.LinkRecord{want}, missing.updated)
+	}
+}
+
+func TestForceLink(t *testing.T) {
+	link := insteon.ControllerLink(1, insteon.Address{1, 2, 3})
+	tests := []struct {
+		desc       string
+		controller insteon.Linkable
+		responder  insteon.Linkable
+		want       *insteon.LinkRecord
+		wantErr    error
+	}{
+		{"Unconfirmed Devices", &testLinkable{}, &testLinkable{}, nil, nil},
+		{"Controller Confirmed", &testLinkCompleter{link: link}, &testLinkable{}, link, nil},
+		{"Responder Confirmed", &testLinkable{}, &testLinkCompleter{link: link}, link, nil},
+		{"Not Confirmed", &testLinkCompleter{err: insteon.ErrReadTimeout}, &testLinkable{}, nil, ErrLinkNotConfirmed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := ForceLink(1, test.controller, test.responder)
+			if err != test.wantErr {
+				t.Errorf("want error %v got %v", test.wantErr, err)
+			} else if test.want != got {
+				t.Errorf("want link %v got %v", test.want, got)
+			}
+		})
+	}
+}